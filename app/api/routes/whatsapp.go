@@ -1,14 +1,24 @@
 package routes
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/crm/pkg/constant"
 	"github.com/crm/pkg/domains/whatsapp"
 	"github.com/crm/pkg/dtos"
 	"github.com/crm/pkg/middleware"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 func WhatsAppRoutes(r *gin.RouterGroup, s whatsapp.Service) {
@@ -23,6 +33,65 @@ func WhatsAppRoutes(r *gin.RouterGroup, s whatsapp.Service) {
 		authGroup.POST("/check-connection", checkConnection(s))
 		authGroup.GET("/status", getStatus(s))
 		authGroup.GET("/contacts", getContacts(s))
+		authGroup.POST("/media-library", uploadLibraryMedia(s))
+		authGroup.GET("/media-library", listLibraryMedia(s))
+		authGroup.DELETE("/media-library/:id", deleteLibraryMedia(s))
+		authGroup.GET("/media/:id", getMessageMedia(s))
+		authGroup.POST("/media/:id/retry", retryMessageMedia(s))
+		authGroup.POST("/disappearing-timer", setDisappearingTimer(s))
+		authGroup.POST("/broadcasts", createBroadcast(s))
+		authGroup.GET("/broadcasts", listBroadcasts(s))
+		authGroup.GET("/broadcasts/:id", getBroadcast(s))
+		authGroup.GET("/broadcasts/:id/recipients", getBroadcastRecipients(s))
+		authGroup.POST("/broadcasts/:id/cancel", cancelBroadcast(s))
+		authGroup.GET("/schedules", listScheduledMessages(s))
+		authGroup.PUT("/schedules/:id", rescheduleMessage(s))
+		authGroup.POST("/schedules/:id/cancel", cancelScheduledMessage(s))
+		authGroup.GET("/outbox", listOutbox(s))
+		authGroup.GET("/outbox/:id", getOutboxMessage(s))
+		authGroup.POST("/outbox/:id/requeue", requeueOutboxMessage(s))
+		authGroup.POST("/templates", createTemplate(s))
+		authGroup.GET("/templates", listTemplates(s))
+		authGroup.GET("/templates/:id", getTemplate(s))
+		authGroup.PUT("/templates/:id", updateTemplate(s))
+		authGroup.DELETE("/templates/:id", deleteTemplate(s))
+		authGroup.GET("/pacing", getPacing(s))
+		authGroup.PUT("/pacing", updatePacing(s))
+		authGroup.POST("/presence", setPresence(s))
+		authGroup.POST("/chat-presence", setChatPresence(s))
+		authGroup.POST("/mark-read", markRead(s))
+		authGroup.GET("/messages", getMessages(s))
+		authGroup.POST("/forward", forwardMessage(s))
+		authGroup.GET("/chats", listChats(s))
+		authGroup.GET("/history-sync", listHistorySyncs(s))
+		authGroup.GET("/messages/search", searchMessages(s))
+		authGroup.GET("/export", exportChat(s))
+		authGroup.POST("/import", importChat(s))
+		authGroup.POST("/webhooks", createWebhook(s))
+		authGroup.GET("/webhooks", listWebhooks(s))
+		authGroup.PUT("/webhooks/:id", updateWebhook(s))
+		authGroup.DELETE("/webhooks/:id", deleteWebhook(s))
+		authGroup.GET("/webhooks/:id/deliveries", listWebhookDeliveries(s))
+		authGroup.POST("/webhook-deliveries/:id/redeliver", redeliverWebhook(s))
+		authGroup.POST("/chat-state", updateChatState(s))
+		authGroup.POST("/star", starMessage(s))
+		authGroup.GET("/labels", listLabels(s))
+		authGroup.POST("/labels", createLabel(s))
+		authGroup.PUT("/labels/:id", updateLabel(s))
+		authGroup.DELETE("/labels/:id", deleteLabel(s))
+		authGroup.POST("/labels/:id/assign", assignLabel(s))
+		authGroup.POST("/status-updates/text", postTextStatus(s))
+		authGroup.POST("/status-updates/media", postMediaStatus(s))
+		authGroup.GET("/status-updates", listStatusPosts(s))
+		authGroup.GET("/status-updates/audience", getStatusAudience(s))
+		authGroup.GET("/status-updates/feed", getStatusFeed(s))
+		authGroup.GET("/status-updates/:id/viewers", getStatusViewers(s))
+	}
+
+	// Browsers cannot send an Authorization header with EventSource or WebSocket requests
+	streamGroup := r.Group("", middleware.CheckStreamAuth())
+	{
+		streamGroup.GET("/events", streamEvents(s))
 	}
 }
 
@@ -55,60 +124,282 @@ func disconnect(s whatsapp.Service) func(c *gin.Context) {
 func sendMessage(s whatsapp.Service) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		var req dtos.SendMessageDTO
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Message == "" && req.TemplateID == 0) {
 			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
 			return
 		}
 
-		response, err := s.SendMessage(c, req)
+		fingerprint, err := requestFingerprint(c, req, nil)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		response, replayed, err := s.Idempotent(c, c.GetHeader(idempotencyKeyHeader), "send-message", fingerprint, func() (*dtos.MessageResponseDTO, error) {
+			return s.SendMessage(c, req)
+		})
+		if respondIdempotencyError(c, err) {
+			return
+		}
+		if errors.Is(err, whatsapp.ErrInvalidSchedule) || errors.Is(err, whatsapp.ErrInvalidTemplate) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, whatsapp.ErrTemplateNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, whatsapp.ErrDailyCapReached) {
+			c.JSON(429, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
+		respondSendResult(c, response, replayed, constant.MESSAGE_SENT)
+	}
+}
+
+// idempotencyKeyHeader lets clients retry sends without delivering the message twice
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// requestFingerprint hashes the payload an Idempotency-Key is bound to, including the spooled
+// media file if any. Nothing is hashed for requests without a key.
+func requestFingerprint(c *gin.Context, payload interface{}, media *os.File) (string, error) {
+	if c.GetHeader(idempotencyKeyHeader) == "" {
+		return "", nil
+	}
+
+	hash := sha256.New()
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	hash.Write(encoded)
+
+	if media != nil {
+		if _, err := io.Copy(hash, media); err != nil {
+			return "", fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+		}
+		if _, err := media.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// respondIdempotencyError answers key misuse: 400 for bad keys, 409 while the first request
+// is running and 422 when the key was used for a different payload. It reports whether it responded.
+func respondIdempotencyError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, whatsapp.ErrIdempotencyKeyInvalid):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrIdempotencyKeyInProgress):
+		c.JSON(409, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrIdempotencyKeyMismatch):
+		c.JSON(422, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// respondSendResult answers 202 for scheduled and queued sends and 200 with sentMessage otherwise
+func respondSendResult(c *gin.Context, response *dtos.MessageResponseDTO, replayed bool, sentMessage string) {
+	if replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+
+	switch {
+	case response.ScheduleID != 0:
+		c.JSON(202, gin.H{
+			"message": constant.MESSAGE_SCHEDULED,
+			"data":    response,
+		})
+	case response.OutboxID != 0:
+		c.JSON(202, gin.H{
+			"message": constant.MESSAGE_QUEUED,
+			"data":    response,
+		})
+	default:
 		c.JSON(200, gin.H{
-			"message": constant.MESSAGE_SENT,
+			"message": sentMessage,
 			"data":    response,
 		})
 	}
 }
 
+// multipartOverhead allows for form fields and boundaries around the media part
+const multipartOverhead = 1 << 20
+
+// maxFormField caps each non-file form field, enough for a caption or a JSON field
+const maxFormField = 64 << 10
+
+// mediaForm is a streamed multipart form whose "media" part, if any, was spooled to a temp file
+type mediaForm struct {
+	fields   map[string]string
+	file     *os.File
+	fileName string
+	fileSize int64
+}
+
+func (f *mediaForm) cleanup() {
+	if f.file != nil {
+		f.file.Close()
+		os.Remove(f.file.Name())
+	}
+}
+
+// readMediaForm streams the multipart body part by part so the file goes straight to disk.
+// It writes the error response itself and returns nil when the form cannot be read.
+func readMediaForm(c *gin.Context, s whatsapp.Service) *mediaForm {
+	// Reject bodies no media type could fit before reading anything; the file part is
+	// capped for its own type while it is spooled and re-checked below
+	maxBody := s.MediaSizeLimit("") + multipartOverhead
+	if c.Request.ContentLength > maxBody {
+		c.JSON(413, gin.H{"error": constant.MEDIA_TOO_LARGE})
+		return nil
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody)
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(400, gin.H{"error": "multipart form data is required"})
+		return nil
+	}
+
+	form := &mediaForm{fields: map[string]string{}}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			form.cleanup()
+			respondMediaError(c, err, 400, "Failed to read form data")
+			return nil
+		}
+
+		if part.FormName() != "media" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormField+1))
+			if err != nil {
+				form.cleanup()
+				respondMediaError(c, err, 400, "Failed to read form data")
+				return nil
+			}
+			if len(value) > maxFormField {
+				form.cleanup()
+				c.JSON(400, gin.H{"error": fmt.Sprintf("form field %s is longer than %d bytes", part.FormName(), maxFormField)})
+				return nil
+			}
+			form.fields[part.FormName()] = string(value)
+			continue
+		}
+
+		if form.file != nil {
+			form.cleanup()
+			c.JSON(400, gin.H{"error": "only one media file is allowed"})
+			return nil
+		}
+
+		// Fields sent before the file decide the cap; fall back to the part's own type
+		mimeType := form.fields["mime_type"]
+		if mimeType == "" {
+			mimeType = part.Header.Get("Content-Type")
+		}
+
+		form.fileName = part.FileName()
+		form.file, form.fileSize, err = s.SpoolMedia(part, mimeType)
+		if err != nil {
+			respondMediaError(c, err, 500, constant.FILE_READ_FAILED)
+			return nil
+		}
+	}
+
+	// Re-check in case mime_type arrived after the file
+	if form.file != nil && form.fields["mime_type"] != "" && form.fileSize > s.MediaSizeLimit(form.fields["mime_type"]) {
+		form.cleanup()
+		c.JSON(413, gin.H{"error": constant.MEDIA_TOO_LARGE})
+		return nil
+	}
+
+	return form
+}
+
 func sendMediaMessage(s whatsapp.Service) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		// Get form data
-		phoneNumber := c.PostForm("phone_number")
-		caption := c.PostForm("caption")
-		mimeType := c.PostForm("mime_type")
-		height := c.PostForm("height")
-		width := c.PostForm("width")
+		if c.ContentType() == "application/json" {
+			sendMediaJSON(s, c)
+			return
+		}
 
-		if phoneNumber == "" || mimeType == "" {
-			c.JSON(400, gin.H{"error": "phone_number and mime_type are required"})
+		form := readMediaForm(c, s)
+		if form == nil {
 			return
 		}
+		defer form.cleanup()
 
-		// Get uploaded file
-		file, header, err := c.Request.FormFile("media")
-		if err != nil {
-			c.JSON(400, gin.H{"error": "Failed to get uploaded file"})
+		phoneNumber := form.fields["phone_number"]
+		mimeType := form.fields["mime_type"]
+		height := form.fields["height"]
+		width := form.fields["width"]
+
+		var mediaID uint
+		if raw := form.fields["media_id"]; raw != "" {
+			id, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil || id == 0 {
+				c.JSON(400, gin.H{"error": "Invalid media_id value"})
+				return
+			}
+			mediaID = uint(id)
+		}
+
+		if phoneNumber == "" || (mimeType == "" && mediaID == 0) {
+			c.JSON(400, gin.H{"error": "phone_number and mime_type are required"})
 			return
 		}
-		defer file.Close()
 
-		// Read file data
-		mediaData, err := io.ReadAll(file)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to read file data"})
+		if (form.file == nil) == (mediaID == 0) {
+			c.JSON(400, gin.H{"error": "either a media file or media_id is required"})
 			return
 		}
 
 		// Create DTO
 		req := dtos.SendMediaMessageDTO{
 			PhoneNumber: phoneNumber,
-			Caption:     caption,
-			MediaData:   mediaData,
+			Caption:     form.fields["caption"],
+			MediaID:     mediaID,
+			FileName:    form.fileName,
+			FileSize:    form.fileSize,
 			MimeType:    mimeType,
 		}
+		if form.file != nil {
+			req.Media = form.file
+		}
+		req.ViewOnce = form.fields["view_once"] == "true"
+		req.Voice = form.fields["voice"] == "true"
+		req.Async = form.fields["async"] == "true"
+		req.Transactional = form.fields["transactional"] == "true"
+		req.Language = form.fields["language"]
+		if raw := form.fields["template_id"]; raw != "" {
+			id, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil || id == 0 {
+				c.JSON(400, gin.H{"error": "Invalid template_id value"})
+				return
+			}
+			req.TemplateID = uint(id)
+		}
+		if raw := form.fields["variables"]; raw != "" {
+			if err := json.Unmarshal([]byte(raw), &req.Variables); err != nil {
+				c.JSON(400, gin.H{"error": "variables must be a JSON object of strings"})
+				return
+			}
+		}
+		req.SendAt = form.fields["send_at"]
+		req.Timezone = form.fields["timezone"]
+		req.Recurrence = form.fields["recurrence"]
 
 		// Parse height and width if provided
 		if height != "" {
@@ -124,94 +415,1547 @@ func sendMediaMessage(s whatsapp.Service) func(c *gin.Context) {
 			}
 		}
 
-		response, err := s.SendMediaMessage(c, req)
+		fingerprint, err := requestFingerprint(c, req, form.file)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(200, gin.H{
-			"message": fmt.Sprintf("%s. File: %s", constant.MEDIA_SENT, header.Filename),
-			"data":    response,
+		response, replayed, err := s.Idempotent(c, c.GetHeader(idempotencyKeyHeader), "send-media", fingerprint, func() (*dtos.MessageResponseDTO, error) {
+			return s.SendMediaMessage(c, req)
+		})
+		if respondIdempotencyError(c, err) {
+			return
+		}
+		if err != nil {
+			respondMediaError(c, err, 500, err.Error())
+			return
+		}
+
+		respondSendResult(c, response, replayed, fmt.Sprintf("%s. File: %s", constant.MEDIA_SENT, req.FileName))
+	}
+}
+
+func uploadLibraryMedia(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		form := readMediaForm(c, s)
+		if form == nil {
+			return
+		}
+		defer form.cleanup()
+
+		mimeType := form.fields["mime_type"]
+		if mimeType == "" || form.file == nil {
+			c.JSON(400, gin.H{"error": "media file and mime_type are required"})
+			return
+		}
+
+		media, err := s.UploadLibraryMedia(c, dtos.LibraryUploadDTO{
+			Media:    form.file,
+			FileName: form.fileName,
+			FileSize: form.fileSize,
+			MimeType: mimeType,
+		})
+		if err != nil {
+			respondMediaError(c, err, 500, err.Error())
+			return
+		}
+
+		c.JSON(201, gin.H{
+			"message": constant.MEDIA_STORED,
+			"data":    media,
 		})
 	}
 }
 
-func getQRCode(s whatsapp.Service) func(c *gin.Context) {
+func listLibraryMedia(s whatsapp.Service) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		qrCode, err := s.GetQRCode(c)
+		media, err := s.ListLibraryMedia(c)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
+		c.JSON(200, gin.H{"media": media})
+	}
+}
+
+func deleteLibraryMedia(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.DeleteLibraryMedia(c, uint(id)); err != nil {
+			respondMediaError(c, err, 500, err.Error())
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.DELETED})
+	}
+}
+
+func getMessageMedia(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		media, content, err := s.GetMessageMedia(c, uint(id))
+		if err != nil {
+			respondMediaError(c, err, 500, err.Error())
+			return
+		}
+
+		// Not downloaded (yet): report the record status instead of content
+		if content == nil {
+			c.JSON(409, gin.H{
+				"error": constant.MEDIA_NOT_AVAILABLE,
+				"data":  media,
+			})
+			return
+		}
+		defer content.Close()
+
+		fileName := media.FileName
+		if fileName == "" {
+			fileName = fmt.Sprintf("%s-%s", media.Kind, media.MessageID)
+		}
+		mimeType := media.MimeType
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+
+		c.DataFromReader(200, int64(media.FileLength), mimeType, content, map[string]string{
+			"Content-Disposition": mime.FormatMediaType("inline", map[string]string{"filename": fileName}),
+		})
+	}
+}
+
+func retryMessageMedia(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		media, err := s.RetryMessageMedia(c, uint(id))
+		if err != nil {
+			respondMediaError(c, err, 500, err.Error())
+			return
+		}
+
 		c.JSON(200, gin.H{
-			"qr_code": qrCode,
-			"message": "Scan this QR code with WhatsApp mobile app",
+			"message": constant.MEDIA_RETRY_REQUESTED,
+			"data":    media,
 		})
 	}
 }
 
-func checkConnection(s whatsapp.Service) func(c *gin.Context) {
+// sendMediaJSON handles the JSON variant of /send-media with base64 data or a media URL
+func sendMediaJSON(s whatsapp.Service, c *gin.Context) {
+	// Base64 inflates the payload by a third
+	maxBody := s.MediaSizeLimit("")*4/3 + multipartOverhead
+	if c.Request.ContentLength > maxBody {
+		c.JSON(413, gin.H{"error": constant.MEDIA_TOO_LARGE})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody)
+
+	var req dtos.SendMediaJSONDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondMediaError(c, err, 400, constant.INVALID_REQUEST)
+		return
+	}
+
+	fingerprint, err := requestFingerprint(c, req, nil)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, replayed, err := s.Idempotent(c, c.GetHeader(idempotencyKeyHeader), "send-media", fingerprint, func() (*dtos.MessageResponseDTO, error) {
+		return s.SendMediaFromJSON(c, req)
+	})
+	if respondIdempotencyError(c, err) {
+		return
+	}
+	if errors.Is(err, whatsapp.ErrInvalidMediaSource) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		respondMediaError(c, err, 500, err.Error())
+		return
+	}
+
+	respondSendResult(c, response, replayed, constant.MEDIA_SENT)
+}
+
+// respondMediaError maps size limit violations to 413, unknown media or templates to 404, an exhausted
+// daily cap to 429, unsupported send options, invalid schedules and templates to 400 and everything else to the given status
+func respondMediaError(c *gin.Context, err error, status int, message string) {
+	if errors.Is(err, whatsapp.ErrDailyCapReached) {
+		c.JSON(429, gin.H{"error": err.Error()})
+		return
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, whatsapp.ErrMediaTooLarge) || errors.As(err, &maxBytesErr) {
+		c.JSON(413, gin.H{"error": constant.MEDIA_TOO_LARGE})
+		return
+	}
+	if errors.Is(err, whatsapp.ErrMediaNotFound) || errors.Is(err, whatsapp.ErrTemplateNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, whatsapp.ErrViewOnceNotSupported) || errors.Is(err, whatsapp.ErrInvalidSchedule) ||
+		errors.Is(err, whatsapp.ErrInvalidTemplate) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(status, gin.H{"error": message})
+}
+
+func setDisappearingTimer(s whatsapp.Service) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		var req struct {
-			PhoneNumber string `json:"phone_number" binding:"required"`
+		var req dtos.DisappearingTimerDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.SetDisappearingTimer(c, req); err != nil {
+			respondChatError(c, err)
+			return
 		}
 
+		c.JSON(200, gin.H{"message": constant.DISAPPEARING_TIMER_SET})
+	}
+}
+
+func createBroadcast(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.CreateBroadcastDTO
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
 			return
 		}
 
-		connected, err := s.CheckConnection(c, req.PhoneNumber)
+		job, err := s.CreateBroadcast(c, req)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			respondBroadcastError(c, err)
 			return
 		}
 
-		c.JSON(200, dtos.CheckConnectionDTO{
-			PhoneNumber: req.PhoneNumber,
-			Connected:   connected,
+		c.JSON(202, gin.H{
+			"message": constant.BROADCAST_CREATED,
+			"data":    job,
 		})
 	}
 }
 
-func getStatus(s whatsapp.Service) func(c *gin.Context) {
+func listBroadcasts(s whatsapp.Service) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		status, err := s.GetStatus(c)
+		jobs, err := s.ListBroadcasts(c)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(200, dtos.WhatsAppStatusDTO{
-			Status: status,
-		})
+		c.JSON(200, gin.H{"broadcasts": jobs})
 	}
 }
 
-func getContacts(s whatsapp.Service) func(c *gin.Context) {
+func getBroadcast(s whatsapp.Service) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		contacts, err := s.GetContacts(c)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
 			return
 		}
 
-		var contactDTOs []dtos.ContactInfoDTO
-		for jid, contact := range contacts {
-			contactDTOs = append(contactDTOs, dtos.ContactInfoDTO{
-				JID:          jid.String(), // Proper JID string
-				Name:         contact.PushName,
-				FirstName:    contact.FirstName,
-				FullName:     contact.FullName,
-				BusinessName: contact.BusinessName, // Business contact support
-				Found:        contact.Found,        // Contact found status
-			})
+		job, err := s.GetBroadcast(c, uint(id))
+		if err != nil {
+			respondBroadcastError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"data": job})
+	}
+}
+
+func getBroadcastRecipients(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_PAGE_NUMBER})
+			return
+		}
+
+		recipients, totalPages, err := s.GetBroadcastRecipients(c, uint(id), c.Query("status"), page)
+		if err != nil {
+			respondBroadcastError(c, err)
+			return
 		}
 
 		c.JSON(200, gin.H{
-			"contacts": contactDTOs,
+			"recipients":  recipients,
+			"page":        page,
+			"total_pages": totalPages,
 		})
 	}
 }
+
+func cancelBroadcast(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.CancelBroadcast(c, uint(id)); err != nil {
+			respondBroadcastError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.BROADCAST_CANCELLED})
+	}
+}
+
+// respondBroadcastError maps broadcast validation errors to 400 and unknown jobs or media to 404
+func respondBroadcastError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, whatsapp.ErrInvalidBroadcast), err.Error() == constant.PAGE_NUMBER_OUT_OF_RANGE:
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrBroadcastNotFound), errors.Is(err, whatsapp.ErrMediaNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
+func listScheduledMessages(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		schedules, err := s.ListScheduledMessages(c, c.Query("status"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"schedules": schedules})
+	}
+}
+
+func rescheduleMessage(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		var req dtos.RescheduleDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		schedule, err := s.RescheduleMessage(c, uint(id), req)
+		if err != nil {
+			respondScheduleError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.SCHEDULE_UPDATED,
+			"data":    schedule,
+		})
+	}
+}
+
+func cancelScheduledMessage(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.CancelScheduledMessage(c, uint(id)); err != nil {
+			respondScheduleError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.SCHEDULE_CANCELLED})
+	}
+}
+
+// respondScheduleError maps invalid schedules and state conflicts to 400 and unknown messages to 404
+func respondScheduleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, whatsapp.ErrInvalidSchedule):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrScheduleNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
+func listOutbox(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_PAGE_NUMBER})
+			return
+		}
+
+		items, totalPages, err := s.ListOutbox(c, c.Query("status"), page)
+		if err != nil {
+			respondOutboxError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"messages":    items,
+			"page":        page,
+			"total_pages": totalPages,
+		})
+	}
+}
+
+func getOutboxMessage(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		item, err := s.GetOutboxMessage(c, uint(id))
+		if err != nil {
+			respondOutboxError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"data": item})
+	}
+}
+
+func requeueOutboxMessage(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.RequeueOutboxMessage(c, uint(id)); err != nil {
+			respondOutboxError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.OUTBOX_REQUEUED})
+	}
+}
+
+// respondOutboxError maps paging and state conflicts to 400 and unknown messages to 404
+func respondOutboxError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, whatsapp.ErrOutboxNotDead), err.Error() == constant.PAGE_NUMBER_OUT_OF_RANGE:
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrOutboxNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
+func createTemplate(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.TemplateDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		tmpl, err := s.CreateTemplate(c, req)
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(201, gin.H{
+			"message": constant.TEMPLATE_CREATED,
+			"data":    tmpl,
+		})
+	}
+}
+
+func listTemplates(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		templates, err := s.ListTemplates(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"templates": templates})
+	}
+}
+
+func getTemplate(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		tmpl, err := s.GetTemplate(c, uint(id))
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"data": tmpl})
+	}
+}
+
+func updateTemplate(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		var req dtos.TemplateDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		tmpl, err := s.UpdateTemplate(c, uint(id), req)
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.TEMPLATE_UPDATED,
+			"data":    tmpl,
+		})
+	}
+}
+
+func deleteTemplate(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.DeleteTemplate(c, uint(id)); err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.TEMPLATE_DELETED})
+	}
+}
+
+// respondTemplateError maps validation errors to 400 and unknown templates to 404
+func respondTemplateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, whatsapp.ErrInvalidTemplate):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrTemplateNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
+func getPacing(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		settings, err := s.GetPacing(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"data": settings})
+	}
+}
+
+func updatePacing(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.PacingDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		settings, err := s.UpdatePacing(c, req)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.PACING_UPDATED,
+			"data":    settings,
+		})
+	}
+}
+
+func getQRCode(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		qrCode, err := s.GetQRCode(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"qr_code": qrCode,
+			"message": "Scan this QR code with WhatsApp mobile app",
+		})
+	}
+}
+
+func checkConnection(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req struct {
+			PhoneNumber string `json:"phone_number" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		connected, err := s.CheckConnection(c, req.PhoneNumber)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, dtos.CheckConnectionDTO{
+			PhoneNumber: req.PhoneNumber,
+			Connected:   connected,
+		})
+	}
+}
+
+func getStatus(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		status, err := s.GetStatus(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, dtos.WhatsAppStatusDTO{
+			Status: status,
+		})
+	}
+}
+
+func getContacts(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		contacts, err := s.GetContacts(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		var contactDTOs []dtos.ContactInfoDTO
+		for jid, contact := range contacts {
+			contactDTOs = append(contactDTOs, dtos.ContactInfoDTO{
+				JID:          jid.String(), // Proper JID string
+				Name:         contact.PushName,
+				FirstName:    contact.FirstName,
+				FullName:     contact.FullName,
+				BusinessName: contact.BusinessName, // Business contact support
+				Found:        contact.Found,        // Contact found status
+			})
+		}
+
+		c.JSON(200, gin.H{
+			"contacts": contactDTOs,
+		})
+	}
+}
+
+func setPresence(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.PresenceDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.SetPresence(c, req); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.PRESENCE_UPDATED})
+	}
+}
+
+func setChatPresence(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.ChatPresenceDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.SetChatPresence(c, req); err != nil {
+			respondChatError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.PRESENCE_UPDATED})
+	}
+}
+
+func markRead(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.MarkReadDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		marked, err := s.MarkRead(c, req)
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.MESSAGES_MARKED_READ,
+			"data":    gin.H{"marked": marked},
+		})
+	}
+}
+
+func getMessages(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_PAGE_NUMBER})
+			return
+		}
+
+		messages, totalPages, err := s.GetMessages(c, dtos.MessageHistoryDTO{
+			PhoneNumber: c.Query("phone_number"),
+			ChatJID:     c.Query("chat_jid"),
+			Page:        page,
+			MarkRead:    c.Query("mark_read") == "true",
+		})
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message":     constant.MESSAGES_RETRIEVED,
+			"messages":    messages,
+			"page":        page,
+			"total_pages": totalPages,
+		})
+	}
+}
+
+// respondChatError maps errors of chat-level operations to HTTP statuses
+func respondChatError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, whatsapp.ErrInvalidChat), errors.Is(err, whatsapp.ErrInvalidSearch),
+		errors.Is(err, whatsapp.ErrInvalidExport), errors.Is(err, whatsapp.ErrInvalidImport),
+		err.Error() == constant.PAGE_NUMBER_OUT_OF_RANGE:
+		c.JSON(400, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
+func postTextStatus(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.StatusTextDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		post, err := s.PostTextStatus(c, req)
+		if err != nil {
+			respondStatusError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.STATUS_POSTED,
+			"data":    post,
+		})
+	}
+}
+
+func postMediaStatus(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		form := readMediaForm(c, s)
+		if form == nil {
+			return
+		}
+		defer form.cleanup()
+
+		req := dtos.StatusMediaDTO{
+			Caption:  form.fields["caption"],
+			FileName: form.fileName,
+			FileSize: form.fileSize,
+			MimeType: form.fields["mime_type"],
+			Audience: form.fields["audience"],
+		}
+		if raw := form.fields["media_id"]; raw != "" {
+			id, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil || id == 0 {
+				c.JSON(400, gin.H{"error": "Invalid media_id value"})
+				return
+			}
+			req.MediaID = uint(id)
+		}
+		if form.file != nil {
+			req.Media = form.file
+		}
+
+		if (form.file == nil) == (req.MediaID == 0) {
+			c.JSON(400, gin.H{"error": "either a media file or media_id is required"})
+			return
+		}
+		if req.MimeType == "" && req.MediaID == 0 {
+			c.JSON(400, gin.H{"error": "mime_type is required"})
+			return
+		}
+		switch req.Audience {
+		case "", "contacts", "whitelist", "blacklist":
+		default:
+			c.JSON(400, gin.H{"error": "audience must be contacts, whitelist or blacklist"})
+			return
+		}
+
+		post, err := s.PostMediaStatus(c, req)
+		if err != nil {
+			respondStatusError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.STATUS_POSTED,
+			"data":    post,
+		})
+	}
+}
+
+func listStatusPosts(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_PAGE_NUMBER})
+			return
+		}
+
+		posts, totalPages, err := s.ListStatusPosts(c, page)
+		if err != nil {
+			respondStatusError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"posts":       posts,
+			"page":        page,
+			"total_pages": totalPages,
+		})
+	}
+}
+
+func getStatusAudience(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		audience, err := s.GetStatusAudience(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"data": audience})
+	}
+}
+
+func getStatusFeed(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_PAGE_NUMBER})
+			return
+		}
+
+		feed, totalPages, err := s.GetStatusFeed(c, c.Query("sender"), page)
+		if err != nil {
+			respondStatusError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"statuses":    feed,
+			"page":        page,
+			"total_pages": totalPages,
+		})
+	}
+}
+
+func getStatusViewers(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		viewers, err := s.GetStatusViewers(c, uint(id))
+		if err != nil {
+			respondStatusError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"viewers": viewers})
+	}
+}
+
+// respondStatusError maps status post errors to HTTP statuses
+func respondStatusError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, whatsapp.ErrInvalidStatus), err.Error() == constant.PAGE_NUMBER_OUT_OF_RANGE:
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrStatusAudienceMismatch):
+		c.JSON(409, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrStatusPostNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	default:
+		respondMediaError(c, err, 500, err.Error())
+	}
+}
+
+func forwardMessage(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.ForwardMessageDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		results, err := s.ForwardMessage(c, req)
+		switch {
+		case errors.Is(err, whatsapp.ErrForwardNotSupported):
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, whatsapp.ErrMessageNotFound):
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			respondMediaError(c, err, 500, err.Error())
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.MESSAGE_FORWARDED,
+			"data":    results,
+		})
+	}
+}
+
+func updateChatState(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.ChatStateDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		chat, err := s.UpdateChatState(c, req)
+		if err != nil {
+			respondAppStateError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.CHAT_STATE_UPDATED,
+			"data":    chat,
+		})
+	}
+}
+
+func starMessage(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.StarMessageDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.StarMessage(c, req); err != nil {
+			respondAppStateError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.MESSAGE_STARRED})
+	}
+}
+
+func listLabels(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		labels, err := s.ListLabels(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"labels": labels})
+	}
+}
+
+func createLabel(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.LabelDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		label, err := s.CreateLabel(c, req)
+		if err != nil {
+			respondAppStateError(c, err)
+			return
+		}
+
+		c.JSON(201, gin.H{
+			"message": constant.LABEL_CREATED,
+			"data":    label,
+		})
+	}
+}
+
+func updateLabel(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.LabelDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		label, err := s.UpdateLabel(c, c.Param("id"), req)
+		if err != nil {
+			respondAppStateError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.LABEL_UPDATED,
+			"data":    label,
+		})
+	}
+}
+
+func deleteLabel(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if err := s.DeleteLabel(c, c.Param("id")); err != nil {
+			respondAppStateError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.LABEL_DELETED})
+	}
+}
+
+func assignLabel(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.LabelAssignDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.AssignLabel(c, c.Param("id"), req); err != nil {
+			respondAppStateError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.LABEL_ASSIGNED})
+	}
+}
+
+// respondAppStateError maps chat organisation errors to HTTP statuses
+func respondAppStateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, whatsapp.ErrInvalidChat):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrLabelNotFound), errors.Is(err, whatsapp.ErrMessageNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
+func listChats(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_PAGE_NUMBER})
+			return
+		}
+
+		req := dtos.ChatListDTO{Page: page}
+		if raw := c.Query("archived"); raw != "" {
+			archived, err := strconv.ParseBool(raw)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "archived must be true or false"})
+				return
+			}
+			req.Archived = &archived
+		}
+
+		chats, totalPages, err := s.ListChats(c, req)
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"chats":       chats,
+			"page":        page,
+			"total_pages": totalPages,
+		})
+	}
+}
+
+func listHistorySyncs(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_PAGE_NUMBER})
+			return
+		}
+
+		chunks, totalPages, err := s.ListHistorySyncs(c, page)
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message":     constant.HISTORY_SYNCS_RETRIEVED,
+			"chunks":      chunks,
+			"page":        page,
+			"total_pages": totalPages,
+		})
+	}
+}
+
+func searchMessages(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_PAGE_NUMBER})
+			return
+		}
+
+		results, totalPages, err := s.SearchMessages(c, dtos.MessageSearchDTO{
+			Query:     c.Query("q"),
+			ChatJID:   c.Query("chat_jid"),
+			Contact:   c.Query("contact"),
+			From:      c.Query("from"),
+			To:        c.Query("to"),
+			Direction: c.Query("direction"),
+			Language:  c.Query("language"),
+			Page:      page,
+		})
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message":     constant.MESSAGES_FOUND,
+			"results":     results,
+			"page":        page,
+			"total_pages": totalPages,
+		})
+	}
+}
+
+func exportChat(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		file, content, err := s.ExportChat(c, dtos.ExportDTO{
+			PhoneNumber:  c.Query("phone_number"),
+			ChatJID:      c.Query("chat_jid"),
+			From:         c.Query("from"),
+			To:           c.Query("to"),
+			Format:       c.Query("format"),
+			TimeZone:     c.Query("tz"),
+			IncludeMedia: c.Query("media") == "true",
+		})
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+		// Closing stops the export when the client goes away mid-download
+		defer content.Close()
+
+		c.DataFromReader(200, -1, file.ContentType, content, map[string]string{
+			"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": file.FileName}),
+		})
+	}
+}
+
+func importChat(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		form := readMediaForm(c, s)
+		if form == nil {
+			return
+		}
+		defer form.cleanup()
+
+		if form.file == nil {
+			c.JSON(400, gin.H{"error": "an exported .txt or .zip file is required"})
+			return
+		}
+
+		req := dtos.ImportDTO{
+			File:        form.file,
+			FileSize:    form.fileSize,
+			PhoneNumber: form.fields["phone_number"],
+			ChatJID:     form.fields["chat_jid"],
+			DateOrder:   form.fields["date_order"],
+			TimeZone:    form.fields["tz"],
+			SelfName:    form.fields["self_name"],
+		}
+		if raw := form.fields["senders"]; raw != "" {
+			if err := json.Unmarshal([]byte(raw), &req.Senders); err != nil {
+				c.JSON(400, gin.H{"error": "senders must be a JSON object of name to phone number or JID"})
+				return
+			}
+		}
+
+		result, err := s.ImportChat(c, req)
+		if err != nil {
+			respondChatError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.CHAT_IMPORTED,
+			"data":    result,
+		})
+	}
+}
+
+func createWebhook(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var req dtos.WebhookDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		hook, err := s.CreateWebhook(c, req)
+		if err != nil {
+			respondWebhookError(c, err)
+			return
+		}
+
+		// The secret is only ever shown here; subscribers need it to verify signatures
+		c.JSON(201, gin.H{
+			"message": constant.WEBHOOK_CREATED,
+			"data":    hook,
+			"secret":  hook.Secret,
+		})
+	}
+}
+
+func listWebhooks(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		hooks, err := s.ListWebhooks(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"webhooks": hooks})
+	}
+}
+
+func updateWebhook(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		var req dtos.WebhookDTO
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		hook, err := s.UpdateWebhook(c, uint(id), req)
+		if err != nil {
+			respondWebhookError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": constant.WEBHOOK_UPDATED,
+			"data":    hook,
+		})
+	}
+}
+
+func deleteWebhook(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		if err := s.DeleteWebhook(c, uint(id)); err != nil {
+			respondWebhookError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"message": constant.WEBHOOK_DELETED})
+	}
+}
+
+func listWebhookDeliveries(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_PAGE_NUMBER})
+			return
+		}
+
+		deliveries, totalPages, err := s.ListWebhookDeliveries(c, uint(id), c.Query("status"), page)
+		if err != nil {
+			respondWebhookError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"deliveries":  deliveries,
+			"page":        page,
+			"total_pages": totalPages,
+		})
+	}
+}
+
+func redeliverWebhook(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+			return
+		}
+
+		delivery, err := s.RedeliverWebhook(c, uint(id))
+		if err != nil {
+			respondWebhookError(c, err)
+			return
+		}
+
+		c.JSON(202, gin.H{
+			"message": constant.WEBHOOK_REDELIVERY_QUEUED,
+			"data":    delivery,
+		})
+	}
+}
+
+// respondWebhookError maps invalid subscriptions to 400 and unknown webhooks or deliveries to 404
+func respondWebhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, whatsapp.ErrInvalidWebhook), err.Error() == constant.PAGE_NUMBER_OUT_OF_RANGE:
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrWebhookNotFound), errors.Is(err, whatsapp.ErrWebhookDeliveryNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
+const (
+	// streamHeartbeat keeps idle event streams from being closed by proxies
+	streamHeartbeat = 25 * time.Second
+	// streamWriteTimeout drops WebSocket clients that stop reading
+	streamWriteTimeout = 10 * time.Second
+)
+
+// streamUpgrader accepts WebSocket connections from any origin; the stream is authorized
+// by bearer token rather than cookies, so cross-site requests carry no credentials
+var streamUpgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// streamEvents streams the caller's events over WebSocket, or as Server-Sent Events for
+// plain requests. Clients resume with the Last-Event-ID header or last_event_id parameter.
+func streamEvents(s whatsapp.Service) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		lastEventID := c.GetHeader("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = c.Query("last_event_id")
+		}
+		var after uint64
+		if lastEventID != "" {
+			id, err := strconv.ParseUint(lastEventID, 10, 64)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constant.INVALID_REQUEST})
+				return
+			}
+			after = id
+		}
+
+		sub, err := s.SubscribeEvents(c, after)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		defer sub.Close()
+
+		if websocket.IsWebSocketUpgrade(c.Request) {
+			streamWebSocket(c, sub)
+			return
+		}
+		streamSSE(c, sub)
+	}
+}
+
+func streamSSE(c *gin.Context, sub *whatsapp.EventSubscription) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+
+	write := func(event dtos.StreamEventDTO) bool {
+		data, _ := json.Marshal(event)
+		if event.ID != 0 {
+			fmt.Fprintf(c.Writer, "id: %d\n", event.ID)
+		}
+		_, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Type, data)
+		c.Writer.Flush()
+		return err == nil
+	}
+
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+	for _, event := range sub.Replay {
+		if !write(event) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok || !write(event) {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+func streamWebSocket(c *gin.Context, sub *whatsapp.EventSubscription) {
+	conn, err := streamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied with an error status
+		return
+	}
+	defer conn.Close()
+
+	// The stream is one-way; reading only serves to notice when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(event dtos.StreamEventDTO) bool {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(event) == nil
+	}
+
+	for _, event := range sub.Replay {
+		if !write(event) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// Too far behind; the client reconnects with its last event ID
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber too slow"),
+					time.Now().Add(streamWriteTimeout))
+				return
+			}
+			if !write(event) {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -11,5 +11,5 @@ func StartApp() {
 	config := config.InitConfig()
 	utils.LoadEnv()
 	database.InitDB(config.Database)
-	server.LaunchHttpServer(config.App, config.Allows)
+	server.LaunchHttpServer(config.App, config.Allows, config.WhatsApp, config.Storage)
 }
@@ -11,6 +11,8 @@ type Config struct {
 	App      App      `yaml:"app"`
 	Database Database `yaml:"database"`
 	Allows   Allows   `yaml:"allows"`
+	WhatsApp WhatsApp `yaml:"whatsapp"`
+	Storage  Storage  `yaml:"storage"`
 }
 
 type App struct {
@@ -33,6 +35,129 @@ type Allows struct {
 	Headers []string `yaml:"headers"`
 }
 
+type WhatsApp struct {
+	Media     Media     `yaml:"media"`
+	Retention Retention `yaml:"retention"`
+	Broadcast Broadcast `yaml:"broadcast"`
+	Scheduler Scheduler `yaml:"scheduler"`
+	Outbox    Outbox    `yaml:"outbox"`
+	Pacing    Pacing    `yaml:"pacing"`
+	Status    Status    `yaml:"status"`
+	History   History   `yaml:"history"`
+	Search    Search    `yaml:"search"`
+	Webhooks  Webhooks  `yaml:"webhooks"`
+	Stream    Stream    `yaml:"stream"`
+	Inbound   Inbound   `yaml:"inbound"`
+
+	IdempotencyTTLHours int `yaml:"idempotency_ttl_hours"` // How long Idempotency-Key responses are replayed
+}
+
+// Pacing holds the default anti-ban send pacing; accounts can override it via the API
+type Pacing struct {
+	Enabled             bool `yaml:"enabled"`
+	MinIntervalMs       int  `yaml:"min_interval_ms"`       // Minimum gap between any two sends of a session
+	RecipientIntervalMs int  `yaml:"recipient_interval_ms"` // Minimum gap between two sends to the same chat
+	JitterMs            int  `yaml:"jitter_ms"`             // Random extra delay added to every send
+	Typing              bool `yaml:"typing"`                // Show "composing" before sending
+	TypingMsPerChar     int  `yaml:"typing_ms_per_char"`
+	MaxTypingMs         int  `yaml:"max_typing_ms"`
+	DailyCap            int  `yaml:"daily_cap"` // Sends per day, 0 for unlimited
+}
+
+// Status controls WhatsApp Status posting
+type Status struct {
+	// Expected status privacy of the account: contacts, whitelist or blacklist.
+	// Posts are refused when the phone's setting differs; empty accepts any.
+	Audience string `yaml:"audience"`
+}
+
+// History controls the import of past conversations the phone sends after pairing
+type History struct {
+	MaxDays       int  `yaml:"max_days"`       // Messages older than this are skipped, 0 imports everything
+	DownloadMedia bool `yaml:"download_media"` // Download attachments of imported messages instead of only recording them
+}
+
+// Search configures full-text search over stored messages
+type Search struct {
+	Language string `yaml:"language"` // Postgres text search configuration, e.g. english, turkish or simple
+}
+
+// Webhooks tunes delivery of event notifications to subscriber URLs
+type Webhooks struct {
+	PollIntervalMs int  `yaml:"poll_interval_ms"`
+	TimeoutSec     int  `yaml:"timeout_sec"`
+	MaxAttempts    int  `yaml:"max_attempts"` // Attempts before a delivery is given up
+	BaseBackoffMs  int  `yaml:"base_backoff_ms"`
+	MaxBackoffSec  int  `yaml:"max_backoff_sec"`
+	AllowPrivate   bool `yaml:"allow_private"` // Allow subscriber URLs on internal networks
+}
+
+// Stream tunes the real-time event stream
+type Stream struct {
+	ReplaySize int `yaml:"replay_size"` // Events kept per user for Last-Event-ID resume
+}
+
+// Inbound tunes the per-session queue between whatsmeow's event dispatch and the inbound pipeline
+type Inbound struct {
+	QueueSize      int    `yaml:"queue_size"`
+	Overflow       string `yaml:"overflow"`         // spill, drop_oldest or block when the queue is full
+	BlockTimeoutMs int    `yaml:"block_timeout_ms"` // How long block waits before dropping the new message
+}
+
+// Outbox tunes delivery of asynchronously queued messages
+type Outbox struct {
+	PollIntervalMs int `yaml:"poll_interval_ms"`
+	MaxAttempts    int `yaml:"max_attempts"` // Attempts before a message is dead-lettered
+	BaseBackoffMs  int `yaml:"base_backoff_ms"`
+	MaxBackoffSec  int `yaml:"max_backoff_sec"`
+}
+
+// Scheduler controls how often due scheduled messages are picked up
+type Scheduler struct {
+	PollIntervalSec int `yaml:"poll_interval_sec"`
+}
+
+// Broadcast sets pacing and size limits for bulk sends
+type Broadcast struct {
+	DefaultIntervalMs int `yaml:"default_interval_ms"`
+	MinIntervalMs     int `yaml:"min_interval_ms"`
+	MaxRecipients     int `yaml:"max_recipients"`
+}
+
+// Retention controls how stored copies of disappearing messages are handled
+type Retention struct {
+	KeepDisappearing bool `yaml:"keep_disappearing"` // Compliance override: never purge disappearing messages
+	PurgeIntervalMin int  `yaml:"purge_interval_min"`
+}
+
+// Media holds upload limits in megabytes per WhatsApp media type and URL fetch settings
+type Media struct {
+	TempDir           string `yaml:"temp_dir"`
+	MaxImageMB        int64  `yaml:"max_image_mb"`
+	MaxVideoMB        int64  `yaml:"max_video_mb"`
+	MaxAudioMB        int64  `yaml:"max_audio_mb"`
+	MaxDocumentMB     int64  `yaml:"max_document_mb"`
+	FetchTimeoutSec   int    `yaml:"fetch_timeout_sec"`
+	AllowPrivateFetch bool   `yaml:"allow_private_fetch"` // Only for local development and tests
+	LibraryDir        string `yaml:"library_dir"`
+	LibraryTTLHours   int    `yaml:"library_ttl_hours"` // How long an upload is reused before it is sent to WhatsApp again
+}
+
+// Storage selects the blob store used for downloaded media ("local" or "s3")
+type Storage struct {
+	Driver   string `yaml:"driver"`
+	LocalDir string `yaml:"local_dir"`
+	S3       S3     `yaml:"s3"`
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
 func InitConfig() *Config {
 	var configs Config
 	file_name, _ := filepath.Abs("./config.yaml")
@@ -67,5 +192,51 @@ func InitConfig() *Config {
 		configs.App.Name = appName
 	}
 
+	// Override media configuration with environment variables
+	if tempDir := os.Getenv("MEDIA_TEMP_DIR"); tempDir != "" {
+		configs.WhatsApp.Media.TempDir = tempDir
+	}
+	if libraryDir := os.Getenv("MEDIA_LIBRARY_DIR"); libraryDir != "" {
+		configs.WhatsApp.Media.LibraryDir = libraryDir
+	}
+	if allowPrivate := os.Getenv("MEDIA_ALLOW_PRIVATE_FETCH"); allowPrivate != "" {
+		configs.WhatsApp.Media.AllowPrivateFetch = allowPrivate == "true"
+	}
+
+	if overflow := os.Getenv("INBOUND_OVERFLOW"); overflow != "" {
+		configs.WhatsApp.Inbound.Overflow = overflow
+	}
+
+	if allowPrivate := os.Getenv("WEBHOOKS_ALLOW_PRIVATE"); allowPrivate != "" {
+		configs.WhatsApp.Webhooks.AllowPrivate = allowPrivate == "true"
+	}
+
+	if keep := os.Getenv("RETENTION_KEEP_DISAPPEARING"); keep != "" {
+		configs.WhatsApp.Retention.KeepDisappearing = keep == "true"
+	}
+
+	// Override storage configuration with environment variables
+	if driver := os.Getenv("STORAGE_DRIVER"); driver != "" {
+		configs.Storage.Driver = driver
+	}
+	if localDir := os.Getenv("STORAGE_LOCAL_DIR"); localDir != "" {
+		configs.Storage.LocalDir = localDir
+	}
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		configs.Storage.S3.Endpoint = endpoint
+	}
+	if region := os.Getenv("S3_REGION"); region != "" {
+		configs.Storage.S3.Region = region
+	}
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		configs.Storage.S3.Bucket = bucket
+	}
+	if accessKey := os.Getenv("S3_ACCESS_KEY"); accessKey != "" {
+		configs.Storage.S3.AccessKey = accessKey
+	}
+	if secretKey := os.Getenv("S3_SECRET_KEY"); secretKey != "" {
+		configs.Storage.S3.SecretKey = secretKey
+	}
+
 	return &configs
 }
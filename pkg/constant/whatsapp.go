@@ -9,9 +9,127 @@ const (
 	STATUS_RETRIEVED      = "Status retrieved successfully"
 	CONTACTS_RETRIEVED    = "Contacts retrieved successfully"
 
-	WHATSAPP_NOT_CONNECTED = "WhatsApp client not connected"
-	WHATSAPP_NOT_INIT      = "WhatsApp client not initialized"
-	INVALID_PHONE_NUMBER   = "Invalid phone number format"
-	MEDIA_UPLOAD_FAILED    = "Failed to upload media"
-	FILE_READ_FAILED       = "Failed to read file data"
+	WHATSAPP_NOT_CONNECTED  = "WhatsApp client not connected"
+	WHATSAPP_NOT_INIT       = "WhatsApp client not initialized"
+	INVALID_PHONE_NUMBER    = "Invalid phone number format"
+	MEDIA_UPLOAD_FAILED     = "Failed to upload media"
+	FILE_READ_FAILED        = "Failed to read file data"
+	MEDIA_TOO_LARGE         = "Media file exceeds the allowed size"
+	INVALID_MEDIA_SOURCE    = "Invalid media source"
+	MEDIA_FETCH_FAILED      = "Failed to fetch media"
+	MEDIA_NOT_FOUND         = "Media not found"
+	MEDIA_STORED            = "Media stored in library successfully"
+	MEDIA_NOT_AVAILABLE     = "Media has not been downloaded"
+	MEDIA_RETRY_REQUESTED   = "Media retry requested"
+	VIEW_ONCE_NOT_SUPPORTED = "View-once is only supported for image, video and audio"
+	DISAPPEARING_TIMER_SET  = "Disappearing messages timer updated"
+	BROADCAST_CREATED       = "Broadcast queued successfully"
+	BROADCAST_CANCELLED     = "Broadcast cancelled"
+	BROADCAST_NOT_FOUND     = "Broadcast not found"
+	MESSAGE_SCHEDULED       = "Message scheduled successfully"
+	SCHEDULE_UPDATED        = "Scheduled message updated"
+	SCHEDULE_CANCELLED      = "Scheduled message cancelled"
+	SCHEDULE_NOT_FOUND      = "Scheduled message not found"
+	MESSAGE_QUEUED          = "Message queued for delivery"
+	OUTBOX_NOT_FOUND        = "Queued message not found"
+	OUTBOX_REQUEUED         = "Message requeued for delivery"
+
+	TEMPLATE_CREATED   = "Template created successfully"
+	TEMPLATE_UPDATED   = "Template updated successfully"
+	TEMPLATE_DELETED   = "Template deleted successfully"
+	TEMPLATE_NOT_FOUND = "Template not found"
+
+	PRESENCE_UPDATED     = "Presence updated"
+	MESSAGES_MARKED_READ = "Messages marked as read"
+	MESSAGES_RETRIEVED   = "Messages retrieved successfully"
+
+	MESSAGE_FORWARDED     = "Message forwarded"
+	MESSAGE_NOT_FOUND     = "Message not found"
+	FORWARD_NOT_SUPPORTED = "This message type cannot be forwarded"
+
+	CHAT_STATE_UPDATED = "Chat updated"
+	MESSAGE_STARRED    = "Message star updated"
+	LABEL_CREATED      = "Label created successfully"
+	LABEL_UPDATED      = "Label updated successfully"
+	LABEL_DELETED      = "Label deleted successfully"
+	LABEL_ASSIGNED     = "Label assignment updated"
+	LABEL_NOT_FOUND    = "Label not found"
+
+	STATUS_POSTED            = "Status posted successfully"
+	STATUS_POST_NOT_FOUND    = "Status post not found"
+	INVALID_STATUS_POST      = "Invalid status post"
+	STATUS_AUDIENCE_MISMATCH = "Account status privacy does not match the requested audience"
+
+	HISTORY_SYNCS_RETRIEVED = "History sync progress retrieved"
+
+	MESSAGES_FOUND       = "Search completed"
+	INVALID_SEARCH_QUERY = "Invalid search query"
+
+	INVALID_EXPORT_REQUEST = "Invalid export request"
+	CHAT_IMPORTED          = "Chat export imported"
+	INVALID_IMPORT         = "Invalid chat export"
+
+	WEBHOOK_CREATED            = "Webhook created successfully"
+	WEBHOOK_UPDATED            = "Webhook updated successfully"
+	WEBHOOK_DELETED            = "Webhook deleted successfully"
+	WEBHOOK_NOT_FOUND          = "Webhook not found"
+	WEBHOOK_DELIVERY_NOT_FOUND = "Webhook delivery not found"
+	WEBHOOK_REDELIVERY_QUEUED  = "Webhook redelivery queued"
+	INVALID_WEBHOOK            = "Invalid webhook"
+
+	PACING_UPDATED    = "Pacing settings updated"
+	DAILY_CAP_REACHED = "Daily send limit reached for this account"
+
+	IDEMPOTENCY_KEY_INVALID     = "Idempotency-Key must be 1-255 characters"
+	IDEMPOTENCY_KEY_MISMATCH    = "Idempotency-Key was already used with a different request"
+	IDEMPOTENCY_KEY_IN_PROGRESS = "A request with this Idempotency-Key is still being processed"
+
+	BROADCAST_STATUS_QUEUED    = "queued"
+	BROADCAST_STATUS_RUNNING   = "running"
+	BROADCAST_STATUS_PAUSED    = "paused" // Waiting for the session to reconnect
+	BROADCAST_STATUS_COMPLETED = "completed"
+	BROADCAST_STATUS_CANCELLED = "cancelled"
+	BROADCAST_STATUS_FAILED    = "failed"
+
+	SCHEDULE_STATUS_SCHEDULED = "scheduled"
+	SCHEDULE_STATUS_SENDING   = "sending"
+	SCHEDULE_STATUS_SENT      = "sent"
+	SCHEDULE_STATUS_FAILED    = "failed"
+	SCHEDULE_STATUS_CANCELLED = "cancelled"
+
+	IDEMPOTENCY_STATUS_PENDING   = "pending"
+	IDEMPOTENCY_STATUS_COMPLETED = "completed"
+
+	OUTBOX_STATUS_QUEUED  = "queued"
+	OUTBOX_STATUS_SENDING = "sending"
+	OUTBOX_STATUS_SENT    = "sent"
+	OUTBOX_STATUS_DEAD    = "dead"
+
+	RECIPIENT_STATUS_PENDING         = "pending"
+	RECIPIENT_STATUS_SENT            = "sent"
+	RECIPIENT_STATUS_FAILED          = "failed"
+	RECIPIENT_STATUS_NOT_ON_WHATSAPP = "not_on_whatsapp"
+
+	HISTORY_SYNC_STATUS_PROCESSING = "processing"
+	HISTORY_SYNC_STATUS_COMPLETED  = "completed"
+	HISTORY_SYNC_STATUS_FAILED     = "failed"
+	HISTORY_SYNC_STATUS_SKIPPED    = "skipped" // Dropped because the import worker fell too far behind
+
+	EVENT_MESSAGE        = "message"
+	EVENT_MESSAGE_EDITED = "message_edited"
+	EVENT_RECEIPT        = "receipt"
+	EVENT_CONNECTION     = "connection"
+	EVENT_GROUP          = "group"
+	EVENT_PRESENCE       = "presence" // Event stream only
+	EVENT_RESYNC         = "resync"   // Tells a stream client that events were missed and state should be reloaded
+
+	WEBHOOK_STATUS_PENDING   = "pending"
+	WEBHOOK_STATUS_SENDING   = "sending"
+	WEBHOOK_STATUS_DELIVERED = "delivered"
+	WEBHOOK_STATUS_FAILED    = "failed"
+
+	MEDIA_STATUS_PENDING  = "pending"
+	MEDIA_STATUS_STORED   = "stored"
+	MEDIA_STATUS_FAILED   = "failed"
+	MEDIA_STATUS_RETRYING = "retry_requested"
 )
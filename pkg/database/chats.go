@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// chatSummarySQL keeps the inbox summary of whats_app_chats in sync with the stored
+// messages: the newest message is the preview and unread inbound messages are counted.
+// The status feed is not a chat and gets no row.
+var chatSummarySQL = []string{
+	`CREATE OR REPLACE FUNCTION whats_app_chats_summary() RETURNS trigger AS $$
+DECLARE
+	unread_delta integer := 0;
+BEGIN
+	IF TG_OP <> 'INSERT' AND OLD.deleted_at IS NULL AND OLD.is_incoming AND OLD.read_at IS NULL THEN
+		unread_delta := unread_delta - 1;
+	END IF;
+	IF TG_OP <> 'DELETE' AND NEW.deleted_at IS NULL AND NEW.is_incoming AND NEW.read_at IS NULL THEN
+		unread_delta := unread_delta + 1;
+	END IF;
+
+	IF TG_OP = 'INSERT' THEN
+		IF NEW.deleted_at IS NOT NULL OR NEW.chat_jid = 'status@broadcast' THEN
+			RETURN NULL;
+		END IF;
+		INSERT INTO whats_app_chats (created_at, updated_at, user_id, chat_jid, unread_count)
+		VALUES (now(), now(), NEW.user_id, NEW.chat_jid, unread_delta)
+		ON CONFLICT (user_id, chat_jid) DO UPDATE SET unread_count = whats_app_chats.unread_count + EXCLUDED.unread_count;
+		UPDATE whats_app_chats SET
+			last_message_id = NEW.message_id, last_content = NEW.content, last_message_type = NEW.message_type,
+			last_is_incoming = NEW.is_incoming, last_message_at = NEW.timestamp
+		WHERE user_id = NEW.user_id AND chat_jid = NEW.chat_jid
+			AND (last_message_at IS NULL OR last_message_at <= NEW.timestamp);
+		RETURN NULL;
+	END IF;
+
+	IF unread_delta <> 0 THEN
+		UPDATE whats_app_chats SET unread_count = GREATEST(unread_count + unread_delta, 0)
+		WHERE user_id = OLD.user_id AND chat_jid = OLD.chat_jid;
+	END IF;
+
+	-- Edits, revokes and deletes of the preview fall back to the newest remaining message
+	IF TG_OP = 'DELETE' OR NEW.content IS DISTINCT FROM OLD.content
+		OR NEW.message_type IS DISTINCT FROM OLD.message_type OR NEW.deleted_at IS DISTINCT FROM OLD.deleted_at THEN
+		UPDATE whats_app_chats c SET
+			last_message_id = COALESCE(l.message_id, ''), last_content = COALESCE(l.content, ''),
+			last_message_type = COALESCE(l.message_type, ''), last_is_incoming = COALESCE(l.is_incoming, false),
+			last_message_at = l.timestamp
+		FROM (SELECT 1) AS one
+		LEFT JOIN LATERAL (
+			SELECT message_id, content, message_type, is_incoming, timestamp
+			FROM whats_app_messages
+			WHERE user_id = OLD.user_id AND chat_jid = OLD.chat_jid AND deleted_at IS NULL
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) l ON true
+		WHERE c.user_id = OLD.user_id AND c.chat_jid = OLD.chat_jid AND c.last_message_id = OLD.message_id;
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS whats_app_chats_summary ON whats_app_messages`,
+	`CREATE TRIGGER whats_app_chats_summary
+	AFTER INSERT OR UPDATE OF content, message_type, read_at, deleted_at OR DELETE ON whats_app_messages
+	FOR EACH ROW EXECUTE FUNCTION whats_app_chats_summary()`,
+	// Summarize chats whose messages were stored before the summary existed
+	`INSERT INTO whats_app_chats (created_at, updated_at, user_id, chat_jid)
+	SELECT DISTINCT now(), now(), m.user_id, m.chat_jid
+	FROM whats_app_messages m
+	WHERE m.deleted_at IS NULL AND m.chat_jid <> 'status@broadcast'
+		AND NOT EXISTS (SELECT 1 FROM whats_app_chats c WHERE c.user_id = m.user_id AND c.chat_jid = m.chat_jid)
+	ON CONFLICT (user_id, chat_jid) DO NOTHING`,
+	`UPDATE whats_app_chats c SET
+		last_message_id = l.message_id, last_content = l.content, last_message_type = l.message_type,
+		last_is_incoming = l.is_incoming, last_message_at = l.timestamp,
+		unread_count = (
+			SELECT COUNT(*) FROM whats_app_messages u
+			WHERE u.user_id = c.user_id AND u.chat_jid = c.chat_jid AND u.is_incoming AND u.read_at IS NULL AND u.deleted_at IS NULL
+		)
+	FROM whats_app_chats c2
+	JOIN LATERAL (
+		SELECT message_id, content, message_type, is_incoming, timestamp
+		FROM whats_app_messages
+		WHERE user_id = c2.user_id AND chat_jid = c2.chat_jid AND deleted_at IS NULL
+		ORDER BY timestamp DESC
+		LIMIT 1
+	) l ON true
+	WHERE c2.id = c.id AND c.last_message_at IS NULL`,
+}
+
+// migrateChatSummary installs the trigger that maintains the inbox summary of chats
+func migrateChatSummary(db *gorm.DB) error {
+	for _, statement := range chatSummarySQL {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to migrate chat summary: %v", err)
+		}
+	}
+	return nil
+}
@@ -7,10 +7,36 @@ import (
 
 // AutoMigrate runs database migrations
 func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	err := db.AutoMigrate(
 		&entities.User{},
 		&entities.WhatsAppSession{},
 		&entities.WhatsAppDevice{},
 		&entities.WhatsAppMessage{},
+		&entities.WhatsAppMedia{},
+		&entities.WhatsAppMessageMedia{},
+		&entities.WhatsAppBroadcast{},
+		&entities.WhatsAppBroadcastRecipient{},
+		&entities.WhatsAppScheduledMessage{},
+		&entities.WhatsAppOutboxMessage{},
+		&entities.WhatsAppIdempotencyKey{},
+		&entities.WhatsAppTemplate{},
+		&entities.WhatsAppTemplateVariant{},
+		&entities.WhatsAppPacing{},
+		&entities.WhatsAppStatusPost{},
+		&entities.WhatsAppStatusView{},
+		&entities.WhatsAppChat{},
+		&entities.WhatsAppLabel{},
+		&entities.WhatsAppLabelAssociation{},
+		&entities.WhatsAppHistorySync{},
+		&entities.WhatsAppWebhook{},
+		&entities.WhatsAppWebhookDelivery{},
+		&entities.WhatsAppInboundSpill{},
 	)
+	if err != nil {
+		return err
+	}
+	if err := migrateMessageSearch(db); err != nil {
+		return err
+	}
+	return migrateChatSummary(db)
 }
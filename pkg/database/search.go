@@ -0,0 +1,39 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// messageSearchSQL keeps whats_app_messages.search_vector in sync with the content.
+// Unknown search languages fall back to the language-neutral "simple" configuration.
+var messageSearchSQL = []string{
+	`CREATE OR REPLACE FUNCTION whats_app_messages_search_vector() RETURNS trigger AS $$
+DECLARE
+	search_config regconfig := 'simple';
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = NEW.search_language) THEN
+		search_config := NEW.search_language::regconfig;
+	END IF;
+	NEW.search_vector := to_tsvector(search_config, COALESCE(NEW.content, ''));
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS whats_app_messages_search_vector ON whats_app_messages`,
+	`CREATE TRIGGER whats_app_messages_search_vector
+	BEFORE INSERT OR UPDATE OF content, search_language ON whats_app_messages
+	FOR EACH ROW EXECUTE FUNCTION whats_app_messages_search_vector()`,
+	// Index messages stored before search existed
+	`UPDATE whats_app_messages SET search_language = COALESCE(search_language, '') WHERE search_vector IS NULL`,
+}
+
+// migrateMessageSearch installs the trigger that maintains the message search index
+func migrateMessageSearch(db *gorm.DB) error {
+	for _, statement := range messageSearchSQL {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to migrate message search: %v", err)
+		}
+	}
+	return nil
+}
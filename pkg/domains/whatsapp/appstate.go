@@ -0,0 +1,367 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrLabelNotFound is returned when a label does not exist for the account
+var ErrLabelNotFound = errors.New(constant.LABEL_NOT_FOUND)
+
+// handleAppState stores chat organisation changes made on any device. It runs on the
+// dispatch goroutine so consecutive changes to one chat are applied in order.
+func (s *service) handleAppState(session *UserSession, evt interface{}) {
+	var err error
+	switch v := evt.(type) {
+	case *events.Archive:
+		err = saveChatState(session.UserID, v.JID, map[string]interface{}{"archived": v.Action.GetArchived()})
+	case *events.Pin:
+		err = saveChatState(session.UserID, v.JID, map[string]interface{}{"pinned": v.Action.GetPinned()})
+	case *events.Mute:
+		err = saveChatState(session.UserID, v.JID, muteState(v.Action.GetMuted(), v.Action.GetMuteEndTimestamp()))
+	case *events.Star:
+		err = saveStar(session.UserID, v.ChatJID, v.MessageID, v.Action.GetStarred())
+	case *events.LabelEdit:
+		err = saveLabel(session.UserID, v.LabelID, v.Action.GetName(), v.Action.GetColor(), v.Action.GetDeleted())
+	case *events.LabelAssociationChat:
+		err = saveLabelAssociation(session.UserID, v.LabelID, v.JID, "", v.Action.GetLabeled())
+	case *events.LabelAssociationMessage:
+		err = saveLabelAssociation(session.UserID, v.LabelID, v.JID, v.MessageID, v.Action.GetLabeled())
+	}
+	if err != nil {
+		log.Printf("Failed to apply app state change for user %d: %v", session.UserID, err)
+	}
+}
+
+// muteState converts a mute action into chat columns; WhatsApp uses -1 or no end for "forever"
+func muteState(muted bool, endMillis int64) map[string]interface{} {
+	state := map[string]interface{}{"muted": muted, "muted_until": nil}
+	if muted && endMillis > 0 {
+		state["muted_until"] = time.UnixMilli(endMillis)
+	}
+	return state
+}
+
+// saveChatState upserts the given columns of a chat
+func saveChatState(userID uint, chat types.JID, state map[string]interface{}) error {
+	record := entities.WhatsAppChat{UserID: userID, ChatJID: chat.ToNonAD().String()}
+
+	db := database.DBClient()
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "chat_jid"}},
+		DoNothing: true,
+	}).Create(&record).Error
+	if err != nil {
+		return fmt.Errorf("failed to save chat: %v", err)
+	}
+
+	err = db.Model(&entities.WhatsAppChat{}).
+		Where("user_id = ? AND chat_jid = ?", record.UserID, record.ChatJID).
+		Updates(state).Error
+	if err != nil {
+		return fmt.Errorf("failed to save chat: %v", err)
+	}
+	return nil
+}
+
+func saveStar(userID uint, chat types.JID, messageID string, starred bool) error {
+	err := database.DBClient().Model(&entities.WhatsAppMessage{}).
+		Where("user_id = ? AND chat_jid = ? AND message_id = ?", userID, chat.ToNonAD().String(), messageID).
+		Update("starred", starred).Error
+	if err != nil {
+		return fmt.Errorf("failed to save star: %v", err)
+	}
+	return nil
+}
+
+func saveLabel(userID uint, labelID, name string, color int32, deleted bool) error {
+	db := database.DBClient()
+	if deleted {
+		if err := db.Unscoped().Where("user_id = ? AND label_id = ?", userID, labelID).Delete(&entities.WhatsAppLabelAssociation{}).Error; err != nil {
+			return fmt.Errorf("failed to delete label associations: %v", err)
+		}
+		if err := db.Unscoped().Where("user_id = ? AND label_id = ?", userID, labelID).Delete(&entities.WhatsAppLabel{}).Error; err != nil {
+			return fmt.Errorf("failed to delete label: %v", err)
+		}
+		return nil
+	}
+
+	label := entities.WhatsAppLabel{UserID: userID, LabelID: labelID, Name: name, Color: color}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "label_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "color", "updated_at"}),
+	}).Create(&label).Error
+	if err != nil {
+		return fmt.Errorf("failed to save label: %v", err)
+	}
+	return nil
+}
+
+func saveLabelAssociation(userID uint, labelID string, chat types.JID, messageID string, labeled bool) error {
+	db := database.DBClient()
+	association := entities.WhatsAppLabelAssociation{
+		UserID:    userID,
+		LabelID:   labelID,
+		ChatJID:   chat.ToNonAD().String(),
+		MessageID: messageID,
+	}
+
+	if !labeled {
+		err := db.Unscoped().
+			Where("user_id = ? AND label_id = ? AND chat_jid = ? AND message_id = ?", userID, labelID, association.ChatJID, messageID).
+			Delete(&entities.WhatsAppLabelAssociation{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to remove label: %v", err)
+		}
+		return nil
+	}
+
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&association).Error; err != nil {
+		return fmt.Errorf("failed to add label: %v", err)
+	}
+	return nil
+}
+
+// lastMessageKey returns the newest stored message of a chat, which archive patches reference
+func lastMessageKey(ctx context.Context, userID uint, chat types.JID) (time.Time, *waCommon.MessageKey) {
+	var last entities.WhatsAppMessage
+	err := database.DBClient().WithContext(ctx).
+		Where("user_id = ? AND chat_jid = ?", userID, chat.String()).
+		Order("timestamp desc").
+		First(&last).Error
+	if err != nil {
+		return time.Time{}, nil
+	}
+
+	key := &waCommon.MessageKey{
+		RemoteJID: proto.String(chat.String()),
+		FromMe:    proto.Bool(!last.IsIncoming),
+		ID:        proto.String(last.MessageID),
+	}
+	if chat.Server == types.GroupServer && last.IsIncoming {
+		key.Participant = proto.String(last.FromJID)
+	}
+	return last.Timestamp, key
+}
+
+// UpdateChatState archives, pins or mutes a chat on the phone and stores the result
+func (s *service) UpdateChatState(ctx context.Context, req dtos.ChatStateDTO) (*entities.WhatsAppChat, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := s.resolveChat(req.PhoneNumber, req.ChatJID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Archived != nil {
+		timestamp, key := lastMessageKey(ctx, session.UserID, chat)
+		if err := session.Client.SendAppState(ctx, appstate.BuildArchive(chat, *req.Archived, timestamp, key)); err != nil {
+			return nil, fmt.Errorf("failed to archive chat: %v", err)
+		}
+		state := map[string]interface{}{"archived": *req.Archived}
+		if *req.Archived {
+			// Archiving unpins the chat as well
+			state["pinned"] = false
+		}
+		if err := saveChatState(session.UserID, chat, state); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Pinned != nil {
+		if err := session.Client.SendAppState(ctx, appstate.BuildPin(chat, *req.Pinned)); err != nil {
+			return nil, fmt.Errorf("failed to pin chat: %v", err)
+		}
+		if err := saveChatState(session.UserID, chat, map[string]interface{}{"pinned": *req.Pinned}); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Muted != nil {
+		duration := time.Duration(req.MuteDurationSec) * time.Second
+		if err := session.Client.SendAppState(ctx, appstate.BuildMute(chat, *req.Muted, duration)); err != nil {
+			return nil, fmt.Errorf("failed to mute chat: %v", err)
+		}
+		var end int64
+		if duration > 0 {
+			end = time.Now().Add(duration).UnixMilli()
+		}
+		if err := saveChatState(session.UserID, chat, muteState(*req.Muted, end)); err != nil {
+			return nil, err
+		}
+	}
+
+	var record entities.WhatsAppChat
+	err = database.DBClient().WithContext(ctx).Where("user_id = ? AND chat_jid = ?", session.UserID, chat.String()).First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		// Nothing was changed and the chat has no stored state yet
+		return &entities.WhatsAppChat{UserID: session.UserID, ChatJID: chat.String()}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat: %v", err)
+	}
+	return &record, nil
+}
+
+// StarMessage stars or unstars a stored message on the phone
+func (s *service) StarMessage(ctx context.Context, req dtos.StarMessageDTO) error {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	chat, err := s.resolveChat(req.PhoneNumber, req.ChatJID)
+	if err != nil {
+		return err
+	}
+
+	var message entities.WhatsAppMessage
+	err = database.DBClient().WithContext(ctx).
+		Where("user_id = ? AND chat_jid = ? AND message_id = ?", session.UserID, chat.String(), req.MessageID).
+		First(&message).Error
+	if err == gorm.ErrRecordNotFound {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get message: %v", err)
+	}
+
+	sender, err := types.ParseJID(message.FromJID)
+	if err != nil {
+		return fmt.Errorf("invalid sender JID %q: %v", message.FromJID, err)
+	}
+	patch := appstate.BuildStar(chat, sender, message.MessageID, !message.IsIncoming, req.Starred)
+	if err := session.Client.SendAppState(ctx, patch); err != nil {
+		return fmt.Errorf("failed to star message: %v", err)
+	}
+	return saveStar(session.UserID, chat, message.MessageID, req.Starred)
+}
+
+func (s *service) ListLabels(ctx context.Context) ([]entities.WhatsAppLabel, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	var labels []entities.WhatsAppLabel
+	if err := database.DBClient().WithContext(ctx).Where("user_id = ?", userID).Order("name").Find(&labels).Error; err != nil {
+		return nil, fmt.Errorf("failed to list labels: %v", err)
+	}
+	return labels, nil
+}
+
+// CreateLabel adds a label, numbered after the highest label ID WhatsApp has assigned so far
+func (s *service) CreateLabel(ctx context.Context, req dtos.LabelDTO) (*entities.WhatsAppLabel, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	if err := database.DBClient().WithContext(ctx).Model(&entities.WhatsAppLabel{}).Where("user_id = ?", session.UserID).Pluck("label_id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to list labels: %v", err)
+	}
+	next := 1
+	for _, id := range ids {
+		if n, err := strconv.Atoi(id); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+
+	return s.editLabel(ctx, session, strconv.Itoa(next), req)
+}
+
+func (s *service) UpdateLabel(ctx context.Context, labelID string, req dtos.LabelDTO) (*entities.WhatsAppLabel, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := findLabel(ctx, session.UserID, labelID); err != nil {
+		return nil, err
+	}
+	return s.editLabel(ctx, session, labelID, req)
+}
+
+// editLabel pushes a label to the phone and stores it
+func (s *service) editLabel(ctx context.Context, session *UserSession, labelID string, req dtos.LabelDTO) (*entities.WhatsAppLabel, error) {
+	if err := session.Client.SendAppState(ctx, appstate.BuildLabelEdit(labelID, req.Name, req.Color, false)); err != nil {
+		return nil, fmt.Errorf("failed to save label: %v", err)
+	}
+	if err := saveLabel(session.UserID, labelID, req.Name, req.Color, false); err != nil {
+		return nil, err
+	}
+	return findLabel(ctx, session.UserID, labelID)
+}
+
+func (s *service) DeleteLabel(ctx context.Context, labelID string) error {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	label, err := findLabel(ctx, session.UserID, labelID)
+	if err != nil {
+		return err
+	}
+	if err := session.Client.SendAppState(ctx, appstate.BuildLabelEdit(labelID, label.Name, label.Color, true)); err != nil {
+		return fmt.Errorf("failed to delete label: %v", err)
+	}
+	return saveLabel(session.UserID, labelID, label.Name, label.Color, true)
+}
+
+// AssignLabel adds a label to or removes it from a chat, or one of its messages when a message ID is given
+func (s *service) AssignLabel(ctx context.Context, labelID string, req dtos.LabelAssignDTO) error {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := findLabel(ctx, session.UserID, labelID); err != nil {
+		return err
+	}
+	chat, err := s.resolveChat(req.PhoneNumber, req.ChatJID)
+	if err != nil {
+		return err
+	}
+
+	patch := appstate.BuildLabelChat(chat, labelID, req.Labeled)
+	if req.MessageID != "" {
+		patch = appstate.BuildLabelMessage(chat, labelID, req.MessageID, req.Labeled)
+	}
+	if err := session.Client.SendAppState(ctx, patch); err != nil {
+		return fmt.Errorf("failed to update label: %v", err)
+	}
+	return saveLabelAssociation(session.UserID, labelID, chat, req.MessageID, req.Labeled)
+}
+
+func findLabel(ctx context.Context, userID uint, labelID string) (*entities.WhatsAppLabel, error) {
+	var label entities.WhatsAppLabel
+	err := database.DBClient().WithContext(ctx).Where("user_id = ? AND label_id = ?", userID, labelID).First(&label).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrLabelNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get label: %v", err)
+	}
+	return &label, nil
+}
@@ -0,0 +1,510 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"github.com/crm/pkg/utils"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+)
+
+// isOnWhatsAppBatch is how many numbers are checked per IsOnWhatsApp call
+const isOnWhatsAppBatch = 50
+
+// ErrBroadcastNotFound is returned when a broadcast does not exist for the user
+var ErrBroadcastNotFound = errors.New(constant.BROADCAST_NOT_FOUND)
+
+// ErrInvalidBroadcast is returned when a broadcast request cannot be queued
+var ErrInvalidBroadcast = errors.New("invalid broadcast")
+
+// errBroadcastCancelled is the cancel cause of a job stopped by its owner. Jobs stopped
+// for any other reason, e.g. a closed session, are paused and resumed on reconnect.
+var errBroadcastCancelled = errors.New("broadcast cancelled")
+
+// broadcastSettings holds pacing defaults and limits for bulk sends
+type broadcastSettings struct {
+	defaultInterval time.Duration
+	minInterval     time.Duration
+	maxRecipients   int
+}
+
+func newBroadcastSettings(bc config.Broadcast) broadcastSettings {
+	settings := broadcastSettings{
+		defaultInterval: time.Duration(bc.DefaultIntervalMs) * time.Millisecond,
+		minInterval:     time.Duration(bc.MinIntervalMs) * time.Millisecond,
+		maxRecipients:   bc.MaxRecipients,
+	}
+	if settings.minInterval <= 0 {
+		settings.minInterval = time.Second
+	}
+	if settings.defaultInterval < settings.minInterval {
+		settings.defaultInterval = settings.minInterval
+	}
+	if settings.maxRecipients <= 0 {
+		settings.maxRecipients = 10000
+	}
+	return settings
+}
+
+// renderMessage executes a text/template message with the given variables
+func renderMessage(tmpl *template.Template, variables map[string]string) (string, error) {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, variables); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func (s *service) CreateBroadcast(ctx context.Context, req dtos.CreateBroadcastDTO) (*entities.WhatsAppBroadcast, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Message == "" && req.MediaID == 0 {
+		return nil, fmt.Errorf("%w: message or media_id is required", ErrInvalidBroadcast)
+	}
+	if len(req.Recipients) > s.broadcast.maxRecipients {
+		return nil, fmt.Errorf("%w: at most %d recipients are allowed", ErrInvalidBroadcast, s.broadcast.maxRecipients)
+	}
+	if _, err := template.New("broadcast").Option("missingkey=zero").Parse(req.Message); err != nil {
+		return nil, fmt.Errorf("%w: invalid message template: %v", ErrInvalidBroadcast, err)
+	}
+	if req.MediaID != 0 {
+		if _, err := s.findLibraryMedia(ctx, session.UserID, req.MediaID); err != nil {
+			return nil, err
+		}
+	}
+
+	interval := s.broadcast.defaultInterval
+	if req.IntervalMs > 0 {
+		interval = time.Duration(req.IntervalMs) * time.Millisecond
+	}
+	if interval < s.broadcast.minInterval {
+		interval = s.broadcast.minInterval
+	}
+
+	job := &entities.WhatsAppBroadcast{
+		UserID:     session.UserID,
+		Message:    req.Message,
+		MediaID:    req.MediaID,
+		IntervalMs: int(interval / time.Millisecond),
+		Status:     constant.BROADCAST_STATUS_QUEUED,
+		Total:      len(req.Recipients),
+	}
+
+	recipients := make([]entities.WhatsAppBroadcastRecipient, 0, len(req.Recipients))
+	for _, r := range req.Recipients {
+		variables, _ := json.Marshal(r.Variables)
+		recipients = append(recipients, entities.WhatsAppBroadcastRecipient{
+			PhoneNumber: r.PhoneNumber,
+			Variables:   string(variables),
+			Status:      constant.RECIPIENT_STATUS_PENDING,
+		})
+	}
+
+	err = database.DBClient().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		for i := range recipients {
+			recipients[i].BroadcastID = job.ID
+		}
+		return tx.CreateInBatches(recipients, 500).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create broadcast: %v", err)
+	}
+
+	s.startBroadcast(session, job.ID)
+
+	log.Printf("Broadcast %d queued by user %d for %d recipients", job.ID, session.UserID, job.Total)
+	return job, nil
+}
+
+// startBroadcast runs a job in the background unless it is already running
+func (s *service) startBroadcast(session *UserSession, jobID uint) {
+	s.broadcastMutex.Lock()
+	defer s.broadcastMutex.Unlock()
+	if _, running := s.broadcastCancels[jobID]; running {
+		return
+	}
+
+	runCtx, cancel := context.WithCancelCause(session.Ctx)
+	s.broadcastCancels[jobID] = cancel
+	go s.runBroadcast(runCtx, session, jobID)
+}
+
+// resumeBroadcasts picks up the unfinished jobs of a user once their session is connected,
+// both after a restart and after a connection drop paused them
+func (s *service) resumeBroadcasts(session *UserSession) {
+	var jobIDs []uint
+	err := database.DBClient().Model(&entities.WhatsAppBroadcast{}).
+		Where("user_id = ? AND status IN ?", session.UserID, []string{
+			constant.BROADCAST_STATUS_QUEUED, constant.BROADCAST_STATUS_RUNNING, constant.BROADCAST_STATUS_PAUSED,
+		}).
+		Order("id").Pluck("id", &jobIDs).Error
+	if err != nil {
+		log.Printf("Failed to load unfinished broadcasts of user %d: %v", session.UserID, err)
+		return
+	}
+
+	for _, jobID := range jobIDs {
+		log.Printf("Resuming broadcast %d of user %d", jobID, session.UserID)
+		s.startBroadcast(session, jobID)
+	}
+}
+
+// resumeAfterDailyCap restarts a job paused by the daily cap once the cap resets. A
+// reconnect before then resumes it through resumeBroadcasts instead.
+func (s *service) resumeAfterDailyCap(session *UserSession, jobID uint) {
+	resetAt := dailyCapReset()
+	log.Printf("Broadcast %d of user %d held until %s by the daily cap", jobID, session.UserID, resetAt.Format(time.RFC3339))
+
+	go func() {
+		timer := time.NewTimer(time.Until(resetAt))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-session.Ctx.Done():
+			return
+		}
+
+		// The job may have been cancelled while it waited
+		var paused int64
+		database.DBClient().Model(&entities.WhatsAppBroadcast{}).
+			Where("id = ? AND status = ?", jobID, constant.BROADCAST_STATUS_PAUSED).Count(&paused)
+		if paused > 0 {
+			s.startBroadcast(session, jobID)
+		}
+	}()
+}
+
+// connectionLost reports whether err means the session dropped rather than the send being rejected
+func connectionLost(session *UserSession, err error) bool {
+	return errors.Is(err, whatsmeow.ErrNotConnected) || !session.Client.IsConnected()
+}
+
+// runBroadcast validates and messages every pending recipient of a job, one at a time.
+// Progress is kept per recipient, so a paused or interrupted job resumes where it stopped.
+func (s *service) runBroadcast(ctx context.Context, session *UserSession, jobID uint) {
+	db := database.DBClient()
+	defer func() {
+		s.broadcastMutex.Lock()
+		if cancel, ok := s.broadcastCancels[jobID]; ok {
+			cancel(nil)
+			delete(s.broadcastCancels, jobID)
+		}
+		s.broadcastMutex.Unlock()
+	}()
+
+	var job entities.WhatsAppBroadcast
+	if err := db.First(&job, jobID).Error; err != nil {
+		log.Printf("Failed to load broadcast %d: %v", jobID, err)
+		return
+	}
+
+	job.Status = constant.BROADCAST_STATUS_RUNNING
+	job.Error = ""
+	if job.StartedAt == nil {
+		now := time.Now()
+		job.StartedAt = &now
+	}
+	db.Model(&job).Select("Status", "Error", "StartedAt").Updates(&job)
+
+	finish := func(status, reason string) {
+		finished := time.Now()
+		db.Model(&job).Select("Status", "Error", "FinishedAt").Updates(&entities.WhatsAppBroadcast{
+			Status:     status,
+			Error:      reason,
+			FinishedAt: &finished,
+		})
+		log.Printf("Broadcast %d finished with status %s", jobID, status)
+	}
+	// Pending recipients stay pending until the session is back
+	pause := func(reason string) {
+		db.Model(&job).Select("Status", "Error").Updates(&entities.WhatsAppBroadcast{
+			Status: constant.BROADCAST_STATUS_PAUSED,
+			Error:  reason,
+		})
+		log.Printf("Broadcast %d paused: %s", jobID, reason)
+	}
+	interrupted := func() bool {
+		if ctx.Err() == nil {
+			return false
+		}
+		if errors.Is(context.Cause(ctx), errBroadcastCancelled) {
+			finish(constant.BROADCAST_STATUS_CANCELLED, "")
+		} else {
+			pause("session closed")
+		}
+		return true
+	}
+
+	tmpl, err := template.New("broadcast").Option("missingkey=zero").Parse(job.Message)
+	if err != nil {
+		finish(constant.BROADCAST_STATUS_FAILED, err.Error())
+		return
+	}
+
+	// Library media is uploaded at most once and shared by every recipient
+	var uploaded whatsmeow.UploadResponse
+	var mediaReq dtos.SendMediaMessageDTO
+	if job.MediaID != 0 {
+		mediaReq.MediaID = job.MediaID
+		uploaded, err = s.libraryUpload(ctx, session, &mediaReq)
+		if err != nil {
+			switch {
+			case interrupted():
+			case connectionLost(session, err):
+				pause(err.Error())
+			default:
+				finish(constant.BROADCAST_STATUS_FAILED, err.Error())
+			}
+			return
+		}
+	}
+
+	if err := s.validateBroadcastRecipients(ctx, session, &job); err != nil {
+		switch {
+		case interrupted():
+		case connectionLost(session, err):
+			pause(err.Error())
+		default:
+			finish(constant.BROADCAST_STATUS_FAILED, err.Error())
+		}
+		return
+	}
+
+	var recipients []entities.WhatsAppBroadcastRecipient
+	db.Where("broadcast_id = ? AND status = ? AND jid <> ''", job.ID, constant.RECIPIENT_STATUS_PENDING).Order("id").Find(&recipients)
+
+	interval := time.Duration(job.IntervalMs) * time.Millisecond
+	for i, recipient := range recipients {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+		if interrupted() {
+			return
+		}
+
+		var variables map[string]string
+		json.Unmarshal([]byte(recipient.Variables), &variables)
+
+		text, err := renderMessage(tmpl, variables)
+		var msg *waProto.Message
+		if err == nil {
+			if job.MediaID != 0 {
+				req := mediaReq
+				req.Caption = text
+				msg = buildMediaMessage(mediaTypeFor(req.MimeType), uploaded, req)
+			} else {
+				msg = textMessage(text)
+			}
+		}
+
+		var resp whatsmeow.SendResponse
+		if err == nil {
+			jid, _ := types.ParseJID(recipient.JID)
+			resp, err = s.sendTo(ctx, session, jid, msg)
+		}
+
+		// A stopped job or dropped connection leaves the recipient pending
+		if err != nil && interrupted() {
+			return
+		}
+		if err != nil && connectionLost(session, err) {
+			pause(err.Error())
+			return
+		}
+		if errors.Is(err, ErrDailyCapReached) {
+			// Not the recipient's fault; carry on once the cap resets
+			pause(err.Error())
+			s.resumeAfterDailyCap(session, jobID)
+			return
+		}
+		if err != nil {
+			recipient.Status = constant.RECIPIENT_STATUS_FAILED
+			recipient.Error = err.Error()
+			db.Model(&job).UpdateColumn("failed", gorm.Expr("failed + 1"))
+		} else {
+			sentAt := time.Now()
+			recipient.Status = constant.RECIPIENT_STATUS_SENT
+			recipient.MessageID = resp.ID
+			recipient.SentAt = &sentAt
+			db.Model(&job).UpdateColumn("sent", gorm.Expr("sent + 1"))
+		}
+		db.Save(&recipient)
+	}
+
+	finish(constant.BROADCAST_STATUS_COMPLETED, "")
+}
+
+// validateBroadcastRecipients resolves pending numbers with IsOnWhatsApp and marks unknown ones
+func (s *service) validateBroadcastRecipients(ctx context.Context, session *UserSession, job *entities.WhatsAppBroadcast) error {
+	db := database.DBClient()
+
+	var pending []entities.WhatsAppBroadcastRecipient
+	if err := db.Where("broadcast_id = ? AND status = ? AND jid = ''", job.ID, constant.RECIPIENT_STATUS_PENDING).Order("id").Find(&pending).Error; err != nil {
+		return fmt.Errorf("failed to load recipients: %v", err)
+	}
+
+	for start := 0; start < len(pending); start += isOnWhatsAppBatch {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		end := min(start+isOnWhatsAppBatch, len(pending))
+		batch := pending[start:end]
+
+		queries := make([]string, 0, len(batch))
+		queryOf := make(map[uint]string, len(batch))
+		for i := range batch {
+			jid, err := s.formatPhoneNumber(batch[i].PhoneNumber)
+			if err != nil {
+				batch[i].Status = constant.RECIPIENT_STATUS_FAILED
+				batch[i].Error = fmt.Sprintf(constant.INVALID_PHONE_NUMBER+": %v", err)
+				db.Save(&batch[i])
+				db.Model(job).UpdateColumn("failed", gorm.Expr("failed + 1"))
+				continue
+			}
+			query := "+" + jid.User
+			queries = append(queries, query)
+			queryOf[batch[i].ID] = query
+		}
+		if len(queries) == 0 {
+			continue
+		}
+
+		results, err := session.Client.IsOnWhatsApp(queries)
+		if err != nil {
+			return fmt.Errorf("failed to check numbers on WhatsApp: %v", err)
+		}
+		registered := make(map[string]types.JID, len(results))
+		for _, result := range results {
+			if result.IsIn {
+				registered[result.Query] = result.JID
+			}
+		}
+
+		for i := range batch {
+			query, ok := queryOf[batch[i].ID]
+			if !ok {
+				continue
+			}
+			if jid, found := registered[query]; found {
+				batch[i].JID = jid.String()
+			} else {
+				batch[i].Status = constant.RECIPIENT_STATUS_NOT_ON_WHATSAPP
+				db.Model(job).UpdateColumn("not_on_whatsapp", gorm.Expr("not_on_whatsapp + 1"))
+			}
+			db.Save(&batch[i])
+		}
+	}
+	return nil
+}
+
+func (s *service) ListBroadcasts(ctx context.Context) ([]entities.WhatsAppBroadcast, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	var jobs []entities.WhatsAppBroadcast
+	if err := database.DBClient().WithContext(ctx).Where("user_id = ?", userID).Order("id desc").Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list broadcasts: %v", err)
+	}
+	return jobs, nil
+}
+
+func (s *service) GetBroadcast(ctx context.Context, id uint) (*entities.WhatsAppBroadcast, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	return s.findBroadcast(ctx, userID, id)
+}
+
+// GetBroadcastRecipients returns one page of recipients, optionally filtered by status
+func (s *service) GetBroadcastRecipients(ctx context.Context, id uint, status string, page int) ([]entities.WhatsAppBroadcastRecipient, int, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("authentication required: %v", err)
+	}
+	if _, err := s.findBroadcast(ctx, userID, id); err != nil {
+		return nil, 0, err
+	}
+
+	query := "broadcast_id = ?"
+	args := []interface{}{id}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+
+	var recipients []entities.WhatsAppBroadcastRecipient
+	totalPages, err := utils.Pagination(&recipients, page, database.DBClient().Order("id"), ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return recipients, totalPages, nil
+}
+
+func (s *service) CancelBroadcast(ctx context.Context, id uint) error {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("authentication required: %v", err)
+	}
+	if _, err := s.findBroadcast(ctx, userID, id); err != nil {
+		return err
+	}
+
+	s.broadcastMutex.Lock()
+	cancel, running := s.broadcastCancels[id]
+	s.broadcastMutex.Unlock()
+	if running {
+		cancel(errBroadcastCancelled)
+		return nil
+	}
+
+	// Jobs waiting for a reconnect have no worker to stop
+	finished := time.Now()
+	result := database.DBClient().WithContext(ctx).Model(&entities.WhatsAppBroadcast{}).
+		Where("id = ? AND status IN ?", id, []string{constant.BROADCAST_STATUS_QUEUED, constant.BROADCAST_STATUS_PAUSED}).
+		Updates(map[string]interface{}{"status": constant.BROADCAST_STATUS_CANCELLED, "error": "", "finished_at": finished})
+	if result.Error != nil {
+		return fmt.Errorf("failed to cancel broadcast: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("broadcast %d is not running", id)
+	}
+	return nil
+}
+
+func (s *service) findBroadcast(ctx context.Context, userID, id uint) (*entities.WhatsAppBroadcast, error) {
+	var job entities.WhatsAppBroadcast
+	err := database.DBClient().WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&job).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrBroadcastNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get broadcast: %v", err)
+	}
+	return &job, nil
+}
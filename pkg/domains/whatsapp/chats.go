@@ -0,0 +1,146 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+)
+
+// chatPageSize matches the page size of the other paginated endpoints
+const chatPageSize = 10
+
+// ListChats returns one page of the inbox, pinned chats first and then by recent activity.
+// Previews and unread counts come from the summary kept on each chat.
+func (s *service) ListChats(ctx context.Context, req dtos.ChatListDTO) ([]dtos.ChatDTO, int, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("authentication required: %v", err)
+	}
+
+	query := database.DBClient().WithContext(ctx).Model(&entities.WhatsAppChat{}).
+		Where("user_id = ? AND chat_jid <> ?", userID, types.StatusBroadcastJID.String())
+	if req.Archived != nil {
+		query = query.Where("archived = ?", *req.Archived)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count chats: %v", err)
+	}
+	if total == 0 {
+		return []dtos.ChatDTO{}, 0, nil
+	}
+	totalPages := int((total + chatPageSize - 1) / chatPageSize)
+	if req.Page <= 0 || req.Page > totalPages {
+		return nil, 0, errors.New(constant.PAGE_NUMBER_OUT_OF_RANGE)
+	}
+
+	var states []entities.WhatsAppChat
+	err = query.Order("pinned DESC, last_message_at DESC NULLS LAST, chat_jid").
+		Limit(chatPageSize).Offset((req.Page - 1) * chatPageSize).Find(&states).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get chats: %v", err)
+	}
+
+	now := time.Now()
+	chats := make([]dtos.ChatDTO, 0, len(states))
+	for _, state := range states {
+		jid, err := types.ParseJID(state.ChatJID)
+		chat := dtos.ChatDTO{
+			ChatJID:     state.ChatJID,
+			Name:        state.Name,
+			IsGroup:     err == nil && jid.Server == types.GroupServer,
+			UnreadCount: state.UnreadCount,
+			Archived:    state.Archived,
+			Pinned:      state.Pinned,
+			Muted:       state.Muted && (state.MutedUntil == nil || state.MutedUntil.After(now)),
+		}
+		if chat.Muted && state.MutedUntil != nil {
+			chat.MutedUntil = state.MutedUntil.Format(time.RFC3339)
+		}
+		// Chats known only from app state have no messages yet
+		if state.LastMessageAt != nil {
+			chat.LastMessage = &dtos.ChatLastMessageDTO{
+				MessageID:   state.LastMessageID,
+				Content:     state.LastContent,
+				MessageType: state.LastMessageType,
+				IsIncoming:  state.LastIsIncoming,
+				Timestamp:   state.LastMessageAt.Format(time.RFC3339),
+			}
+			chat.LastActivityAt = state.LastMessageAt.Format(time.RFC3339)
+		}
+		chats = append(chats, chat)
+	}
+
+	s.nameChats(ctx, userID, chats)
+	return chats, totalPages, nil
+}
+
+// nameChats fills in display names of direct chats from the contact store. Group subjects
+// are stored as WhatsApp announces them and by syncGroupNames. Names are best effort; the
+// list works while WhatsApp is offline.
+func (s *service) nameChats(ctx context.Context, userID uint, chats []dtos.ChatDTO) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return
+	}
+
+	for i := range chats {
+		chat := &chats[i]
+		if chat.Name != "" || chat.IsGroup {
+			continue
+		}
+		jid, err := types.ParseJID(chat.ChatJID)
+		if err != nil {
+			continue
+		}
+
+		contact, err := session.Client.Store.Contacts.GetContact(ctx, jid)
+		if err != nil {
+			log.Printf("Failed to load contact %s for user %d: %v", jid, userID, err)
+			continue
+		}
+		chat.Name = contactName(contact)
+	}
+}
+
+// syncGroupNames stores the subjects and disappearing timers of all joined groups, so the
+// inbox can name them without asking WhatsApp per request
+func (s *service) syncGroupNames(session *UserSession) {
+	groups, err := session.Client.GetJoinedGroups()
+	if err != nil {
+		log.Printf("Failed to get joined groups for user %d: %v", session.UserID, err)
+		return
+	}
+	for _, group := range groups {
+		s.handleGroupName(session, group.JID, group.Name)
+		saveDisappearingTimer(session.UserID, group.JID, groupDisappearingTimer(group.GroupEphemeral))
+	}
+}
+
+// contactName picks the best known display name of a contact
+func contactName(contact types.ContactInfo) string {
+	for _, name := range []string{contact.FullName, contact.BusinessName, contact.PushName} {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// handleGroupName stores a group subject announced by WhatsApp
+func (s *service) handleGroupName(session *UserSession, group types.JID, name string) {
+	if err := saveChatState(session.UserID, group, map[string]interface{}{"name": name}); err != nil {
+		log.Printf("Failed to store name of %s for user %d: %v", group, session.UserID, err)
+	}
+}
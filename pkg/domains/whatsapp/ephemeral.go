@@ -0,0 +1,187 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrViewOnceNotSupported is returned when view-once is requested for a document
+var ErrViewOnceNotSupported = errors.New(constant.VIEW_ONCE_NOT_SUPPORTED)
+
+// disappearingTimers maps the accepted timer names to WhatsApp's allowed durations
+var disappearingTimers = map[string]time.Duration{
+	"off": whatsmeow.DisappearingTimerOff,
+	"24h": whatsmeow.DisappearingTimer24Hours,
+	"7d":  whatsmeow.DisappearingTimer7Days,
+	"90d": whatsmeow.DisappearingTimer90Days,
+}
+
+// retentionPolicy decides whether stored copies of disappearing messages are purged
+type retentionPolicy struct {
+	keepDisappearing bool
+	purgeInterval    time.Duration
+}
+
+func newRetentionPolicy(rc config.Retention) retentionPolicy {
+	interval := time.Duration(rc.PurgeIntervalMin) * time.Minute
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	return retentionPolicy{
+		keepDisappearing: rc.KeepDisappearing,
+		purgeInterval:    interval,
+	}
+}
+
+// wrapViewOnce marks the media in msg as view-once and wraps it the way WhatsApp clients expect
+func wrapViewOnce(msg *waProto.Message) *waProto.Message {
+	switch {
+	case msg.GetImageMessage() != nil:
+		msg.ImageMessage.ViewOnce = proto.Bool(true)
+	case msg.GetVideoMessage() != nil:
+		msg.VideoMessage.ViewOnce = proto.Bool(true)
+	case msg.GetAudioMessage() != nil:
+		msg.AudioMessage.ViewOnce = proto.Bool(true)
+	}
+	return &waProto.Message{
+		ViewOnceMessageV2: &waProto.FutureProofMessage{Message: msg},
+	}
+}
+
+// ephemeralExpiration returns how long an inbound message lives in its chat, or zero if it
+// is permanent or its lifetime is not known. Unknown lifetimes are never purged.
+func ephemeralExpiration(userID uint, event *events.Message) time.Duration {
+	contextInfo := contextInfoOf(event.Message)
+	if seconds := contextInfo.GetExpiration(); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if event.IsEphemeral {
+		// Plain conversation messages carry no context info; use the chat's last known timer
+		return chatDisappearingTimer(userID, event.Info.Chat)
+	}
+	return 0
+}
+
+// chatDisappearingTimer returns the stored disappearing timer of a chat, zero when it is
+// off or not known
+func chatDisappearingTimer(userID uint, chat types.JID) time.Duration {
+	var seconds uint32
+	err := database.DBClient().Model(&entities.WhatsAppChat{}).
+		Where("user_id = ? AND chat_jid = ?", userID, chat.ToNonAD().String()).
+		Select("disappearing_timer").Scan(&seconds).Error
+	if err != nil {
+		log.Printf("Failed to load disappearing timer of %s for user %d: %v", chat, userID, err)
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// saveDisappearingTimer records the disappearing timer of a chat as announced by WhatsApp
+func saveDisappearingTimer(userID uint, chat types.JID, seconds uint32) {
+	if err := saveChatState(userID, chat, map[string]interface{}{"disappearing_timer": seconds}); err != nil {
+		log.Printf("Failed to store disappearing timer of %s for user %d: %v", chat, userID, err)
+	}
+}
+
+// groupDisappearingTimer returns the timer described by a group's ephemeral settings
+func groupDisappearingTimer(ephemeral types.GroupEphemeral) uint32 {
+	if !ephemeral.IsEphemeral {
+		return 0
+	}
+	return ephemeral.DisappearingTimer
+}
+
+func (s *service) SetDisappearingTimer(ctx context.Context, req dtos.DisappearingTimerDTO) error {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	timer, ok := disappearingTimers[req.Timer]
+	if !ok {
+		return fmt.Errorf("%w: %s", whatsmeow.ErrInvalidDisappearingTimer, req.Timer)
+	}
+
+	chat, err := s.resolveChat(req.PhoneNumber, req.ChatJID)
+	if err != nil {
+		return err
+	}
+
+	if err := session.Client.SetDisappearingTimer(chat, timer, time.Now()); err != nil {
+		return fmt.Errorf("failed to set disappearing timer: %v", err)
+	}
+
+	saveDisappearingTimer(session.UserID, chat, uint32(timer/time.Second))
+
+	log.Printf("Disappearing timer for %s set to %s by user %d", chat, req.Timer, session.UserID)
+	return nil
+}
+
+// purgeExpiredMessages periodically hard-deletes stored disappearing messages and their media
+func (s *service) purgeExpiredMessages() {
+	if s.retention.keepDisappearing {
+		log.Printf("Retention override active, disappearing messages are kept")
+		return
+	}
+
+	ticker := time.NewTicker(s.retention.purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.purgeExpiredOnce()
+	}
+}
+
+func (s *service) purgeExpiredOnce() {
+	db := database.DBClient()
+
+	var expired []entities.WhatsAppMessage
+	if err := db.Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).Limit(500).Find(&expired).Error; err != nil {
+		log.Printf("Failed to load expired messages: %v", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	ids := make([]uint, 0, len(expired))
+	for _, message := range expired {
+		ids = append(ids, message.ID)
+	}
+
+	var media []entities.WhatsAppMessageMedia
+	db.Unscoped().Where("message_record_id IN ?", ids).Find(&media)
+	for _, item := range media {
+		if item.StorageKey == "" {
+			continue
+		}
+		if err := s.store.Delete(context.Background(), item.StorageKey); err != nil {
+			log.Printf("Failed to delete blob %s of expired message: %v", item.StorageKey, err)
+		}
+	}
+
+	if err := db.Unscoped().Where("message_record_id IN ?", ids).Delete(&entities.WhatsAppMessageMedia{}).Error; err != nil {
+		log.Printf("Failed to purge media of expired messages: %v", err)
+		return
+	}
+	if err := db.Unscoped().Where("id IN ?", ids).Delete(&entities.WhatsAppMessage{}).Error; err != nil {
+		log.Printf("Failed to purge expired messages: %v", err)
+		return
+	}
+
+	log.Printf("Purged %d expired disappearing messages", len(ids))
+}
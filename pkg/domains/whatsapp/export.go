@@ -0,0 +1,509 @@
+package whatsapp
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+)
+
+const (
+	// exportBatchSize is how many messages are loaded per query while streaming
+	exportBatchSize = 500
+	// exportTimeLayout is the timestamp prefix of WhatsApp's "Export chat" text files
+	exportTimeLayout = "02/01/2006, 15:04"
+	// exportMediaOmitted and exportFileAttached mark attachments in text exports the way the phone does
+	exportMediaOmitted = "<Media omitted>"
+	exportFileAttached = " (file attached)"
+	exportEdited       = " <This message was edited>"
+)
+
+// ErrInvalidExport is returned for export requests with an unknown format or no scope
+var ErrInvalidExport = errors.New(constant.INVALID_EXPORT_REQUEST)
+
+// exportExtensions maps the MIME types WhatsApp sends to the extensions the phone uses
+var exportExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"video/mp4":       ".mp4",
+	"audio/ogg":       ".opus",
+	"audio/mpeg":      ".mp3",
+	"audio/mp4":       ".m4a",
+	"application/pdf": ".pdf",
+}
+
+// exportMediaPrefixes are the file name prefixes of attachments in phone exports
+var exportMediaPrefixes = map[string]string{
+	"image":    "IMG",
+	"video":    "VID",
+	"audio":    "AUD",
+	"sticker":  "STK",
+	"document": "DOC",
+}
+
+// exportScope is a validated export request
+type exportScope struct {
+	userID   uint
+	chat     string // Empty for all chats in the date range
+	from, to time.Time
+	format   string
+	zip      bool
+	location *time.Location
+	names    map[string]string // Display names by JID, best effort
+}
+
+// exportRecord is one stored message as written to a transcript
+type exportRecord struct {
+	message   *entities.WhatsAppMessage
+	sender    string
+	media     *entities.WhatsAppMessageMedia // Stored attachment, if any
+	mediaFile string                         // Name of the attachment inside the ZIP
+}
+
+// transcriptWriter writes the messages of an export in one format
+type transcriptWriter interface {
+	write(record *exportRecord) error
+	close() error
+}
+
+// ExportChat streams the messages of one chat or of a date range as JSON, CSV or
+// WhatsApp-style text, optionally zipped together with the stored attachments.
+func (s *service) ExportChat(ctx context.Context, req dtos.ExportDTO) (*dtos.ExportFileDTO, io.ReadCloser, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	scope := &exportScope{userID: userID, format: req.Format, zip: req.IncludeMedia, location: time.UTC}
+	switch scope.format {
+	case "":
+		scope.format = "txt"
+	case "txt", "csv", "json":
+	default:
+		return nil, nil, fmt.Errorf("%w: format must be txt, csv or json", ErrInvalidExport)
+	}
+	if req.TimeZone != "" {
+		if scope.location, err = time.LoadLocation(req.TimeZone); err != nil {
+			return nil, nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalidExport, req.TimeZone)
+		}
+	}
+
+	if req.PhoneNumber != "" || req.ChatJID != "" {
+		chat, err := s.resolveChat(req.PhoneNumber, req.ChatJID)
+		if err != nil {
+			return nil, nil, err
+		}
+		scope.chat = chat.String()
+	}
+	if req.From != "" {
+		if scope.from, err = parseTimeFilter(req.From, false); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+		}
+	}
+	if req.To != "" {
+		if scope.to, err = parseTimeFilter(req.To, true); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+		}
+	}
+	if scope.chat == "" && scope.from.IsZero() && scope.to.IsZero() {
+		return nil, nil, fmt.Errorf("%w: a chat or a date range is required", ErrInvalidExport)
+	}
+
+	s.loadExportNames(ctx, scope)
+	file := exportFile(scope)
+
+	// The request context is recycled once the handler returns; the export stops
+	// instead when the reader is closed and the next write fails
+	reader, writer := io.Pipe()
+	go func() {
+		err := s.writeExport(context.Background(), scope, file.TranscriptName, writer)
+		if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			log.Printf("Export for user %d failed: %v", userID, err)
+		}
+		writer.CloseWithError(err)
+	}()
+	return file, reader, nil
+}
+
+// loadExportNames collects display names from stored chats and, while connected, the contact store
+func (s *service) loadExportNames(ctx context.Context, scope *exportScope) {
+	scope.names = map[string]string{}
+
+	var chats []entities.WhatsAppChat
+	if err := database.DBClient().WithContext(ctx).Where("user_id = ? AND name <> ''", scope.userID).Find(&chats).Error; err != nil {
+		log.Printf("Failed to load chat names for user %d: %v", scope.userID, err)
+	}
+	for _, chat := range chats {
+		scope.names[chat.ChatJID] = chat.Name
+	}
+
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return
+	}
+	if name := session.Client.Store.PushName; name != "" {
+		scope.names[session.Client.Store.ID.ToNonAD().String()] = name
+	}
+	contacts, err := session.Client.Store.Contacts.GetAllContacts(ctx)
+	if err != nil {
+		log.Printf("Failed to load contacts for user %d: %v", scope.userID, err)
+		return
+	}
+	for jid, contact := range contacts {
+		if name := contactName(contact); name != "" {
+			scope.names[jid.String()] = name
+		}
+	}
+}
+
+// senderName returns the name a transcript shows for jid, falling back to the phone number
+func (scope *exportScope) senderName(jid string) string {
+	if name := scope.names[jid]; name != "" {
+		return name
+	}
+	parsed, err := types.ParseJID(jid)
+	if err != nil || parsed.Server != types.DefaultUserServer {
+		return jid
+	}
+	return "+" + parsed.User
+}
+
+// exportFile names the download and the transcript inside it
+func exportFile(scope *exportScope) *dtos.ExportFileDTO {
+	subject := "WhatsApp Chat"
+	if scope.chat != "" {
+		subject = "WhatsApp Chat with " + strings.ReplaceAll(scope.senderName(scope.chat), "/", "-")
+	}
+
+	file := &dtos.ExportFileDTO{TranscriptName: subject + "." + scope.format}
+	switch scope.format {
+	case "csv":
+		file.ContentType = "text/csv; charset=utf-8"
+	case "json":
+		file.ContentType = "application/json"
+	default:
+		file.ContentType = "text/plain; charset=utf-8"
+	}
+	file.FileName = file.TranscriptName
+	if scope.zip {
+		file.FileName = subject + ".zip"
+		file.ContentType = "application/zip"
+	}
+	return file
+}
+
+// writeExport streams the transcript, and for ZIP exports the attachments after it
+func (s *service) writeExport(ctx context.Context, scope *exportScope, transcriptName string, w io.Writer) error {
+	out := w
+	var archive *zip.Writer
+	if scope.zip {
+		archive = zip.NewWriter(w)
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: transcriptName, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		out = entry
+	}
+
+	transcript := newTranscriptWriter(scope, out)
+	var attachments []*exportRecord
+	err := s.eachExportRecord(ctx, scope, func(record *exportRecord) error {
+		if record.mediaFile != "" {
+			attachments = append(attachments, record)
+		}
+		return transcript.write(record)
+	})
+	if err != nil {
+		return err
+	}
+	if err := transcript.close(); err != nil {
+		return err
+	}
+	if archive == nil {
+		return nil
+	}
+
+	// Attachments are already compressed, so they are stored as they are
+	for _, record := range attachments {
+		if err := s.writeExportMedia(ctx, archive, record); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// writeExportMedia copies one stored attachment into the archive
+func (s *service) writeExportMedia(ctx context.Context, archive *zip.Writer, record *exportRecord) error {
+	content, err := s.store.Get(ctx, record.media.StorageKey)
+	if err != nil {
+		// A missing blob must not abort a legal export; the transcript still names the file
+		log.Printf("Failed to read media %d for export: %v", record.media.ID, err)
+		return nil
+	}
+	defer content.Close()
+
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: record.mediaFile, Method: zip.Store, Modified: record.message.Timestamp})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+// eachExportRecord walks the messages in scope oldest first, one batch at a time
+func (s *service) eachExportRecord(ctx context.Context, scope *exportScope, fn func(record *exportRecord) error) error {
+	db := database.DBClient().WithContext(ctx)
+	query := db.Where("user_id = ?", scope.userID)
+	if scope.chat != "" {
+		query = query.Where("chat_jid = ?", scope.chat)
+	} else {
+		query = query.Where("chat_jid <> ?", types.StatusBroadcastJID.String())
+	}
+	if !scope.from.IsZero() {
+		query = query.Where("timestamp >= ?", scope.from)
+	}
+	if !scope.to.IsZero() {
+		query = query.Where("timestamp <= ?", scope.to)
+	}
+	query = query.Session(&gorm.Session{})
+
+	names := map[string]bool{}
+	sequence := 0
+	var lastTimestamp time.Time
+	var lastID uint
+	for {
+		batch := query
+		if lastID > 0 {
+			batch = batch.Where("(timestamp, id) > (?, ?)", lastTimestamp, lastID)
+		}
+
+		var messages []entities.WhatsAppMessage
+		if err := batch.Order("timestamp, id").Limit(exportBatchSize).Find(&messages).Error; err != nil {
+			return fmt.Errorf("failed to load messages: %v", err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(messages))
+		for _, message := range messages {
+			ids = append(ids, message.ID)
+		}
+		var media []entities.WhatsAppMessageMedia
+		err := db.Where("message_record_id IN ? AND status = ?", ids, constant.MEDIA_STATUS_STORED).Find(&media).Error
+		if err != nil {
+			return fmt.Errorf("failed to load media: %v", err)
+		}
+		byMessage := make(map[uint]*entities.WhatsAppMessageMedia, len(media))
+		for i := range media {
+			byMessage[media[i].MessageRecordID] = &media[i]
+		}
+
+		for i := range messages {
+			message := &messages[i]
+			record := &exportRecord{message: message, sender: scope.senderName(message.FromJID)}
+			if attachment := byMessage[message.ID]; attachment != nil {
+				record.media = attachment
+				if scope.zip {
+					sequence++
+					record.mediaFile = exportMediaName(attachment, message.Timestamp, sequence, names)
+				}
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+
+		last := messages[len(messages)-1]
+		lastTimestamp, lastID = last.Timestamp, last.ID
+	}
+}
+
+// exportMediaName names an attachment the way the phone does, e.g. IMG-20240131-WA0007.jpg.
+// Documents keep their own file name unless it was already used.
+func exportMediaName(media *entities.WhatsAppMessageMedia, sentAt time.Time, sequence int, used map[string]bool) string {
+	name := ""
+	if media.Kind == "document" && media.FileName != "" {
+		name = path.Base(strings.ReplaceAll(media.FileName, "\\", "/"))
+	}
+	if name == "" || name == "." || name == "/" || used[name] {
+		prefix := exportMediaPrefixes[media.Kind]
+		if prefix == "" {
+			prefix = "FILE"
+		}
+		name = fmt.Sprintf("%s-%s-WA%04d%s", prefix, sentAt.Format("20060102"), sequence, exportExtension(media.MimeType))
+	}
+	used[name] = true
+	return name
+}
+
+func exportExtension(mimeType string) string {
+	base, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ".bin"
+	}
+	if ext, ok := exportExtensions[base]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(base); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+func newTranscriptWriter(scope *exportScope, w io.Writer) transcriptWriter {
+	switch scope.format {
+	case "csv":
+		return &csvTranscript{scope: scope, w: csv.NewWriter(w)}
+	case "json":
+		return &jsonTranscript{scope: scope, w: w}
+	default:
+		return &textTranscript{scope: scope, w: w}
+	}
+}
+
+// textTranscript writes WhatsApp's "Export chat" format: "31/01/2024, 14:05 - Name: text"
+type textTranscript struct {
+	scope *exportScope
+	w     io.Writer
+}
+
+func (t *textTranscript) write(record *exportRecord) error {
+	message := record.message
+	text := message.Content
+	switch {
+	case record.mediaFile != "":
+		text = record.mediaFile + exportFileAttached
+		if message.Content != "" {
+			text += "\n" + message.Content
+		}
+	case message.MessageType != "text" && message.MessageType != "unknown":
+		text = exportMediaOmitted
+	}
+	if message.EditedAt != nil {
+		text += exportEdited
+	}
+
+	_, err := fmt.Fprintf(t.w, "%s - %s: %s\n", message.Timestamp.In(t.scope.location).Format(exportTimeLayout), record.sender, text)
+	return err
+}
+
+func (t *textTranscript) close() error {
+	return nil
+}
+
+// csvTranscript writes one row per message with a header row
+type csvTranscript struct {
+	scope  *exportScope
+	w      *csv.Writer
+	header bool
+}
+
+func (t *csvTranscript) write(record *exportRecord) error {
+	if !t.header {
+		t.header = true
+		err := t.w.Write([]string{"id", "message_id", "chat_jid", "timestamp", "from_jid", "sender", "direction", "type", "content", "media_file", "edited", "imported"})
+		if err != nil {
+			return err
+		}
+	}
+	dto := exportMessageDTO(t.scope, record)
+	return t.w.Write([]string{
+		strconv.FormatUint(uint64(dto.ID), 10),
+		dto.MessageID,
+		dto.ChatJID,
+		dto.Timestamp,
+		dto.FromJID,
+		dto.Sender,
+		dto.Direction,
+		dto.MessageType,
+		dto.Content,
+		dto.MediaFile,
+		strconv.FormatBool(dto.EditedAt != ""),
+		strconv.FormatBool(dto.Imported),
+	})
+}
+
+func (t *csvTranscript) close() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+// jsonTranscript writes a JSON array one element at a time
+type jsonTranscript struct {
+	scope *exportScope
+	w     io.Writer
+	count int
+}
+
+func (t *jsonTranscript) write(record *exportRecord) error {
+	separator := ","
+	if t.count == 0 {
+		separator = "["
+	}
+	t.count++
+
+	data, err := json.Marshal(exportMessageDTO(t.scope, record))
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(t.w, separator+"\n"); err != nil {
+		return err
+	}
+	_, err = t.w.Write(data)
+	return err
+}
+
+func (t *jsonTranscript) close() error {
+	closing := "\n]\n"
+	if t.count == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(t.w, closing)
+	return err
+}
+
+// exportMessageDTO is the structured form of a message used by the CSV and JSON exports
+func exportMessageDTO(scope *exportScope, record *exportRecord) dtos.ExportMessageDTO {
+	message := record.message
+	dto := dtos.ExportMessageDTO{
+		ID:          message.ID,
+		MessageID:   message.MessageID,
+		ChatJID:     message.ChatJID,
+		Timestamp:   message.Timestamp.In(scope.location).Format(time.RFC3339),
+		FromJID:     message.FromJID,
+		Sender:      record.sender,
+		Direction:   "outgoing",
+		MessageType: message.MessageType,
+		Content:     message.Content,
+		MediaFile:   record.mediaFile,
+		Imported:    message.Imported,
+	}
+	if message.IsIncoming {
+		dto.Direction = "incoming"
+	}
+	if message.EditedAt != nil {
+		dto.EditedAt = message.EditedAt.In(scope.location).Format(time.RFC3339)
+	}
+	if record.media != nil {
+		dto.MediaID = record.media.ID
+	}
+	return dto
+}
@@ -0,0 +1,212 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/dtos"
+)
+
+// ErrInvalidMediaSource is returned when a JSON media request has no usable data or URL
+var ErrInvalidMediaSource = errors.New(constant.INVALID_MEDIA_SOURCE)
+
+// errDisallowedAddress is raised by the fetch dialer for internal destinations
+var errDisallowedAddress = errors.New("destination address is not allowed")
+
+// newFetchClient builds the HTTP client used for media_url downloads
+func newFetchClient(mc config.Media) *http.Client {
+	timeout := time.Duration(mc.FetchTimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return newGuardedClient(timeout, mc.AllowPrivateFetch)
+}
+
+// newGuardedClient builds an HTTP client for user-supplied URLs. Addresses are checked
+// after DNS resolution so rebinding tricks cannot reach internal hosts.
+func newGuardedClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("%w: %s", errDisallowedAddress, host)
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, // A proxy would hide the real destination from the dialer check
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("%w: unsupported scheme %q", errDisallowedAddress, req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// isPrivateIP reports whether ip belongs to a loopback, private, link-local or otherwise internal range
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+
+	// Carrier-grade NAT range, commonly used inside cloud networks
+	_, cgnat, _ := net.ParseCIDR("100.64.0.0/10")
+	return cgnat.Contains(ip)
+}
+
+// SendMediaFromJSON resolves base64, URL or library media and sends it like a multipart upload
+func (s *service) SendMediaFromJSON(ctx context.Context, req dtos.SendMediaJSONDTO) (*dtos.MessageResponseDTO, error) {
+	sources := 0
+	for _, set := range []bool{req.MediaData != "", req.MediaURL != "", req.MediaID != 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("%w: exactly one of media_data, media_url or media_id is required", ErrInvalidMediaSource)
+	}
+
+	if req.MediaID != 0 {
+		return s.SendMediaMessage(ctx, dtos.SendMediaMessageDTO{
+			PhoneNumber: req.PhoneNumber,
+			Caption:     req.Caption,
+			MediaID:     req.MediaID,
+			FileName:    req.FileName,
+			MimeType:    req.MimeType,
+			Height:      req.Height,
+			Width:       req.Width,
+			ViewOnce:    req.ViewOnce,
+			Voice:       req.Voice,
+			Async:       req.Async,
+
+			Transactional:      req.Transactional,
+			ScheduleDTO:        req.ScheduleDTO,
+			TemplateOptionsDTO: req.TemplateOptionsDTO,
+		})
+	}
+
+	var (
+		file *os.File
+		size int64
+		err  error
+	)
+	mimeType := req.MimeType
+	fileName := req.FileName
+
+	if req.MediaData != "" {
+		if mimeType == "" {
+			return nil, fmt.Errorf("%w: mime_type is required with media_data", ErrInvalidMediaSource)
+		}
+		decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(req.MediaData))
+		file, size, err = s.SpoolMedia(decoder, mimeType)
+		var corrupt base64.CorruptInputError
+		if errors.As(err, &corrupt) {
+			return nil, fmt.Errorf("%w: media_data is not valid base64", ErrInvalidMediaSource)
+		}
+	} else {
+		file, size, mimeType, fileName, err = s.fetchMedia(ctx, req.MediaURL, mimeType, fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	return s.SendMediaMessage(ctx, dtos.SendMediaMessageDTO{
+		PhoneNumber: req.PhoneNumber,
+		Caption:     req.Caption,
+		Media:       file,
+		FileName:    fileName,
+		FileSize:    size,
+		MimeType:    mimeType,
+		Height:      req.Height,
+		Width:       req.Width,
+		ViewOnce:    req.ViewOnce,
+		Voice:       req.Voice,
+		Async:       req.Async,
+
+		Transactional:      req.Transactional,
+		ScheduleDTO:        req.ScheduleDTO,
+		TemplateOptionsDTO: req.TemplateOptionsDTO,
+	})
+}
+
+// fetchMedia downloads rawURL into a temp file, enforcing the size cap for the resolved MIME type
+func (s *service) fetchMedia(ctx context.Context, rawURL, mimeType, fileName string) (*os.File, int64, string, string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, 0, "", "", fmt.Errorf("%w: media_url must be an absolute http(s) URL", ErrInvalidMediaSource)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, 0, "", "", fmt.Errorf("%w: %v", ErrInvalidMediaSource, err)
+	}
+
+	resp, err := s.fetcher.Do(httpReq)
+	if err != nil {
+		if errors.Is(err, errDisallowedAddress) {
+			return nil, 0, "", "", fmt.Errorf("%w: media_url points to a disallowed address", ErrInvalidMediaSource)
+		}
+		return nil, 0, "", "", fmt.Errorf(constant.MEDIA_FETCH_FAILED+": %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, "", "", fmt.Errorf(constant.MEDIA_FETCH_FAILED+": remote returned %s", resp.Status)
+	}
+
+	if mimeType == "" {
+		mimeType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	if fileName == "" {
+		fileName = path.Base(parsed.Path)
+	}
+
+	// Refuse early when the server announces a body over the cap
+	if resp.ContentLength > s.MediaSizeLimit(mimeType) {
+		return nil, 0, "", "", ErrMediaTooLarge
+	}
+
+	file, size, err := s.SpoolMedia(resp.Body, mimeType)
+	if err != nil {
+		return nil, 0, "", "", err
+	}
+	return file, size, mimeType, fileName, nil
+}
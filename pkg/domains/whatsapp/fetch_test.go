@@ -0,0 +1,188 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/crm/pkg/config"
+)
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "127.0.0.1", want: true},
+		{ip: "::1", want: true},
+		{ip: "10.1.2.3", want: true},
+		{ip: "172.16.0.1", want: true},
+		{ip: "192.168.1.1", want: true},
+		{ip: "169.254.169.254", want: true},
+		{ip: "100.64.0.1", want: true},
+		{ip: "100.127.255.255", want: true},
+		{ip: "0.0.0.0", want: true},
+		{ip: "::", want: true},
+		{ip: "224.0.0.1", want: true},
+		{ip: "fe80::1", want: true},
+		{ip: "fd00::1", want: true},
+		{ip: "::ffff:127.0.0.1", want: true},
+		{ip: "8.8.8.8", want: false},
+		{ip: "100.128.0.1", want: false},
+		{ip: "172.32.0.1", want: false},
+		{ip: "2001:4860:4860::8888", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := isPrivateIP(net.ParseIP(tt.ip)); got != tt.want {
+				t.Fatalf("isPrivateIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchMedia(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.png":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, "png-bytes")
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(make([]byte, megabyte+1))
+		case "/untyped":
+			w.Header().Set("Content-Type", "")
+			w.Write([]byte{0, 1, 2})
+		case "/redirect":
+			http.Redirect(w, r, "/photo.png", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		allowPrivate bool
+		url          string
+		mimeType     string
+		fileName     string
+		wantMime     string
+		wantName     string
+		wantBody     string
+		wantErr      error
+		wantErrText  string
+	}{
+		{
+			name:         "type and name from the response",
+			allowPrivate: true,
+			url:          server.URL + "/photo.png",
+			wantMime:     "image/png",
+			wantName:     "photo.png",
+			wantBody:     "png-bytes",
+		},
+		{
+			name:         "caller type and name win",
+			allowPrivate: true,
+			url:          server.URL + "/photo.png",
+			mimeType:     "application/pdf",
+			fileName:     "scan.pdf",
+			wantMime:     "application/pdf",
+			wantName:     "scan.pdf",
+			wantBody:     "png-bytes",
+		},
+		{
+			name:         "missing type falls back to octet-stream",
+			allowPrivate: true,
+			url:          server.URL + "/untyped",
+			wantMime:     "application/octet-stream",
+			wantName:     "untyped",
+			wantBody:     "\x00\x01\x02",
+		},
+		{
+			name:         "redirects are followed",
+			allowPrivate: true,
+			url:          server.URL + "/redirect",
+			wantMime:     "image/png",
+			wantName:     "redirect",
+			wantBody:     "png-bytes",
+		},
+		{
+			name:         "remote error status",
+			allowPrivate: true,
+			url:          server.URL + "/missing",
+			wantErrText:  "404",
+		},
+		{
+			name:         "over the cap for the type",
+			allowPrivate: true,
+			url:          server.URL + "/large.png",
+			wantErr:      ErrMediaTooLarge,
+		},
+		{
+			name:         "not an http url",
+			allowPrivate: true,
+			url:          "ftp://example.com/file.png",
+			wantErr:      ErrInvalidMediaSource,
+		},
+		{
+			name:    "relative url",
+			url:     "/photo.png",
+			wantErr: ErrInvalidMediaSource,
+		},
+		{
+			name:    "loopback blocked by the guard",
+			url:     server.URL + "/photo.png",
+			wantErr: ErrInvalidMediaSource,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := config.Media{TempDir: t.TempDir(), MaxImageMB: 1, AllowPrivateFetch: tt.allowPrivate}
+			s := &service{media: newMediaLimits(mc), fetcher: newFetchClient(mc)}
+
+			file, size, mimeType, fileName, err := s.fetchMedia(context.Background(), tt.url, tt.mimeType, tt.fileName)
+			if tt.wantErr != nil || tt.wantErrText != "" {
+				if err == nil {
+					t.Fatalf("fetchMedia succeeded, want error")
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("fetchMedia error = %v, want %v", err, tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErrText) {
+					t.Fatalf("fetchMedia error = %v, want it to mention %q", err, tt.wantErrText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchMedia: %v", err)
+			}
+			defer func() {
+				file.Close()
+				os.Remove(file.Name())
+			}()
+
+			body, err := io.ReadAll(file)
+			if err != nil {
+				t.Fatalf("reading spooled file: %v", err)
+			}
+			if string(body) != tt.wantBody || size != int64(len(tt.wantBody)) {
+				t.Fatalf("body = %q (size %d), want %q", body, size, tt.wantBody)
+			}
+			if mimeType != tt.wantMime {
+				t.Fatalf("mime type = %q, want %q", mimeType, tt.wantMime)
+			}
+			if fileName != tt.wantName {
+				t.Fatalf("file name = %q, want %q", fileName, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrMessageNotFound is returned when a stored message does not exist or belongs to another user
+	ErrMessageNotFound = errors.New(constant.MESSAGE_NOT_FOUND)
+	// ErrForwardNotSupported is returned for stored messages whose content cannot be rebuilt
+	ErrForwardNotSupported = errors.New(constant.FORWARD_NOT_SUPPORTED)
+)
+
+// ForwardMessage re-sends a stored message to every target, marked as forwarded.
+// Targets fail independently; the result lists the outcome of each.
+func (s *service) ForwardMessage(ctx context.Context, req dtos.ForwardMessageDTO) ([]dtos.ForwardResultDTO, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var original entities.WhatsAppMessage
+	err = database.DBClient().WithContext(ctx).Where("id = ? AND user_id = ?", req.MessageID, session.UserID).First(&original).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %v", err)
+	}
+
+	msg, err := s.forwardContent(ctx, session, &original)
+	if err != nil {
+		return nil, err
+	}
+	score := original.ForwardingScore + 1
+	setForwarded(msg, score)
+
+	results := make([]dtos.ForwardResultDTO, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		result := dtos.ForwardResultDTO{Target: target, Status: constant.RECIPIENT_STATUS_SENT}
+
+		resp, err := s.forwardTo(ctx, session, target, proto.Clone(msg).(*waProto.Message), &original)
+		if err != nil {
+			result.Status = constant.RECIPIENT_STATUS_FAILED
+			result.Error = err.Error()
+		} else {
+			result.MessageID = resp.ID
+			result.Timestamp = resp.Timestamp.Format(time.RFC3339)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// forwardTo sends msg to one target and links the copy stored in history to the original
+func (s *service) forwardTo(ctx context.Context, session *UserSession, target string, msg *waProto.Message, original *entities.WhatsAppMessage) (whatsmeow.SendResponse, error) {
+	phoneNumber, chatJID := target, ""
+	if strings.Contains(target, "@") {
+		phoneNumber, chatJID = "", target
+	}
+	chat, err := s.resolveChat(phoneNumber, chatJID)
+	if err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+
+	resp, err := s.sendTo(ctx, session, chat, msg)
+	if err != nil {
+		return whatsmeow.SendResponse{}, fmt.Errorf("failed to forward message: %v", err)
+	}
+
+	err = database.DBClient().WithContext(ctx).Model(&entities.WhatsAppMessage{}).
+		Where("user_id = ? AND chat_jid = ? AND message_id = ?", session.UserID, chat.String(), resp.ID).
+		Update("forwarded_from_id", original.ID).Error
+	if err != nil {
+		// The message is already out; only its link to the original is missing
+		log.Printf("Failed to record forward of message %d by user %d: %v", original.ID, session.UserID, err)
+	}
+
+	log.Printf("Message %d forwarded by user %d to %s. ID: %s", original.ID, session.UserID, chat, resp.ID)
+	return resp, nil
+}
+
+// forwardContent rebuilds the content of a stored message for sending
+func (s *service) forwardContent(ctx context.Context, session *UserSession, original *entities.WhatsAppMessage) (*waProto.Message, error) {
+	if original.MessageType == "text" {
+		return &waProto.Message{
+			ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String(original.Content)},
+		}, nil
+	}
+
+	var media entities.WhatsAppMessageMedia
+	err := database.DBClient().WithContext(ctx).Where("message_record_id = ?", original.ID).First(&media).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrForwardNotSupported, original.MessageType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get media: %v", err)
+	}
+
+	uploaded, err := s.forwardUpload(ctx, session, &media, original.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	if media.Kind == "sticker" {
+		return &waProto.Message{
+			StickerMessage: &waProto.StickerMessage{
+				URL:           &uploaded.URL,
+				Mimetype:      &media.MimeType,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    &uploaded.FileLength,
+				DirectPath:    &uploaded.DirectPath,
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+			},
+		}, nil
+	}
+	return buildMediaMessage(mediaTypeFor(media.MimeType), uploaded, dtos.SendMediaMessageDTO{
+		Caption:  original.Content,
+		FileName: media.FileName,
+		MimeType: media.MimeType,
+	}), nil
+}
+
+// forwardUpload re-uses the original media keys while the CDN still holds the file,
+// and otherwise uploads the stored copy again
+func (s *service) forwardUpload(ctx context.Context, session *UserSession, media *entities.WhatsAppMessageMedia, sentAt time.Time) (whatsmeow.UploadResponse, error) {
+	if media.DirectPath != "" && len(media.MediaKey) > 0 && time.Since(sentAt) < s.media.libraryTTL {
+		return whatsmeow.UploadResponse{
+			URL:           "https://mmg.whatsapp.net" + media.DirectPath,
+			DirectPath:    media.DirectPath,
+			MediaKey:      media.MediaKey,
+			FileEncSHA256: media.FileEncSHA256,
+			FileSHA256:    media.FileSHA256,
+			FileLength:    media.FileLength,
+		}, nil
+	}
+
+	if media.Status != constant.MEDIA_STATUS_STORED {
+		return whatsmeow.UploadResponse{}, fmt.Errorf("%w: the attachment is no longer available", ErrMediaNotFound)
+	}
+	content, err := s.store.Get(ctx, media.StorageKey)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	defer content.Close()
+
+	uploaded, err := s.uploadMedia(ctx, session, dtos.SendMediaMessageDTO{
+		Media:    content,
+		FileSize: int64(media.FileLength),
+		MimeType: media.MimeType,
+	}, whatsmeow.MediaType(media.MediaType))
+	if err != nil {
+		return whatsmeow.UploadResponse{}, fmt.Errorf(constant.MEDIA_UPLOAD_FAILED+": %v", err)
+	}
+	return uploaded, nil
+}
+
+// setForwarded marks msg as forwarded so recipients see the "Forwarded" label
+func setForwarded(msg *waProto.Message, score uint32) {
+	contextInfo := &waProto.ContextInfo{
+		IsForwarded:     proto.Bool(true),
+		ForwardingScore: proto.Uint32(score),
+	}
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		msg.ExtendedTextMessage.ContextInfo = contextInfo
+	case msg.GetImageMessage() != nil:
+		msg.ImageMessage.ContextInfo = contextInfo
+	case msg.GetVideoMessage() != nil:
+		msg.VideoMessage.ContextInfo = contextInfo
+	case msg.GetAudioMessage() != nil:
+		msg.AudioMessage.ContextInfo = contextInfo
+	case msg.GetDocumentMessage() != nil:
+		msg.DocumentMessage.ContextInfo = contextInfo
+	case msg.GetStickerMessage() != nil:
+		msg.StickerMessage.ContextInfo = contextInfo
+	}
+}
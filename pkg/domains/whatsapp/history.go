@@ -0,0 +1,232 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/entities"
+	"github.com/crm/pkg/utils"
+	"go.mau.fi/whatsmeow/proto/waCompanionReg"
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm/clause"
+)
+
+// historySettings limits what a history sync imports
+type historySettings struct {
+	maxAge        time.Duration // Zero imports everything
+	downloadMedia bool
+}
+
+func newHistorySettings(hc config.History) historySettings {
+	settings := historySettings{downloadMedia: hc.DownloadMedia}
+	if hc.MaxDays > 0 {
+		settings.maxAge = time.Duration(hc.MaxDays) * 24 * time.Hour
+
+		// Ask the phone for no more than we keep; the cutoff below still applies
+		// because phones treat the limit as a hint
+		store.DeviceProps.HistorySyncConfig = &waCompanionReg.DeviceProps_HistorySyncConfig{
+			FullSyncDaysLimit: proto.Uint32(uint32(hc.MaxDays)),
+		}
+	}
+	return settings
+}
+
+// historySyncBacklog is how many received chunks may wait for the import worker before
+// new ones make the event dispatcher wait
+const historySyncBacklog = 16
+
+// historySyncWait is how long the event dispatcher waits for room in a full backlog
+// before it skips the chunk, so a slow import cannot stall the connection
+const historySyncWait = 10 * time.Second
+
+// enqueueHistorySync hands a chunk to the session's import worker. It runs on whatsmeow's
+// dispatch goroutine; a chunk that finds no room in time is recorded as skipped.
+func (s *service) enqueueHistorySync(session *UserSession, evt *events.HistorySync) {
+	select {
+	case session.HistorySync <- evt:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(historySyncWait)
+	defer timer.Stop()
+	select {
+	case session.HistorySync <- evt:
+		return
+	case <-timer.C:
+	case <-session.Ctx.Done():
+		return
+	}
+
+	chunk := newHistoryChunk(session.UserID, evt)
+	chunk.Status = constant.HISTORY_SYNC_STATUS_SKIPPED
+	chunk.Error = "import backlog full"
+	for _, conversation := range evt.Data.GetConversations() {
+		chunk.Skipped += len(conversation.GetMessages())
+	}
+	now := time.Now()
+	chunk.CompletedAt = &now
+	if err := recordHistoryChunk(chunk); err != nil {
+		log.Printf("Failed to record history sync chunk %d for user %d: %v", chunk.ChunkOrder, session.UserID, err)
+	}
+	log.Printf("History sync %s chunk %d for user %d skipped: import backlog full for %s",
+		chunk.SyncType, chunk.ChunkOrder, session.UserID, historySyncWait)
+}
+
+// newHistoryChunk describes a received chunk before it is imported
+func newHistoryChunk(userID uint, evt *events.HistorySync) *entities.WhatsAppHistorySync {
+	data := evt.Data
+	return &entities.WhatsAppHistorySync{
+		UserID:        userID,
+		SyncType:      data.GetSyncType().String(),
+		ChunkOrder:    data.GetChunkOrder(),
+		Progress:      data.GetProgress(),
+		Conversations: len(data.GetConversations()),
+		Status:        constant.HISTORY_SYNC_STATUS_PROCESSING,
+	}
+}
+
+// recordHistoryChunk stores the progress row of a chunk. A new pairing sends the same
+// chunks again; they replace the earlier progress.
+func recordHistoryChunk(chunk *entities.WhatsAppHistorySync) error {
+	return database.DBClient().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "sync_type"}, {Name: "chunk_order"}},
+		DoUpdates: clause.AssignmentColumns([]string{"progress", "conversations", "imported", "duplicates", "skipped", "status", "error", "completed_at", "updated_at"}),
+	}).Create(chunk).Error
+}
+
+// runHistorySync imports the history sync chunks of a session one at a time, in the
+// order whatsmeow delivered them
+func (s *service) runHistorySync(session *UserSession) {
+	for {
+		select {
+		case <-session.Ctx.Done():
+			return
+		case evt := <-session.HistorySync:
+			s.handleHistorySync(session, evt)
+		}
+	}
+}
+
+// handleHistorySync imports one history sync chunk
+func (s *service) handleHistorySync(session *UserSession, evt *events.HistorySync) {
+	data := evt.Data
+	chunk := newHistoryChunk(session.UserID, evt)
+	if err := recordHistoryChunk(chunk); err != nil {
+		log.Printf("Failed to record history sync chunk %d for user %d: %v", chunk.ChunkOrder, session.UserID, err)
+		return
+	}
+
+	var cutoff time.Time
+	if s.history.maxAge > 0 {
+		cutoff = time.Now().Add(-s.history.maxAge)
+	}
+
+	for _, conversation := range data.GetConversations() {
+		if err := s.importConversation(session, conversation, cutoff, chunk); err != nil {
+			chunk.Status = constant.HISTORY_SYNC_STATUS_FAILED
+			chunk.Error = err.Error()
+			break
+		}
+	}
+	if chunk.Status == constant.HISTORY_SYNC_STATUS_PROCESSING {
+		chunk.Status = constant.HISTORY_SYNC_STATUS_COMPLETED
+	}
+	now := time.Now()
+	chunk.CompletedAt = &now
+	err := database.DBClient().Model(chunk).Select("imported", "duplicates", "skipped", "status", "error", "completed_at").Updates(chunk).Error
+	if err != nil {
+		log.Printf("Failed to update history sync chunk %d for user %d: %v", chunk.ChunkOrder, session.UserID, err)
+	}
+
+	log.Printf("History sync %s chunk %d for user %d: %d imported, %d duplicates, %d skipped (%d%%)",
+		chunk.SyncType, chunk.ChunkOrder, session.UserID, chunk.Imported, chunk.Duplicates, chunk.Skipped, chunk.Progress)
+}
+
+// importConversation stores the messages of one synced chat, newer than cutoff
+func (s *service) importConversation(session *UserSession, conversation *waHistorySync.Conversation, cutoff time.Time, chunk *entities.WhatsAppHistorySync) error {
+	chat, err := types.ParseJID(conversation.GetID())
+	if err != nil {
+		chunk.Skipped += len(conversation.GetMessages())
+		return nil
+	}
+
+	// Stored before the messages so their expiry can fall back to the chat's timer
+	state := map[string]interface{}{"disappearing_timer": conversation.GetEphemeralExpiration()}
+	if name := conversation.GetName(); name != "" {
+		state["name"] = name
+	}
+	if err := saveChatState(session.UserID, chat, state); err != nil {
+		log.Printf("Failed to store state of %s for user %d: %v", chat, session.UserID, err)
+	}
+
+	var parsed []*events.Message
+	for _, item := range conversation.GetMessages() {
+		evt, err := session.Client.ParseWebMessage(chat, item.GetMessage())
+		if err != nil || !renderable(evt.Message) || evt.Info.Timestamp.Before(cutoff) {
+			chunk.Skipped++
+			continue
+		}
+		parsed = append(parsed, evt)
+	}
+
+	// The phone reports how many of the newest messages are unread; older ones were read there
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[i].Info.Timestamp.After(parsed[j].Info.Timestamp)
+	})
+	unread := int(conversation.GetUnreadCount())
+
+	for _, evt := range parsed {
+		record := s.incomingRecord(session, evt)
+		record.Imported = true
+		if record.IsIncoming {
+			if unread > 0 {
+				unread--
+			} else {
+				readAt := record.Timestamp
+				record.ReadAt = &readAt
+			}
+		}
+
+		created, err := insertMessage(record)
+		if err != nil {
+			return err
+		}
+		if !created {
+			chunk.Duplicates++
+			continue
+		}
+		chunk.Imported++
+
+		media, d := recordIncomingMedia(session, evt, record)
+		if media != nil && s.history.downloadMedia {
+			s.downloadIncomingMedia(session, media, d)
+		}
+	}
+	return nil
+}
+
+// ListHistorySyncs returns the import progress of history sync chunks, newest first
+func (s *service) ListHistorySyncs(ctx context.Context, page int) ([]entities.WhatsAppHistorySync, int, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("authentication required: %v", err)
+	}
+
+	var chunks []entities.WhatsAppHistorySync
+	totalPages, err := utils.Pagination(&chunks, page, database.DBClient().Order("updated_at desc"), ctx, "user_id = ?", userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return chunks, totalPages, nil
+}
@@ -0,0 +1,165 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrIdempotencyKeyInvalid is returned for empty or oversized keys
+	ErrIdempotencyKeyInvalid = errors.New(constant.IDEMPOTENCY_KEY_INVALID)
+	// ErrIdempotencyKeyMismatch is returned when a key is reused with a different payload
+	ErrIdempotencyKeyMismatch = errors.New(constant.IDEMPOTENCY_KEY_MISMATCH)
+	// ErrIdempotencyKeyInProgress is returned while the first request with a key is still running
+	ErrIdempotencyKeyInProgress = errors.New(constant.IDEMPOTENCY_KEY_IN_PROGRESS)
+)
+
+// idempotencyPendingLease is how long a claim on a key lasts without being renewed. A
+// running request renews it, so only a request that died with the process lets a retry
+// take the key over, however long pacing or an upload keeps the send busy.
+const idempotencyPendingLease = 5 * time.Minute
+
+// idempotencyLeaseRenewal is how often a running request renews its claim
+const idempotencyLeaseRenewal = time.Minute
+
+func idempotencyTTL(cfg config.WhatsApp) time.Duration {
+	ttl := time.Duration(cfg.IdempotencyTTLHours) * time.Hour
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return ttl
+}
+
+// Idempotent runs send at most once per key within the TTL window. A replay with the same
+// fingerprint returns the stored response and replayed=true; failed sends release the key
+// so the client can retry them.
+func (s *service) Idempotent(ctx context.Context, key, endpoint, fingerprint string, send func() (*dtos.MessageResponseDTO, error)) (*dtos.MessageResponseDTO, bool, error) {
+	if key == "" {
+		response, err := send()
+		return response, false, err
+	}
+	if len(key) > 255 {
+		return nil, false, ErrIdempotencyKeyInvalid
+	}
+
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("authentication required: %v", err)
+	}
+
+	db := database.DBClient().WithContext(ctx)
+
+	// Expired keys and abandoned claims may be reused
+	now := time.Now()
+	db.Where("user_id = ? AND idempotency_key = ?", userID, key).
+		Where("expires_at <= ? OR (status = ? AND COALESCE(updated_at, created_at) <= ?)",
+			now, constant.IDEMPOTENCY_STATUS_PENDING, now.Add(-idempotencyPendingLease)).
+		Delete(&entities.WhatsAppIdempotencyKey{})
+
+	record := &entities.WhatsAppIdempotencyKey{
+		UserID:      userID,
+		Key:         key,
+		Endpoint:    endpoint,
+		Fingerprint: fingerprint,
+		Status:      constant.IDEMPOTENCY_STATUS_PENDING,
+		ExpiresAt:   now.Add(s.idempotencyTTL),
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("failed to store idempotency key: %v", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return s.replayIdempotent(ctx, userID, key, endpoint, fingerprint)
+	}
+
+	renewCtx, stopRenewal := context.WithCancel(context.Background())
+	go renewIdempotencyClaim(renewCtx, record.ID)
+	response, err := send()
+	stopRenewal()
+	if err != nil {
+		db.Delete(record)
+		return nil, false, err
+	}
+
+	encoded, _ := json.Marshal(response)
+	record.Status = constant.IDEMPOTENCY_STATUS_COMPLETED
+	record.Response = string(encoded)
+	if err := db.Save(record).Error; err != nil {
+		// The message went out; a lost record only weakens protection against a later replay
+		log.Printf("Failed to store response for idempotency key of user %d: %v", userID, err)
+	}
+	return response, false, nil
+}
+
+// renewIdempotencyClaim keeps a pending key claimed until ctx is cancelled
+func renewIdempotencyClaim(ctx context.Context, id uint) {
+	ticker := time.NewTicker(idempotencyLeaseRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := database.DBClient().Model(&entities.WhatsAppIdempotencyKey{}).
+				Where("id = ? AND status = ?", id, constant.IDEMPOTENCY_STATUS_PENDING).
+				Update("updated_at", time.Now()).Error
+			if err != nil {
+				log.Printf("Failed to renew idempotency key %d: %v", id, err)
+			}
+		}
+	}
+}
+
+// replayIdempotent returns the stored outcome of an earlier request with the same key
+func (s *service) replayIdempotent(ctx context.Context, userID uint, key, endpoint, fingerprint string) (*dtos.MessageResponseDTO, bool, error) {
+	var existing entities.WhatsAppIdempotencyKey
+	err := database.DBClient().WithContext(ctx).Where("user_id = ? AND idempotency_key = ?", userID, key).First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		// The first request failed and released the key in the meantime
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load idempotency key: %v", err)
+	}
+
+	if existing.Endpoint != endpoint || existing.Fingerprint != fingerprint {
+		return nil, false, ErrIdempotencyKeyMismatch
+	}
+	if existing.Status != constant.IDEMPOTENCY_STATUS_COMPLETED {
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+
+	var response dtos.MessageResponseDTO
+	if err := json.Unmarshal([]byte(existing.Response), &response); err != nil {
+		return nil, false, fmt.Errorf("failed to decode stored response: %v", err)
+	}
+	log.Printf("Replayed %s response for idempotency key of user %d", endpoint, userID)
+	return &response, true, nil
+}
+
+// purgeIdempotencyKeys hourly removes keys whose replay window has passed
+func (s *service) purgeIdempotencyKeys() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result := database.DBClient().Where("expires_at <= ?", time.Now()).Delete(&entities.WhatsAppIdempotencyKey{})
+		if result.Error != nil {
+			log.Printf("Failed to purge expired idempotency keys: %v", result.Error)
+		}
+	}
+}
@@ -0,0 +1,570 @@
+package whatsapp
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidImport is returned for uploads that are not a readable WhatsApp chat export
+var ErrInvalidImport = errors.New(constant.INVALID_IMPORT)
+
+var (
+	// Android: "31/01/2024, 14:05 - Name: text", "1/31/24, 2:05 PM - Name: text"
+	importAndroidLine = regexp.MustCompile(`^(\d{1,4}[./-]\d{1,2}[./-]\d{1,4}),? (\d{1,2}[:.]\d{2}(?:[:.]\d{2})?)(?: ?([AaPp])\.? ?[Mm]\.?)? [-–] (.*)$`)
+	// iOS: "[31/01/2024, 14:05:33] Name: text", "[1/31/24, 2:05:33 PM] Name: text"
+	importIOSLine = regexp.MustCompile(`^\[(\d{1,4}[./-]\d{1,2}[./-]\d{1,4}),? (\d{1,2}[:.]\d{2}(?:[:.]\d{2})?)(?: ?([AaPp])\.? ?[Mm]\.?)?\] (.*)$`)
+	// Attachments: Android "IMG-20240131-WA0007.jpg (file attached)", iOS "<attached: 00000012-PHOTO-2024-01-31-14-05-33.jpg>"
+	importAttachedAndroid = regexp.MustCompile(`^(.+?) \(file attached\)$`)
+	importAttachedIOS     = regexp.MustCompile(`^<attached: (.+?)>$`)
+	// iOS writes the kind of an omitted attachment, e.g. "image omitted"
+	importOmittedIOS = regexp.MustCompile(`^(image|video|audio|sticker|document|GIF) omitted$`)
+	importDateSplit  = regexp.MustCompile(`[./-]`)
+
+	// importInvisibles strips the direction marks iOS adds and normalizes the narrow
+	// spaces newer exports put before AM/PM
+	importInvisibles = strings.NewReplacer("\u200e", "", "\u200f", "", "\ufeff", "", "\u202f", " ", "\u00a0", " ")
+)
+
+// importExtensions covers attachment extensions the mime package does not know
+var importExtensions = map[string]string{
+	".opus": "audio/ogg; codecs=opus",
+	".webp": "image/webp",
+	".m4a":  "audio/mp4",
+}
+
+// importLine is one message of a transcript before its date is interpreted
+type importLine struct {
+	date, clock, meridiem string
+	sender                string
+	text                  string
+}
+
+// parseExportTranscript splits a phone export into messages. Lines that do not start with
+// a timestamp continue the previous message; system notices without a sender are dropped.
+func parseExportTranscript(r io.Reader) ([]*importLine, int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []*importLine
+	var current *importLine
+	skipped := 0
+	for scanner.Scan() {
+		line := importInvisibles.Replace(strings.TrimRight(scanner.Text(), "\r"))
+
+		match := importAndroidLine.FindStringSubmatch(line)
+		if match == nil {
+			match = importIOSLine.FindStringSubmatch(line)
+		}
+		if match == nil {
+			if current != nil {
+				current.text += "\n" + line
+			}
+			continue
+		}
+
+		sender, text, ok := strings.Cut(match[4], ": ")
+		if !ok {
+			// "Messages and calls are end-to-end encrypted", "X added Y", ...
+			current = nil
+			skipped++
+			continue
+		}
+		current = &importLine{date: match[1], clock: match[2], meridiem: strings.ToUpper(match[3]), sender: sender, text: text}
+		lines = append(lines, current)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	return lines, skipped, nil
+}
+
+// detectDateOrder tells day-first from month-first dates by looking for a component above 12.
+// Ambiguous files use fallback.
+func detectDateOrder(lines []*importLine, fallback string) string {
+	for _, line := range lines {
+		parts := importDateSplit.Split(line.date, -1)
+		if len(parts) != 3 {
+			continue
+		}
+		if len(parts[0]) == 4 {
+			return "ymd"
+		}
+		first, _ := strconv.Atoi(parts[0])
+		second, _ := strconv.Atoi(parts[1])
+		switch {
+		case first > 12:
+			return "dmy"
+		case second > 12:
+			return "mdy"
+		}
+	}
+	return fallback
+}
+
+// parseImportTime interprets the date and clock of a transcript line in loc
+func parseImportTime(line *importLine, order string, loc *time.Location) (time.Time, error) {
+	parts := importDateSplit.Split(line.date, -1)
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("invalid date %q", line.date)
+	}
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", line.date)
+		}
+		numbers[i] = n
+	}
+
+	var year, month, day int
+	switch order {
+	case "ymd":
+		year, month, day = numbers[0], numbers[1], numbers[2]
+	case "mdy":
+		month, day, year = numbers[0], numbers[1], numbers[2]
+	default:
+		day, month, year = numbers[0], numbers[1], numbers[2]
+	}
+	if year < 100 {
+		year += 2000
+	}
+
+	clock := strings.Split(strings.ReplaceAll(line.clock, ".", ":"), ":")
+	hour, _ := strconv.Atoi(clock[0])
+	minute, _ := strconv.Atoi(clock[1])
+	second := 0
+	if len(clock) == 3 {
+		second, _ = strconv.Atoi(clock[2])
+	}
+	switch {
+	case line.meridiem == "P" && hour < 12:
+		hour += 12
+	case line.meridiem == "A" && hour == 12:
+		hour = 0
+	}
+
+	// time.Date would roll a day past the end of the month, e.g. 31/02, into the next one
+	lastDay := time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if month < 1 || month > 12 || day < 1 || day > lastDay || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q %q", line.date, line.clock)
+	}
+	return time.Date(year, time.Month(month), day, hour, minute, second, 0, loc), nil
+}
+
+// importAttachment returns the attachment named by the text of a message and the remaining caption
+func importAttachment(text string) (file, kind, caption string) {
+	first, rest, _ := strings.Cut(text, "\n")
+	if match := importAttachedAndroid.FindStringSubmatch(first); match != nil {
+		return match[1], importKind(match[1]), rest
+	}
+	if match := importAttachedIOS.FindStringSubmatch(first); match != nil {
+		return match[1], importKind(match[1]), rest
+	}
+	if first == exportMediaOmitted {
+		// Android does not say what was omitted
+		return "", "unknown", first
+	}
+	if match := importOmittedIOS.FindStringSubmatch(first); match != nil {
+		kind := match[1]
+		if kind == "GIF" {
+			kind = "video"
+		}
+		return "", kind, rest
+	}
+	return "", "", text
+}
+
+// importMimeType guesses the MIME type of a bundled attachment from its extension
+func importMimeType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if mimeType, ok := importExtensions[ext]; ok {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+func importKind(name string) string {
+	mimeType := importMimeType(name)
+	switch {
+	case strings.HasPrefix(name, "STK-") || strings.Contains(name, "-STICKER-") || mimeType == "image/webp":
+		return "sticker"
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	default:
+		return "document"
+	}
+}
+
+// ownJID returns the account's own JID, from the session when there is one and otherwise
+// from the device JID stored when it last connected. The session is nil in the latter case.
+func (s *service) ownJID(ctx context.Context, userID uint) (types.JID, *UserSession, error) {
+	s.mutex.RLock()
+	session, exists := s.sessions[userID]
+	s.mutex.RUnlock()
+	if exists && session.Client != nil && session.Client.Store.ID != nil {
+		return session.Client.Store.ID.ToNonAD(), session, nil
+	}
+
+	var device entities.WhatsAppDevice
+	err := database.DBClient().WithContext(ctx).Where("user_id = ? AND jid <> ''", userID).First(&device).Error
+	if err == gorm.ErrRecordNotFound {
+		return types.JID{}, nil, fmt.Errorf(constant.WHATSAPP_NOT_CONNECTED)
+	}
+	if err != nil {
+		return types.JID{}, nil, fmt.Errorf("failed to get device: %v", err)
+	}
+	jid, err := types.ParseJID(device.JID)
+	if err != nil {
+		return types.JID{}, nil, fmt.Errorf("invalid stored device JID %q: %v", device.JID, err)
+	}
+	return jid.ToNonAD(), nil, nil
+}
+
+// saveDeviceJID remembers the JID a user's device is paired as
+func saveDeviceJID(userID uint, jid types.JID) {
+	device := entities.WhatsAppDevice{UserID: userID, JID: jid.ToNonAD().String()}
+	err := database.DBClient().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"jid", "updated_at"}),
+	}).Create(&device).Error
+	if err != nil {
+		log.Printf("Failed to store device JID of user %d: %v", userID, err)
+	}
+}
+
+// importMessageID derives a stable ID so importing the same export twice stores nothing new
+func importMessageID(chat string, sentAt time.Time, sender, text string, ordinal int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s\x00%s\x00%d", chat, sentAt.Unix(), sender, text, ordinal)))
+	return "IMPORT-" + strings.ToUpper(hex.EncodeToString(sum[:10]))
+}
+
+// ImportChat parses a WhatsApp "Export chat" .txt, or a ZIP with the transcript and its
+// attachments, and stores the messages in the given chat marked as imported.
+func (s *service) ImportChat(ctx context.Context, req dtos.ImportDTO) (*dtos.ImportResultDTO, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	own, session, err := s.ownJID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := s.resolveChat(req.PhoneNumber, req.ChatJID)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := time.UTC
+	if req.TimeZone != "" {
+		if loc, err = time.LoadLocation(req.TimeZone); err != nil {
+			return nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalidImport, req.TimeZone)
+		}
+	}
+	switch req.DateOrder {
+	case "":
+		req.DateOrder = "dmy"
+	case "dmy", "mdy", "ymd":
+	default:
+		return nil, fmt.Errorf("%w: date_order must be dmy, mdy or ymd", ErrInvalidImport)
+	}
+
+	transcript, attachments, err := openExport(req)
+	if err != nil {
+		return nil, err
+	}
+	defer transcript.Close()
+
+	lines, skipped, err := parseExportTranscript(transcript)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: no messages found", ErrInvalidImport)
+	}
+
+	self := own.String()
+	senders, err := s.importSenders(ctx, session, chat, self, lines, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &dtos.ImportResultDTO{ChatJID: chat.String(), Skipped: skipped, Senders: senders}
+	order := detectDateOrder(lines, req.DateOrder)
+
+	ordinals := map[string]int{}
+	for _, line := range lines {
+		sentAt, err := parseImportTime(line, order, loc)
+		if err != nil {
+			result.Skipped++
+			continue
+		}
+
+		text, edited := strings.CutSuffix(line.text, exportEdited)
+		file, kind, content := importAttachment(text)
+		messageType := "text"
+		if kind != "" {
+			messageType = kind
+		} else {
+			content = text
+		}
+
+		// Exports have minute precision; keep the file order of messages sharing a minute
+		key := sentAt.Format(time.RFC3339)
+		ordinal := ordinals[key]
+		ordinals[key]++
+
+		from := senders[line.sender]
+		record := &entities.WhatsAppMessage{
+			UserID:         userID,
+			MessageID:      importMessageID(chat.String(), sentAt, line.sender, line.text, ordinal),
+			ChatJID:        chat.String(),
+			FromJID:        from,
+			ToJID:          chat.String(),
+			Content:        content,
+			MessageType:    messageType,
+			Timestamp:      sentAt.Add(time.Duration(ordinal) * time.Millisecond),
+			IsIncoming:     from != self,
+			Imported:       true,
+			SearchLanguage: s.searchLanguage,
+		}
+		if record.IsIncoming {
+			if chat.Server != types.GroupServer {
+				record.ToJID = self
+			}
+			// Exported history was read on the phone
+			readAt := record.Timestamp
+			record.ReadAt = &readAt
+		}
+		if edited {
+			editedAt := record.Timestamp
+			record.EditedAt = &editedAt
+		}
+
+		created, err := insertMessage(record)
+		if err != nil {
+			return nil, err
+		}
+		if !created {
+			result.Duplicates++
+			continue
+		}
+		result.Imported++
+
+		if entry := attachments[file]; entry != nil {
+			if err := s.importMedia(ctx, record, kind, entry); err != nil {
+				log.Printf("Failed to import attachment %s for user %d: %v", file, userID, err)
+				continue
+			}
+			result.Media++
+		}
+	}
+
+	log.Printf("Imported chat export into %s for user %d: %d messages, %d duplicates, %d skipped, %d attachments",
+		chat, userID, result.Imported, result.Duplicates, result.Skipped, result.Media)
+	return result, nil
+}
+
+// openExport returns the transcript of an upload and, for ZIP exports, the bundled files by name
+func openExport(req dtos.ImportDTO) (io.ReadCloser, map[string]*zip.File, error) {
+	header := make([]byte, 4)
+	if _, err := req.File.ReadAt(header, 0); err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+	}
+	if !bytes.Equal(header, []byte("PK\x03\x04")) {
+		return io.NopCloser(io.NewSectionReader(req.File, 0, req.FileSize)), nil, nil
+	}
+
+	archive, err := zip.NewReader(req.File, req.FileSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	var transcript *zip.File
+	attachments := map[string]*zip.File{}
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		name := path.Base(entry.Name)
+		// Android names the transcript "WhatsApp Chat with X.txt", iOS "_chat.txt"
+		if strings.EqualFold(path.Ext(name), ".txt") && (transcript == nil || name == "_chat.txt" || strings.HasPrefix(name, "WhatsApp Chat")) {
+			transcript = entry
+			continue
+		}
+		attachments[name] = entry
+	}
+	if transcript == nil {
+		return nil, nil, fmt.Errorf("%w: the ZIP contains no chat transcript", ErrInvalidImport)
+	}
+
+	content, err := transcript.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	return content, attachments, nil
+}
+
+// importSenders maps every sender name of the transcript to a JID. Explicit mappings win,
+// then the account itself, phone numbers, contact names, and finally the other party of
+// a direct chat. Without a session only explicit mappings and phone numbers are known.
+func (s *service) importSenders(ctx context.Context, session *UserSession, chat types.JID, self string, lines []*importLine, req dtos.ImportDTO) (map[string]string, error) {
+	selfNames := map[string]bool{"You": true}
+	if req.SelfName != "" {
+		selfNames[req.SelfName] = true
+	} else if session != nil && session.Client.Store.PushName != "" {
+		selfNames[session.Client.Store.PushName] = true
+	}
+
+	// Contact names live in the session's device store
+	byName := map[string]string{}
+	if session != nil {
+		contacts, err := session.Client.Store.Contacts.GetAllContacts(ctx)
+		if err != nil {
+			log.Printf("Failed to load contacts for user %d: %v", session.UserID, err)
+		}
+		for jid, contact := range contacts {
+			if name := contactName(contact); name != "" {
+				byName[name] = jid.ToNonAD().String()
+			}
+		}
+	}
+
+	senders := map[string]string{}
+	var unknown []string
+	for _, line := range lines {
+		name := line.sender
+		if _, done := senders[name]; done {
+			continue
+		}
+
+		if target, ok := req.Senders[name]; ok {
+			phoneNumber, chatJID := target, ""
+			if strings.Contains(target, "@") {
+				phoneNumber, chatJID = "", target
+			}
+			jid, err := s.resolveChat(phoneNumber, chatJID)
+			if err != nil {
+				return nil, fmt.Errorf("%w: sender %q: %v", ErrInvalidImport, name, err)
+			}
+			senders[name] = jid.String()
+			continue
+		}
+		if selfNames[name] {
+			senders[name] = self
+			continue
+		}
+		if digits := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(" -()+", r) {
+				return -1
+			}
+			return r
+		}, name); len(digits) >= 7 && len(digits) <= 15 && strings.Trim(digits, "0123456789") == "" {
+			senders[name] = types.NewJID(digits, types.DefaultUserServer).String()
+			continue
+		}
+		if jid, ok := byName[name]; ok {
+			senders[name] = jid
+			continue
+		}
+		senders[name] = ""
+		unknown = append(unknown, name)
+	}
+
+	// In a direct chat the only sender that is not the account is the other party
+	selfSeen := false
+	for _, jid := range senders {
+		selfSeen = selfSeen || jid == self
+	}
+	if chat.Server != types.GroupServer && len(unknown) == 1 {
+		senders[unknown[0]] = chat.String()
+		unknown = nil
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		hint := "map them with senders"
+		if !selfSeen && chat.Server != types.GroupServer {
+			hint = "pass self_name or map them with senders"
+		}
+		return nil, fmt.Errorf("%w: unknown senders %q; %s", ErrInvalidImport, unknown, hint)
+	}
+	return senders, nil
+}
+
+// importMedia stores a bundled attachment for an imported message
+func (s *service) importMedia(ctx context.Context, record *entities.WhatsAppMessage, kind string, entry *zip.File) error {
+	media := &entities.WhatsAppMessageMedia{
+		UserID:          record.UserID,
+		MessageRecordID: record.ID,
+		MessageID:       record.MessageID,
+		Kind:            kind,
+		MimeType:        importMimeType(entry.Name),
+		FileLength:      entry.UncompressedSize64,
+		Status:          constant.MEDIA_STATUS_PENDING,
+	}
+	if kind == "document" {
+		media.FileName = path.Base(entry.Name)
+	}
+	db := database.DBClient().WithContext(ctx)
+	if err := db.Create(media).Error; err != nil {
+		return fmt.Errorf("failed to record media: %v", err)
+	}
+	fail := func(err error) error {
+		media.Status = constant.MEDIA_STATUS_FAILED
+		media.Error = err.Error()
+		db.Save(media)
+		return err
+	}
+
+	// The declared size comes from the upload itself, so the stream is capped as well
+	limit := s.MediaSizeLimit(media.MimeType)
+	if entry.UncompressedSize64 > uint64(limit) {
+		return fail(ErrMediaTooLarge)
+	}
+	content, err := entry.Open()
+	if err != nil {
+		return fail(err)
+	}
+	defer content.Close()
+
+	// Attachment names are not used in the key; names such as "report..v2.pdf" are valid
+	// in an export but rejected as storage keys
+	key := path.Join("imported", fmt.Sprint(media.UserID), fmt.Sprintf("%d%s", media.ID, strings.ToLower(path.Ext(entry.Name))))
+	if err := s.store.Put(ctx, key, &cappedReader{r: content, limit: limit}, int64(entry.UncompressedSize64), media.MimeType); err != nil {
+		return fail(err)
+	}
+
+	media.StorageKey = key
+	media.Status = constant.MEDIA_STATUS_STORED
+	return db.Save(media).Error
+}
@@ -0,0 +1,287 @@
+package whatsapp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExportTranscript(t *testing.T) {
+	tests := []struct {
+		name        string
+		transcript  string
+		want        []importLine
+		wantSkipped int
+	}{
+		{
+			name: "android day first",
+			transcript: "31/01/2024, 14:05 - Messages and calls are end-to-end encrypted.\n" +
+				"31/01/2024, 14:05 - Ana: Hi\n" +
+				"31/01/2024, 14:06 - Bruno: Hello there\r\n",
+			want: []importLine{
+				{date: "31/01/2024", clock: "14:05", sender: "Ana", text: "Hi"},
+				{date: "31/01/2024", clock: "14:06", sender: "Bruno", text: "Hello there"},
+			},
+			wantSkipped: 1,
+		},
+		{
+			name:       "android month first with meridiem",
+			transcript: "1/31/24, 2:05 PM - Ana: Hi\n1/31/24, 2:06 a.m. - Bruno: Late reply\n",
+			want: []importLine{
+				{date: "1/31/24", clock: "2:05", meridiem: "P", sender: "Ana", text: "Hi"},
+				{date: "1/31/24", clock: "2:06", meridiem: "A", sender: "Bruno", text: "Late reply"},
+			},
+		},
+		{
+			name: "ios with direction marks",
+			transcript: "\u200e[31/01/2024, 14:05:33] Ana: \u200eimage omitted\n" +
+				"[31/01/2024, 14:05:40] Bruno: Nice!\n",
+			want: []importLine{
+				{date: "31/01/2024", clock: "14:05:33", sender: "Ana", text: "image omitted"},
+				{date: "31/01/2024", clock: "14:05:40", sender: "Bruno", text: "Nice!"},
+			},
+		},
+		{
+			name:       "continuation lines join the previous message",
+			transcript: "31/01/2024, 14:05 - Ana: First line\nsecond line\n\nfourth line\n31/01/2024, 14:06 - Bruno: Ok\n",
+			want: []importLine{
+				{date: "31/01/2024", clock: "14:05", sender: "Ana", text: "First line\nsecond line\n\nfourth line"},
+				{date: "31/01/2024", clock: "14:06", sender: "Bruno", text: "Ok"},
+			},
+		},
+		{
+			name: "continuation after a system notice is dropped",
+			transcript: "31/01/2024, 14:05 - Ana: Hi\n" +
+				"31/01/2024, 14:06 - Ana added Bruno\n" +
+				"stray text\n",
+			want: []importLine{
+				{date: "31/01/2024", clock: "14:05", sender: "Ana", text: "Hi"},
+			},
+			wantSkipped: 1,
+		},
+		{
+			name:       "text before the first message is ignored",
+			transcript: "not a transcript\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, skipped, err := parseExportTranscript(strings.NewReader(tt.transcript))
+			if err != nil {
+				t.Fatalf("parseExportTranscript: %v", err)
+			}
+			got := make([]importLine, len(lines))
+			for i, line := range lines {
+				got[i] = *line
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Fatalf("lines = %+v, want %+v", got, tt.want)
+			}
+			if skipped != tt.wantSkipped {
+				t.Fatalf("skipped = %d, want %d", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestDetectDateOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		dates    []string
+		fallback string
+		want     string
+	}{
+		{name: "day above twelve first", dates: []string{"05/01/2024", "31/01/2024"}, fallback: "mdy", want: "dmy"},
+		{name: "day above twelve second", dates: []string{"1/5/24", "1/31/24"}, fallback: "dmy", want: "mdy"},
+		{name: "year first", dates: []string{"2024-01-05"}, fallback: "mdy", want: "ymd"},
+		{name: "dotted dates", dates: []string{"13.01.24"}, fallback: "mdy", want: "dmy"},
+		{name: "ambiguous uses fallback", dates: []string{"01/02/2024", "03/04/2024"}, fallback: "mdy", want: "mdy"},
+		{name: "malformed dates are ignored", dates: []string{"2024", "1/13/24"}, fallback: "dmy", want: "mdy"},
+		{name: "no lines", fallback: "dmy", want: "dmy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []*importLine
+			for _, date := range tt.dates {
+				lines = append(lines, &importLine{date: date})
+			}
+			if got := detectDateOrder(lines, tt.fallback); got != tt.want {
+				t.Fatalf("detectDateOrder = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportTime(t *testing.T) {
+	saoPaulo := time.FixedZone("BRT", -3*60*60)
+
+	tests := []struct {
+		name    string
+		line    importLine
+		order   string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:  "day first",
+			line:  importLine{date: "31/01/2024", clock: "14:05"},
+			order: "dmy",
+			want:  time.Date(2024, 1, 31, 14, 5, 0, 0, saoPaulo),
+		},
+		{
+			name:  "month first with two digit year",
+			line:  importLine{date: "1/31/24", clock: "2:05", meridiem: "P"},
+			order: "mdy",
+			want:  time.Date(2024, 1, 31, 14, 5, 0, 0, saoPaulo),
+		},
+		{
+			name:  "year first with seconds",
+			line:  importLine{date: "2024-01-31", clock: "14:05:33"},
+			order: "ymd",
+			want:  time.Date(2024, 1, 31, 14, 5, 33, 0, saoPaulo),
+		},
+		{
+			name:  "dotted clock",
+			line:  importLine{date: "31.01.24", clock: "14.05"},
+			order: "dmy",
+			want:  time.Date(2024, 1, 31, 14, 5, 0, 0, saoPaulo),
+		},
+		{
+			name:  "midnight in twelve hour clock",
+			line:  importLine{date: "1/31/24", clock: "12:10", meridiem: "A"},
+			order: "mdy",
+			want:  time.Date(2024, 1, 31, 0, 10, 0, 0, saoPaulo),
+		},
+		{
+			name:  "noon in twelve hour clock",
+			line:  importLine{date: "1/31/24", clock: "12:10", meridiem: "P"},
+			order: "mdy",
+			want:  time.Date(2024, 1, 31, 12, 10, 0, 0, saoPaulo),
+		},
+		{
+			name:  "leap day",
+			line:  importLine{date: "29/02/2024", clock: "09:00"},
+			order: "dmy",
+			want:  time.Date(2024, 2, 29, 9, 0, 0, 0, saoPaulo),
+		},
+		{
+			name:    "wrong order for the file",
+			line:    importLine{date: "31/01/2024", clock: "14:05"},
+			order:   "mdy",
+			wantErr: true,
+		},
+		{
+			name:    "day past the end of the month",
+			line:    importLine{date: "31/02/2024", clock: "14:05"},
+			order:   "dmy",
+			wantErr: true,
+		},
+		{
+			name:    "not a leap year",
+			line:    importLine{date: "29/02/2023", clock: "14:05"},
+			order:   "dmy",
+			wantErr: true,
+		},
+		{
+			name:    "hour out of range",
+			line:    importLine{date: "31/01/2024", clock: "24:05"},
+			order:   "dmy",
+			wantErr: true,
+		},
+		{
+			name:    "malformed date",
+			line:    importLine{date: "31/01", clock: "14:05"},
+			order:   "dmy",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseImportTime(&tt.line, tt.order, saoPaulo)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseImportTime = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseImportTime: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("parseImportTime = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportAttachment(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		wantFile    string
+		wantKind    string
+		wantCaption string
+	}{
+		{
+			name:        "android attachment with caption",
+			text:        "IMG-20240131-WA0007.jpg (file attached)\nLook at this",
+			wantFile:    "IMG-20240131-WA0007.jpg",
+			wantKind:    "image",
+			wantCaption: "Look at this",
+		},
+		{
+			name:     "ios attachment",
+			text:     "<attached: 00000012-PHOTO-2024-01-31-14-05-33.jpg>",
+			wantFile: "00000012-PHOTO-2024-01-31-14-05-33.jpg",
+			wantKind: "image",
+		},
+		{
+			name:     "sticker",
+			text:     "STK-20240131-WA0001.webp (file attached)",
+			wantFile: "STK-20240131-WA0001.webp",
+			wantKind: "sticker",
+		},
+		{
+			name:     "voice note",
+			text:     "PTT-20240131-WA0002.opus (file attached)",
+			wantFile: "PTT-20240131-WA0002.opus",
+			wantKind: "audio",
+		},
+		{
+			name:     "document",
+			text:     "<attached: 00000013-invoice.pdf>",
+			wantFile: "00000013-invoice.pdf",
+			wantKind: "document",
+		},
+		{
+			name:        "android omitted media",
+			text:        "<Media omitted>",
+			wantKind:    "unknown",
+			wantCaption: "<Media omitted>",
+		},
+		{
+			name:     "ios omitted gif",
+			text:     "GIF omitted",
+			wantKind: "video",
+		},
+		{
+			name:        "plain text",
+			text:        "Just text\nover two lines",
+			wantCaption: "Just text\nover two lines",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, kind, caption := importAttachment(tt.text)
+			if file != tt.wantFile || kind != tt.wantKind || caption != tt.wantCaption {
+				t.Fatalf("importAttachment = (%q, %q, %q), want (%q, %q, %q)",
+					file, kind, caption, tt.wantFile, tt.wantKind, tt.wantCaption)
+			}
+		})
+	}
+}
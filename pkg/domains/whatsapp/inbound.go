@@ -0,0 +1,360 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/entities"
+	"github.com/crm/pkg/storage"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// mediaDownloadTimeout bounds how long the event processor waits for one attachment
+const mediaDownloadTimeout = 2 * time.Minute
+
+// inboundAttachment describes the downloadable part of a message
+type inboundAttachment struct {
+	downloadable whatsmeow.DownloadableMessage
+	kind         string
+	mimeType     string
+	fileName     string
+	fileLength   uint64
+}
+
+// messageContent returns the text preview and message type stored for msg
+func messageContent(msg *waProto.Message) (string, string) {
+	switch {
+	case msg == nil:
+		return "[Unsupported message type]", "unknown"
+	case msg.GetConversation() != "":
+		return msg.GetConversation(), "text"
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetText(), "text"
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption(), "image"
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption(), "video"
+	case msg.GetAudioMessage() != nil:
+		return "", "audio"
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption(), "document"
+	case msg.GetStickerMessage() != nil:
+		return "", "sticker"
+	default:
+		return "[Unsupported message type]", "unknown"
+	}
+}
+
+// renderable reports whether msg is a type the message log can show. Reactions, polls,
+// protocol messages and the like are not stored.
+func renderable(msg *waProto.Message) bool {
+	_, messageType := messageContent(msg)
+	return messageType != "unknown"
+}
+
+// contextInfoOf returns the context info of msg, or nil for types that carry none
+func contextInfoOf(msg *waProto.Message) *waProto.ContextInfo {
+	switch {
+	case msg == nil:
+		return nil
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetContextInfo()
+	default:
+		return nil
+	}
+}
+
+// attachmentOf returns the downloadable attachment of msg, if it has one
+func attachmentOf(msg *waProto.Message) *inboundAttachment {
+	switch {
+	case msg == nil:
+		return nil
+	case msg.GetImageMessage() != nil:
+		m := msg.GetImageMessage()
+		return &inboundAttachment{m, "image", m.GetMimetype(), "", m.GetFileLength()}
+	case msg.GetVideoMessage() != nil:
+		m := msg.GetVideoMessage()
+		return &inboundAttachment{m, "video", m.GetMimetype(), "", m.GetFileLength()}
+	case msg.GetAudioMessage() != nil:
+		m := msg.GetAudioMessage()
+		return &inboundAttachment{m, "audio", m.GetMimetype(), "", m.GetFileLength()}
+	case msg.GetDocumentMessage() != nil:
+		m := msg.GetDocumentMessage()
+		return &inboundAttachment{m, "document", m.GetMimetype(), m.GetFileName(), m.GetFileLength()}
+	case msg.GetStickerMessage() != nil:
+		m := msg.GetStickerMessage()
+		return &inboundAttachment{m, "sticker", m.GetMimetype(), "", m.GetFileLength()}
+	default:
+		return nil
+	}
+}
+
+// storeIncomingMessage persists an inbound message and returns the saved record.
+// created is false when the message was already stored, e.g. by a history sync.
+func (s *service) storeIncomingMessage(session *UserSession, event *events.Message) (*entities.WhatsAppMessage, bool, error) {
+	record := s.incomingRecord(session, event)
+	created, err := insertMessage(record)
+	if err != nil {
+		return nil, false, err
+	}
+	return record, created, nil
+}
+
+// incomingRecord builds the stored form of a received message
+func (s *service) incomingRecord(session *UserSession, event *events.Message) *entities.WhatsAppMessage {
+	content, messageType := messageContent(event.Message)
+
+	toJID := event.Info.Chat.String()
+	if !event.Info.IsGroup && session.Client.Store.ID != nil {
+		toJID = session.Client.Store.ID.ToNonAD().String()
+	}
+
+	record := &entities.WhatsAppMessage{
+		UserID:      session.UserID,
+		MessageID:   event.Info.ID,
+		ChatJID:     event.Info.Chat.String(),
+		FromJID:     event.Info.Sender.ToNonAD().String(),
+		ToJID:       toJID,
+		Content:     content,
+		MessageType: messageType,
+		Timestamp:   event.Info.Timestamp,
+		IsIncoming:  !event.Info.IsFromMe,
+
+		ForwardingScore: contextInfoOf(event.Message).GetForwardingScore(),
+		SearchLanguage:  s.searchLanguage,
+	}
+	if expiration := ephemeralExpiration(session.UserID, event); expiration > 0 {
+		expiresAt := event.Info.Timestamp.Add(expiration)
+		record.ExpiresAt = &expiresAt
+	}
+	return record
+}
+
+// insertMessage stores record unless a message with the same ID is already stored for the chat
+func insertMessage(record *entities.WhatsAppMessage) (bool, error) {
+	result := database.DBClient().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "message_id"}, {Name: "chat_jid"}},
+		DoNothing: true,
+	}).Create(record)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to store message: %v", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// applyEdit updates the stored copy of a message its sender edited, which also
+// re-indexes it for search. It returns the updated copy, or nil if it is not stored.
+func (s *service) applyEdit(session *UserSession, event *events.Message, protocol *waProto.ProtocolMessage) *entities.WhatsAppMessage {
+	messageID := protocol.GetKey().GetID()
+	db := database.DBClient()
+
+	var record entities.WhatsAppMessage
+	err := db.Where("user_id = ? AND chat_jid = ? AND message_id = ?", session.UserID, event.Info.Chat.String(), messageID).
+		First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		log.Printf("Edited message %s of user %d is not stored", messageID, session.UserID)
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to get edited message %s of user %d: %v", messageID, session.UserID, err)
+		return nil
+	}
+
+	content, _ := messageContent(protocol.GetEditedMessage())
+	editedAt := event.Info.Timestamp
+	if err := db.Model(&record).Updates(map[string]interface{}{"content": content, "edited_at": editedAt}).Error; err != nil {
+		log.Printf("Failed to apply edit of message %s for user %d: %v", messageID, session.UserID, err)
+		return nil
+	}
+	return &record
+}
+
+// applyRevoke blanks the stored copy of a message deleted for everyone and drops its attachment
+func (s *service) applyRevoke(session *UserSession, event *events.Message, protocol *waProto.ProtocolMessage) {
+	messageID := protocol.GetKey().GetID()
+	db := database.DBClient()
+
+	var record entities.WhatsAppMessage
+	err := db.Where("user_id = ? AND chat_jid = ? AND message_id = ?", session.UserID, event.Info.Chat.String(), messageID).
+		First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		log.Printf("Revoked message %s of user %d is not stored", messageID, session.UserID)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get revoked message %s of user %d: %v", messageID, session.UserID, err)
+		return
+	}
+
+	revokedAt := event.Info.Timestamp
+	if err := db.Model(&record).Updates(map[string]interface{}{"content": "", "revoked_at": revokedAt}).Error; err != nil {
+		log.Printf("Failed to apply revoke of message %s for user %d: %v", messageID, session.UserID, err)
+		return
+	}
+
+	var attachments []entities.WhatsAppMessageMedia
+	if err := db.Where("message_record_id = ?", record.ID).Find(&attachments).Error; err != nil {
+		log.Printf("Failed to get media of revoked message %s of user %d: %v", messageID, session.UserID, err)
+		return
+	}
+	for _, media := range attachments {
+		if media.StorageKey != "" {
+			if err := s.store.Delete(session.Ctx, media.StorageKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("Failed to delete media of revoked message %s of user %d: %v", messageID, session.UserID, err)
+				continue
+			}
+		}
+		db.Delete(&media)
+	}
+}
+
+// storeIncomingMedia records the attachment of a stored message and downloads it into the blob store
+func (s *service) storeIncomingMedia(session *UserSession, event *events.Message, record *entities.WhatsAppMessage) {
+	media, d := recordIncomingMedia(session, event, record)
+	if media == nil {
+		return
+	}
+	s.downloadIncomingMedia(session, media, d)
+}
+
+// recordIncomingMedia stores the download parameters of the attachment of a stored message
+func recordIncomingMedia(session *UserSession, event *events.Message, record *entities.WhatsAppMessage) (*entities.WhatsAppMessageMedia, whatsmeow.DownloadableMessage) {
+	attachment := attachmentOf(event.Message)
+	if attachment == nil {
+		return nil, nil
+	}
+
+	d := attachment.downloadable
+	media := &entities.WhatsAppMessageMedia{
+		UserID:          session.UserID,
+		MessageRecordID: record.ID,
+		MessageID:       record.MessageID,
+		Kind:            attachment.kind,
+		MimeType:        attachment.mimeType,
+		FileName:        attachment.fileName,
+		FileLength:      attachment.fileLength,
+		Status:          constant.MEDIA_STATUS_PENDING,
+		MediaType:       string(whatsmeow.GetMediaType(d)),
+		DirectPath:      d.GetDirectPath(),
+		MediaKey:        d.GetMediaKey(),
+		FileSHA256:      d.GetFileSHA256(),
+		FileEncSHA256:   d.GetFileEncSHA256(),
+	}
+	if err := database.DBClient().Create(media).Error; err != nil {
+		log.Printf("Failed to record media for message %s of user %d: %v", record.MessageID, session.UserID, err)
+		return nil, nil
+	}
+	return media, d
+}
+
+// downloadIncomingMedia pulls a recorded attachment into the blob store
+func (s *service) downloadIncomingMedia(session *UserSession, media *entities.WhatsAppMessageMedia, d whatsmeow.DownloadableMessage) {
+	ctx, cancel := context.WithTimeout(session.Ctx, mediaDownloadTimeout)
+	defer cancel()
+
+	err := s.downloadToStore(ctx, media, func(file *os.File) error {
+		return session.Client.DownloadToFile(ctx, d, file)
+	})
+	switch {
+	case err != nil && isExpiredMediaError(err):
+		// Old attachments are gone from the CDN; ask the phone to upload them again
+		media.Error = err.Error()
+		if retryErr := s.requestMediaRetry(session, media); retryErr != nil {
+			log.Printf("Failed to request media retry for message %s of user %d: %v", media.MessageID, session.UserID, retryErr)
+			media.Status = constant.MEDIA_STATUS_FAILED
+		}
+	case err != nil:
+		log.Printf("Failed to download media for message %s of user %d: %v", media.MessageID, session.UserID, err)
+		media.Status = constant.MEDIA_STATUS_FAILED
+		media.Error = err.Error()
+	default:
+		media.Status = constant.MEDIA_STATUS_STORED
+		media.Error = ""
+	}
+	database.DBClient().Save(media)
+}
+
+// downloadToStore runs download into a temp file and moves the result into the blob store
+func (s *service) downloadToStore(ctx context.Context, media *entities.WhatsAppMessageMedia, download func(file *os.File) error) error {
+	file, err := os.CreateTemp(s.media.tempDir, "whatsapp-download-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	if err := download(file); err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+	}
+
+	key := path.Join("inbound", fmt.Sprint(media.UserID), fmt.Sprintf("%d-%s", media.ID, media.MessageID))
+	if err := s.store.Put(ctx, key, file, info.Size(), media.MimeType); err != nil {
+		return err
+	}
+
+	media.StorageKey = key
+	media.FileLength = uint64(info.Size())
+	return nil
+}
+
+// GetMessageMedia returns an inbound attachment owned by the caller together with its content
+func (s *service) GetMessageMedia(ctx context.Context, id uint) (*entities.WhatsAppMessageMedia, io.ReadCloser, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	var media entities.WhatsAppMessageMedia
+	err = database.DBClient().WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&media).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get media: %v", err)
+	}
+
+	if media.Status != constant.MEDIA_STATUS_STORED {
+		return &media, nil, nil
+	}
+
+	content, err := s.store.Get(ctx, media.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &media, content, nil
+}
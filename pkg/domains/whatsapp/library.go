@@ -0,0 +1,279 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"go.mau.fi/whatsmeow"
+	"gorm.io/gorm"
+)
+
+// ErrMediaNotFound is returned when a media library entry does not exist for the user
+var ErrMediaNotFound = errors.New(constant.MEDIA_NOT_FOUND)
+
+// activeSession returns the caller's session if it is logged in and connected
+func (s *service) activeSession(ctx context.Context) (*UserSession, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	s.mutex.RLock()
+	session, exists := s.sessions[userID]
+	s.mutex.RUnlock()
+
+	if !exists || session.Client == nil {
+		return nil, fmt.Errorf(constant.WHATSAPP_NOT_CONNECTED)
+	}
+	if !session.IsConnected || !session.Client.IsConnected() || session.Client.Store.ID == nil {
+		return nil, fmt.Errorf("WhatsApp not connected or not logged in. Please connect first")
+	}
+
+	return session, nil
+}
+
+func (s *service) UploadLibraryMedia(ctx context.Context, req dtos.LibraryUploadDTO) (*dtos.LibraryMediaDTO, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := mediaTypeFor(req.MimeType)
+	if req.FileSize > s.media.forType(mediaType) {
+		return nil, ErrMediaTooLarge
+	}
+
+	// Keep the original so the entry can be re-uploaded once the CDN copy expires
+	dir := filepath.Join(s.media.libraryDir, strconv.FormatUint(uint64(session.UserID), 10))
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create library directory: %v", err)
+	}
+	stored, err := os.CreateTemp(dir, "media-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create library file: %v", err)
+	}
+	defer stored.Close()
+
+	if _, err := io.Copy(stored, &cappedReader{r: req.Media, limit: s.media.forType(mediaType)}); err != nil {
+		os.Remove(stored.Name())
+		if errors.Is(err, ErrMediaTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+	}
+
+	media := entities.WhatsAppMedia{
+		UserID:      session.UserID,
+		FileName:    req.FileName,
+		MimeType:    req.MimeType,
+		MediaType:   string(mediaType),
+		StoragePath: stored.Name(),
+	}
+	if err := s.refreshLibraryUpload(ctx, session, &media); err != nil {
+		os.Remove(stored.Name())
+		if errors.Is(err, ErrMediaTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf(constant.MEDIA_UPLOAD_FAILED+": %v", err)
+	}
+
+	if err := database.DBClient().WithContext(ctx).Create(&media).Error; err != nil {
+		os.Remove(stored.Name())
+		return nil, fmt.Errorf("failed to save media: %v", err)
+	}
+
+	log.Printf("Media %d stored in library for user %d (%s, %d bytes)", media.ID, session.UserID, media.MimeType, media.FileLength)
+	return toLibraryMediaDTO(media), nil
+}
+
+func (s *service) ListLibraryMedia(ctx context.Context) ([]dtos.LibraryMediaDTO, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	var items []entities.WhatsAppMedia
+	if err := database.DBClient().WithContext(ctx).Where("user_id = ?", userID).Order("id desc").Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("failed to list media: %v", err)
+	}
+
+	result := make([]dtos.LibraryMediaDTO, 0, len(items))
+	for _, item := range items {
+		result = append(result, *toLibraryMediaDTO(item))
+	}
+	return result, nil
+}
+
+func (s *service) DeleteLibraryMedia(ctx context.Context, id uint) error {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("authentication required: %v", err)
+	}
+
+	media, err := s.findLibraryMedia(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	if err := database.DBClient().WithContext(ctx).Delete(&media).Error; err != nil {
+		return fmt.Errorf("failed to delete media: %v", err)
+	}
+	os.Remove(media.StoragePath)
+	return nil
+}
+
+// findLibraryMedia loads a library entry owned by userID
+func (s *service) findLibraryMedia(ctx context.Context, userID, id uint) (entities.WhatsAppMedia, error) {
+	var media entities.WhatsAppMedia
+	err := database.DBClient().WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&media).Error
+	if err == gorm.ErrRecordNotFound {
+		return media, ErrMediaNotFound
+	}
+	if err != nil {
+		return media, fmt.Errorf("failed to get media: %v", err)
+	}
+	return media, nil
+}
+
+// retainMedia keeps the file of a deferred media send until it is delivered, since the
+// request's temp file is gone by then. Library entries are only checked; uploaded files
+// are copied into the blob store, which works without a WhatsApp session. The returned
+// key is empty for library entries.
+func (s *service) retainMedia(ctx context.Context, userID uint, req *dtos.SendMediaMessageDTO) (string, error) {
+	mediaType := mediaTypeFor(req.MimeType)
+	if req.ViewOnce && mediaType == whatsmeow.MediaDocument {
+		return "", ErrViewOnceNotSupported
+	}
+
+	if req.MediaID != 0 {
+		_, err := s.findLibraryMedia(ctx, userID, req.MediaID)
+		return "", err
+	}
+
+	limit := s.media.forType(mediaType)
+	if req.FileSize > limit {
+		return "", ErrMediaTooLarge
+	}
+	key := path.Join("deferred", fmt.Sprint(userID), randomToken(16))
+	if err := s.store.Put(ctx, key, &cappedReader{r: req.Media, limit: limit}, req.FileSize, req.MimeType); err != nil {
+		if errors.Is(err, ErrMediaTooLarge) {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to store media: %v", err)
+	}
+	req.Media = nil
+	return key, nil
+}
+
+// releaseMedia deletes the blob of a deferred send once it can no longer be sent
+func (s *service) releaseMedia(key string) {
+	if key == "" {
+		return
+	}
+	if err := s.store.Delete(context.Background(), key); err != nil {
+		log.Printf("Failed to delete retained media %s: %v", key, err)
+	}
+}
+
+// retainedUpload uploads the blob of a deferred send to WhatsApp
+func (s *service) retainedUpload(ctx context.Context, session *UserSession, key string, req dtos.SendMediaMessageDTO) (whatsmeow.UploadResponse, error) {
+	content, err := s.store.Get(ctx, key)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+	}
+	defer content.Close()
+
+	req.Media = content
+	return s.uploadMedia(ctx, session, req, mediaTypeFor(req.MimeType))
+}
+
+// libraryUpload returns the cached upload for req.MediaID, re-uploading only when the CDN entry has expired.
+// The MIME type always comes from the entry since the media keys are bound to its media type.
+func (s *service) libraryUpload(ctx context.Context, session *UserSession, req *dtos.SendMediaMessageDTO) (whatsmeow.UploadResponse, error) {
+	media, err := s.findLibraryMedia(ctx, session.UserID, req.MediaID)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+
+	req.MimeType = media.MimeType
+	if req.FileName == "" {
+		req.FileName = media.FileName
+	}
+
+	if time.Now().After(media.ExpiresAt) {
+		log.Printf("Library media %d for user %d expired, uploading again", media.ID, session.UserID)
+		if err := s.refreshLibraryUpload(ctx, session, &media); err != nil {
+			return whatsmeow.UploadResponse{}, err
+		}
+		if err := database.DBClient().WithContext(ctx).Save(&media).Error; err != nil {
+			return whatsmeow.UploadResponse{}, fmt.Errorf("failed to save media: %v", err)
+		}
+	}
+
+	return whatsmeow.UploadResponse{
+		URL:           media.URL,
+		DirectPath:    media.DirectPath,
+		MediaKey:      media.MediaKey,
+		FileEncSHA256: media.FileEncSHA256,
+		FileSHA256:    media.FileSHA256,
+		FileLength:    media.FileLength,
+	}, nil
+}
+
+// refreshLibraryUpload uploads the stored original and records the new CDN entry on media
+func (s *service) refreshLibraryUpload(ctx context.Context, session *UserSession, media *entities.WhatsAppMedia) error {
+	file, err := os.Open(media.StoragePath)
+	if err != nil {
+		return fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+	}
+
+	uploaded, err := s.uploadMedia(ctx, session, dtos.SendMediaMessageDTO{
+		Media:    file,
+		FileSize: info.Size(),
+		MimeType: media.MimeType,
+	}, whatsmeow.MediaType(media.MediaType))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	media.URL = uploaded.URL
+	media.DirectPath = uploaded.DirectPath
+	media.MediaKey = uploaded.MediaKey
+	media.FileSHA256 = uploaded.FileSHA256
+	media.FileEncSHA256 = uploaded.FileEncSHA256
+	media.FileLength = uploaded.FileLength
+	media.UploadedAt = now
+	media.ExpiresAt = now.Add(s.media.libraryTTL)
+	return nil
+}
+
+func toLibraryMediaDTO(media entities.WhatsAppMedia) *dtos.LibraryMediaDTO {
+	return &dtos.LibraryMediaDTO{
+		ID:         media.ID,
+		FileName:   media.FileName,
+		MimeType:   media.MimeType,
+		MediaType:  media.MediaType,
+		FileLength: media.FileLength,
+		UploadedAt: media.UploadedAt.Format(time.RFC3339),
+		ExpiresAt:  media.ExpiresAt.Format(time.RFC3339),
+	}
+}
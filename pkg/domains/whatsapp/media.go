@@ -0,0 +1,247 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/dtos"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+)
+
+const megabyte = 1 << 20
+
+// ErrMediaTooLarge is returned when an upload exceeds the cap configured for its media type
+var ErrMediaTooLarge = errors.New(constant.MEDIA_TOO_LARGE)
+
+// mediaLimits holds the per-type upload caps in bytes and where media files are kept
+type mediaLimits struct {
+	tempDir    string
+	libraryDir string
+	libraryTTL time.Duration
+	image      int64
+	video      int64
+	audio      int64
+	document   int64
+}
+
+// newMediaLimits converts the configured megabyte caps to bytes, falling back to WhatsApp's own limits
+func newMediaLimits(mc config.Media) mediaLimits {
+	orDefault := func(mb, def int64) int64 {
+		if mb <= 0 {
+			mb = def
+		}
+		return mb * megabyte
+	}
+
+	libraryDir := mc.LibraryDir
+	if libraryDir == "" {
+		libraryDir = "./media_library"
+	}
+	libraryTTL := time.Duration(mc.LibraryTTLHours) * time.Hour
+	if libraryTTL <= 0 {
+		libraryTTL = 14 * 24 * time.Hour
+	}
+
+	return mediaLimits{
+		tempDir:    mc.TempDir,
+		libraryDir: libraryDir,
+		libraryTTL: libraryTTL,
+		image:      orDefault(mc.MaxImageMB, 16),
+		video:      orDefault(mc.MaxVideoMB, 64),
+		audio:      orDefault(mc.MaxAudioMB, 16),
+		document:   orDefault(mc.MaxDocumentMB, 100),
+	}
+}
+
+// forType returns the cap for the given WhatsApp media type
+func (l mediaLimits) forType(mediaType whatsmeow.MediaType) int64 {
+	switch mediaType {
+	case whatsmeow.MediaImage:
+		return l.image
+	case whatsmeow.MediaVideo:
+		return l.video
+	case whatsmeow.MediaAudio:
+		return l.audio
+	default:
+		return l.document
+	}
+}
+
+// max returns the largest cap across all media types
+func (l mediaLimits) max() int64 {
+	largest := l.image
+	for _, limit := range []int64{l.video, l.audio, l.document} {
+		if limit > largest {
+			largest = limit
+		}
+	}
+	return largest
+}
+
+// mediaTypeFor maps a MIME type to the WhatsApp media type used for upload
+func mediaTypeFor(mimeType string) whatsmeow.MediaType {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return whatsmeow.MediaImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return whatsmeow.MediaVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return whatsmeow.MediaAudio
+	default:
+		return whatsmeow.MediaDocument
+	}
+}
+
+// MediaSizeLimit returns the upload cap in bytes for the MIME type, or the largest cap if it is empty
+func (s *service) MediaSizeLimit(mimeType string) int64 {
+	if mimeType == "" {
+		return s.media.max()
+	}
+	return s.media.forType(mediaTypeFor(mimeType))
+}
+
+// SpoolMedia streams r into a temp file, stopping as soon as the cap for mimeType is exceeded.
+// The caller owns the returned file and must close and remove it.
+func (s *service) SpoolMedia(r io.Reader, mimeType string) (*os.File, int64, error) {
+	limit := s.MediaSizeLimit(mimeType)
+
+	file, err := os.CreateTemp(s.media.tempDir, "whatsapp-media-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temp file: %v", err)
+	}
+
+	// Read one byte past the cap so oversized uploads can be told apart from exact fits
+	size, err := io.Copy(file, io.LimitReader(r, limit+1))
+	if err == nil && size > limit {
+		err = ErrMediaTooLarge
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		if errors.Is(err, ErrMediaTooLarge) {
+			return nil, 0, err
+		}
+		return nil, 0, fmt.Errorf(constant.FILE_READ_FAILED+": %w", err)
+	}
+
+	return file, size, nil
+}
+
+// cappedReader fails with ErrMediaTooLarge once more than limit bytes have been read
+type cappedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	if c.read > c.limit {
+		return n, ErrMediaTooLarge
+	}
+	return n, err
+}
+
+// uploadMedia encrypts and uploads req.Media through a temp file instead of holding it in memory
+func (s *service) uploadMedia(ctx context.Context, session *UserSession, req dtos.SendMediaMessageDTO, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	limit := s.media.forType(mediaType)
+	if req.FileSize > limit {
+		return whatsmeow.UploadResponse{}, ErrMediaTooLarge
+	}
+
+	encrypted, err := os.CreateTemp(s.media.tempDir, "whatsapp-upload-*")
+	if err != nil {
+		return whatsmeow.UploadResponse{}, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer func() {
+		encrypted.Close()
+		os.Remove(encrypted.Name())
+	}()
+
+	uploaded, err := session.Client.UploadReader(ctx, &cappedReader{r: req.Media, limit: limit}, encrypted, mediaType)
+	if errors.Is(err, ErrMediaTooLarge) {
+		return whatsmeow.UploadResponse{}, ErrMediaTooLarge
+	}
+	return uploaded, err
+}
+
+// buildMediaMessage wraps an upload into the message type matching mediaType
+func buildMediaMessage(mediaType whatsmeow.MediaType, uploaded whatsmeow.UploadResponse, req dtos.SendMediaMessageDTO) *waProto.Message {
+	var msg *waProto.Message
+
+	switch mediaType {
+	case whatsmeow.MediaImage:
+		msg = &waProto.Message{
+			ImageMessage: &waProto.ImageMessage{
+				URL:           &uploaded.URL,
+				Mimetype:      &req.MimeType,
+				Caption:       &req.Caption,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    &uploaded.FileLength,
+				Height:        &req.Height,
+				Width:         &req.Width,
+				DirectPath:    &uploaded.DirectPath,
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+			},
+		}
+	case whatsmeow.MediaVideo:
+		msg = &waProto.Message{
+			VideoMessage: &waProto.VideoMessage{
+				URL:           &uploaded.URL,
+				Mimetype:      &req.MimeType,
+				Caption:       &req.Caption,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    &uploaded.FileLength,
+				DirectPath:    &uploaded.DirectPath,
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+			},
+		}
+	case whatsmeow.MediaAudio:
+		msg = &waProto.Message{
+			AudioMessage: &waProto.AudioMessage{
+				URL:           &uploaded.URL,
+				Mimetype:      &req.MimeType,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    &uploaded.FileLength,
+				DirectPath:    &uploaded.DirectPath,
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+				PTT:           &req.Voice,
+			},
+		}
+	default: // Document
+		msg = &waProto.Message{
+			DocumentMessage: &waProto.DocumentMessage{
+				URL:           &uploaded.URL,
+				Mimetype:      &req.MimeType,
+				Title:         &req.Caption,
+				FileName:      &req.FileName,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    &uploaded.FileLength,
+				DirectPath:    &uploaded.DirectPath,
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+			},
+		}
+	}
+
+	// View-once media is wrapped so recipients can open it a single time
+	if req.ViewOnce {
+		msg = wrapViewOnce(msg)
+	}
+	return msg
+}
@@ -0,0 +1,139 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/entities"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waMmsRetry"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+)
+
+// isExpiredMediaError reports whether a download failed because the CDN no longer has the file
+func isExpiredMediaError(err error) bool {
+	return errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) ||
+		errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410) ||
+		errors.Is(err, whatsmeow.ErrNoURLPresent)
+}
+
+// requestMediaRetry asks the sender's phone to re-upload an expired attachment.
+// The answer arrives later as an events.MediaRetry handled by handleMediaRetry.
+func (s *service) requestMediaRetry(session *UserSession, media *entities.WhatsAppMessageMedia) error {
+	var record entities.WhatsAppMessage
+	if err := database.DBClient().First(&record, media.MessageRecordID).Error; err != nil {
+		return fmt.Errorf("failed to load message for media retry: %v", err)
+	}
+
+	chat, err := types.ParseJID(record.ChatJID)
+	if err != nil {
+		return fmt.Errorf("invalid chat JID %q: %v", record.ChatJID, err)
+	}
+	sender, err := types.ParseJID(record.FromJID)
+	if err != nil {
+		return fmt.Errorf("invalid sender JID %q: %v", record.FromJID, err)
+	}
+
+	info := &types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     chat,
+			Sender:   sender,
+			IsFromMe: !record.IsIncoming,
+			IsGroup:  chat.Server == types.GroupServer,
+		},
+		ID: record.MessageID,
+	}
+	if err := session.Client.SendMediaRetryReceipt(info, media.MediaKey); err != nil {
+		return fmt.Errorf("failed to send media retry receipt: %v", err)
+	}
+
+	now := time.Now()
+	media.Status = constant.MEDIA_STATUS_RETRYING
+	media.RetryCount++
+	media.RetryRequestedAt = &now
+	log.Printf("Requested media retry for message %s of user %d", media.MessageID, session.UserID)
+	return nil
+}
+
+// handleMediaRetry completes a download once the phone has re-uploaded the attachment
+func (s *service) handleMediaRetry(session *UserSession, evt *events.MediaRetry) {
+	db := database.DBClient()
+
+	var media entities.WhatsAppMessageMedia
+	err := db.Where("user_id = ? AND message_id = ?", session.UserID, evt.MessageID).First(&media).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.Printf("Failed to load media for retry of message %s (user %d): %v", evt.MessageID, session.UserID, err)
+		}
+		return
+	}
+
+	retryData, err := whatsmeow.DecryptMediaRetryNotification(evt, media.MediaKey)
+	if err == nil && retryData.GetResult() != waMmsRetry.MediaRetryNotification_SUCCESS {
+		err = fmt.Errorf("media retry failed: %s", retryData.GetResult())
+	}
+	if err != nil {
+		log.Printf("Media retry for message %s of user %d failed: %v", evt.MessageID, session.UserID, err)
+		media.Status = constant.MEDIA_STATUS_FAILED
+		media.Error = err.Error()
+		db.Save(&media)
+		return
+	}
+
+	media.DirectPath = retryData.GetDirectPath()
+
+	ctx, cancel := context.WithTimeout(session.Ctx, mediaDownloadTimeout)
+	defer cancel()
+
+	err = s.downloadToStore(ctx, &media, func(file *os.File) error {
+		return session.Client.DownloadMediaWithPathToFile(ctx, media.DirectPath, media.FileEncSHA256, media.FileSHA256,
+			media.MediaKey, int(media.FileLength), whatsmeow.MediaType(media.MediaType), "", file)
+	})
+	if err != nil {
+		log.Printf("Download after media retry for message %s of user %d failed: %v", evt.MessageID, session.UserID, err)
+		media.Status = constant.MEDIA_STATUS_FAILED
+		media.Error = err.Error()
+	} else {
+		log.Printf("Media for message %s of user %d recovered after retry", evt.MessageID, session.UserID)
+		media.Status = constant.MEDIA_STATUS_STORED
+		media.Error = ""
+	}
+	db.Save(&media)
+}
+
+// RetryMessageMedia manually re-requests a failed inbound attachment
+func (s *service) RetryMessageMedia(ctx context.Context, id uint) (*entities.WhatsAppMessageMedia, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var media entities.WhatsAppMessageMedia
+	err = database.DBClient().WithContext(ctx).Where("id = ? AND user_id = ?", id, session.UserID).First(&media).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get media: %v", err)
+	}
+
+	if media.Status == constant.MEDIA_STATUS_STORED {
+		return &media, nil
+	}
+
+	if err := s.requestMediaRetry(session, &media); err != nil {
+		return nil, err
+	}
+	if err := database.DBClient().WithContext(ctx).Save(&media).Error; err != nil {
+		return nil, fmt.Errorf("failed to update media: %v", err)
+	}
+	return &media, nil
+}
@@ -0,0 +1,45 @@
+package whatsapp
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics are registered with the default registry and served on /metrics with the HTTP metrics
+
+var inboundHandlerRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "whatsapp",
+	Subsystem: "inbound",
+	Name:      "handler_runs_total",
+	Help:      "Inbound pipeline handler runs by outcome (ok, stopped, error, panic).",
+}, []string{"handler", "outcome"})
+
+var inboundHandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "whatsapp",
+	Subsystem: "inbound",
+	Name:      "handler_duration_seconds",
+	Help:      "Time spent in each inbound pipeline handler.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+}, []string{"handler"})
+
+var inboundDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "whatsapp",
+	Subsystem: "inbound",
+	Name:      "dropped_total",
+	Help:      "Received messages discarded by the inbound queue's overflow policy, by reason (oldest, timeout, spill_failed).",
+}, []string{"reason"})
+
+var inboundSpilled = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "whatsapp",
+	Subsystem: "inbound",
+	Name:      "spilled_total",
+	Help:      "Received messages parked in Postgres because the inbound queue was full.",
+})
+
+var inboundWait = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "whatsapp",
+	Subsystem: "inbound",
+	Name:      "queue_wait_seconds",
+	Help:      "Time received messages spend queued before the inbound pipeline picks them up.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+})
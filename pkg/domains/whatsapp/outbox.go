@@ -0,0 +1,400 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"github.com/crm/pkg/utils"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+)
+
+// ErrOutboxNotFound is returned when a queued message does not exist for the user
+var ErrOutboxNotFound = errors.New(constant.OUTBOX_NOT_FOUND)
+
+// ErrOutboxNotDead is returned when requeueing a message that has not been dead-lettered
+var ErrOutboxNotDead = errors.New("only dead-lettered messages can be requeued")
+
+// outboxBatch is how many chat heads are delivered per pass
+const outboxBatch = 50
+
+// outboxSendTimeout bounds a single delivery attempt
+const outboxSendTimeout = time.Minute
+
+// outboxSettings controls polling and retry backoff of the outbox workers
+type outboxSettings struct {
+	pollInterval time.Duration
+	maxAttempts  int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+}
+
+func newOutboxSettings(oc config.Outbox) outboxSettings {
+	settings := outboxSettings{
+		pollInterval: time.Duration(oc.PollIntervalMs) * time.Millisecond,
+		maxAttempts:  oc.MaxAttempts,
+		baseBackoff:  time.Duration(oc.BaseBackoffMs) * time.Millisecond,
+		maxBackoff:   time.Duration(oc.MaxBackoffSec) * time.Second,
+	}
+	if settings.pollInterval <= 0 {
+		settings.pollInterval = 2 * time.Second
+	}
+	if settings.maxAttempts <= 0 {
+		settings.maxAttempts = 8
+	}
+	if settings.baseBackoff <= 0 {
+		settings.baseBackoff = 2 * time.Second
+	}
+	if settings.maxBackoff < settings.baseBackoff {
+		settings.maxBackoff = 5 * time.Minute
+	}
+	return settings
+}
+
+// backoff returns the delay before the next attempt, doubling per attempt up to maxBackoff
+func (o outboxSettings) backoff(attempts int) time.Duration {
+	delay := o.baseBackoff
+	for i := 1; i < attempts && delay < o.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, o.maxBackoff)
+}
+
+// isPermanentSendError reports whether retrying a failed send can never succeed
+func isPermanentSendError(err error) bool {
+	return errors.Is(err, ErrMediaNotFound) ||
+		errors.Is(err, ErrMediaTooLarge) ||
+		errors.Is(err, ErrViewOnceNotSupported) ||
+		errors.Is(err, whatsmeow.ErrUnknownServer) ||
+		errors.Is(err, whatsmeow.ErrRecipientADJID) ||
+		errors.Is(err, whatsmeow.ErrBroadcastListUnsupported)
+}
+
+// enqueueMessage stores a text send in the outbox and wakes the user's worker
+func (s *service) enqueueMessage(ctx context.Context, userID uint, req dtos.SendMessageDTO) (*dtos.MessageResponseDTO, error) {
+	return s.enqueue(ctx, &entities.WhatsAppOutboxMessage{
+		UserID:        userID,
+		PhoneNumber:   req.PhoneNumber,
+		Message:       req.Message,
+		Transactional: req.Transactional,
+	})
+}
+
+// enqueueMediaMessage stores a media send in the outbox, backed by a library entry or a retained blob
+func (s *service) enqueueMediaMessage(ctx context.Context, userID uint, req dtos.SendMediaMessageDTO) (*dtos.MessageResponseDTO, error) {
+	key, err := s.retainMedia(ctx, userID, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.enqueue(ctx, &entities.WhatsAppOutboxMessage{
+		UserID:      userID,
+		PhoneNumber: req.PhoneNumber,
+		Message:     req.Caption,
+		MediaID:     req.MediaID,
+		StorageKey:  key,
+		MimeType:    req.MimeType,
+		FileName:    req.FileName,
+		FileSize:    req.FileSize,
+		ViewOnce:    req.ViewOnce,
+		Voice:       req.Voice,
+		Height:      req.Height,
+		Width:       req.Width,
+
+		Transactional: req.Transactional,
+	})
+	if err != nil {
+		s.releaseMedia(key)
+	}
+	return resp, err
+}
+
+func (s *service) enqueue(ctx context.Context, item *entities.WhatsAppOutboxMessage) (*dtos.MessageResponseDTO, error) {
+	recipient, err := s.formatPhoneNumber(item.PhoneNumber)
+	if err != nil {
+		return nil, fmt.Errorf(constant.INVALID_PHONE_NUMBER+": %v", err)
+	}
+
+	now := time.Now()
+	item.ChatJID = recipient.String()
+	item.Status = constant.OUTBOX_STATUS_QUEUED
+	item.NextAttemptAt = now
+
+	if err := database.DBClient().WithContext(ctx).Create(item).Error; err != nil {
+		return nil, fmt.Errorf("failed to queue message: %v", err)
+	}
+	s.wakeOutbox(item.UserID)
+
+	log.Printf("Message %d queued by user %d for %s", item.ID, item.UserID, item.ChatJID)
+	return &dtos.MessageResponseDTO{
+		Timestamp: now.Format(time.RFC3339),
+		Status:    constant.OUTBOX_STATUS_QUEUED,
+		To:        item.PhoneNumber,
+		OutboxID:  item.ID,
+	}, nil
+}
+
+// wakeOutbox nudges the user's outbox worker without waiting for the next poll
+func (s *service) wakeOutbox(userID uint) {
+	s.mutex.RLock()
+	session, exists := s.sessions[userID]
+	s.mutex.RUnlock()
+	if !exists || session.OutboxWake == nil {
+		return
+	}
+
+	select {
+	case session.OutboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// runOutbox delivers the user's queued messages for as long as the session lives.
+// Messages stay in Postgres, so anything queued while disconnected or before a
+// restart is delivered once the session is connected again.
+func (s *service) runOutbox(session *UserSession) {
+	// Attempts interrupted by a crash are retried
+	database.DBClient().Model(&entities.WhatsAppOutboxMessage{}).
+		Where("user_id = ? AND status = ?", session.UserID, constant.OUTBOX_STATUS_SENDING).
+		Update("status", constant.OUTBOX_STATUS_QUEUED)
+
+	ticker := time.NewTicker(s.outbox.pollInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-session.Ctx.Done():
+			return
+		case <-ticker.C:
+		case <-session.OutboxWake:
+		}
+
+		if err := s.drainOutbox(session); err != nil {
+			// Without a working database every retry could resend a message
+			failures++
+			delay := s.outbox.backoff(failures)
+			log.Printf("Outbox of user %d paused for %s: %v", session.UserID, delay, err)
+			if sleepCtx(session.Ctx, delay) != nil {
+				return
+			}
+			continue
+		}
+		failures = 0
+	}
+}
+
+// drainOutbox delivers due messages until every chat is empty or waiting for a retry.
+// It stops at the first message whose state cannot be stored.
+func (s *service) drainOutbox(session *UserSession) error {
+	for session.Ctx.Err() == nil && s.sessionReady(session.UserID) {
+		heads, err := s.outboxHeads(session.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to load outbox: %v", err)
+		}
+		if len(heads) == 0 {
+			return nil
+		}
+
+		for i := range heads {
+			if err := s.deliverOutbox(session, &heads[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// outboxHeads returns the oldest queued message of each chat when it is due.
+// A chat whose head is backing off is skipped entirely, which keeps delivery FIFO per chat.
+func (s *service) outboxHeads(userID uint) ([]entities.WhatsAppOutboxMessage, error) {
+	db := database.DBClient()
+
+	oldest := db.Model(&entities.WhatsAppOutboxMessage{}).Select("MIN(id)").
+		Where("user_id = ? AND status IN ?", userID, []string{constant.OUTBOX_STATUS_QUEUED, constant.OUTBOX_STATUS_SENDING}).
+		Group("chat_jid")
+
+	var heads []entities.WhatsAppOutboxMessage
+	err := db.Where("id IN (?) AND status = ? AND next_attempt_at <= ?", oldest, constant.OUTBOX_STATUS_QUEUED, time.Now()).
+		Order("id").Limit(outboxBatch).Find(&heads).Error
+	return heads, err
+}
+
+// deliverOutbox makes one delivery attempt and records the outcome. Only failures to
+// store the message's state are returned; send failures are recorded on the message.
+func (s *service) deliverOutbox(session *UserSession, item *entities.WhatsAppOutboxMessage) error {
+	db := database.DBClient()
+
+	item.Status = constant.OUTBOX_STATUS_SENDING
+	item.Attempts++
+	if err := db.Model(item).Select("Status", "Attempts").Updates(item).Error; err != nil {
+		return fmt.Errorf("failed to claim queued message %d: %v", item.ID, err)
+	}
+
+	// Pacing may hold a send back for a while before it goes out
+	ctx, cancel := context.WithTimeout(withTransactional(session.Ctx, item.Transactional), outboxSendTimeout)
+	defer cancel()
+
+	var resp whatsmeow.SendResponse
+	msg, err := s.outboxMessage(ctx, session, item)
+	if err == nil {
+		var recipient types.JID
+		recipient, err = types.ParseJID(item.ChatJID)
+		if err == nil {
+			resp, err = s.sendTo(ctx, session, recipient, msg)
+		}
+	}
+
+	switch {
+	case err == nil:
+		sentAt := time.Now()
+		item.Status = constant.OUTBOX_STATUS_SENT
+		item.MessageID = resp.ID
+		item.SentAt = &sentAt
+		item.LastError = ""
+		log.Printf("Queued message %d of user %d sent. ID: %s", item.ID, session.UserID, resp.ID)
+	case errors.Is(err, ErrDailyCapReached):
+		// Not the message's fault; try again once the cap resets
+		tomorrow := dailyCapReset()
+		item.Status = constant.OUTBOX_STATUS_QUEUED
+		item.Attempts--
+		item.LastError = err.Error()
+		item.NextAttemptAt = tomorrow
+		log.Printf("Queued message %d of user %d held until %s by the daily cap", item.ID, session.UserID, tomorrow.Format(time.RFC3339))
+	case isPermanentSendError(err) || item.Attempts >= s.outbox.maxAttempts:
+		item.Status = constant.OUTBOX_STATUS_DEAD
+		item.LastError = err.Error()
+		log.Printf("Queued message %d of user %d dead-lettered after %d attempts: %v", item.ID, session.UserID, item.Attempts, err)
+	default:
+		item.Status = constant.OUTBOX_STATUS_QUEUED
+		item.LastError = err.Error()
+		item.NextAttemptAt = time.Now().Add(s.outbox.backoff(item.Attempts))
+		log.Printf("Queued message %d of user %d failed, retrying at %s: %v", item.ID, session.UserID, item.NextAttemptAt.Format(time.RFC3339), err)
+	}
+
+	// The message stays claimed when this fails, so it is not sent again before a restart
+	if err := db.Save(item).Error; err != nil {
+		return fmt.Errorf("failed to update queued message %d: %v", item.ID, err)
+	}
+	// Dead-lettered messages keep their media so they can be requeued
+	if item.Status == constant.OUTBOX_STATUS_SENT {
+		s.releaseMedia(item.StorageKey)
+	}
+	return nil
+}
+
+// outboxMessage builds the protobuf message for a queued send
+func (s *service) outboxMessage(ctx context.Context, session *UserSession, item *entities.WhatsAppOutboxMessage) (*waProto.Message, error) {
+	if item.MediaID == 0 && item.StorageKey == "" {
+		return textMessage(item.Message), nil
+	}
+
+	req := dtos.SendMediaMessageDTO{
+		Caption:  item.Message,
+		MediaID:  item.MediaID,
+		FileName: item.FileName,
+		FileSize: item.FileSize,
+		MimeType: item.MimeType,
+		Height:   item.Height,
+		Width:    item.Width,
+		ViewOnce: item.ViewOnce,
+		Voice:    item.Voice,
+	}
+	var uploaded whatsmeow.UploadResponse
+	var err error
+	if item.StorageKey != "" {
+		uploaded, err = s.retainedUpload(ctx, session, item.StorageKey, req)
+	} else {
+		uploaded, err = s.libraryUpload(ctx, session, &req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := mediaTypeFor(req.MimeType)
+	if req.ViewOnce && mediaType == whatsmeow.MediaDocument {
+		return nil, ErrViewOnceNotSupported
+	}
+
+	return buildMediaMessage(mediaType, uploaded, req), nil
+}
+
+// ListOutbox returns one page of the caller's queued messages, e.g. status=dead for the dead-letter queue
+func (s *service) ListOutbox(ctx context.Context, status string, page int) ([]entities.WhatsAppOutboxMessage, int, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("authentication required: %v", err)
+	}
+
+	query := "user_id = ?"
+	args := []interface{}{userID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+
+	var items []entities.WhatsAppOutboxMessage
+	totalPages, err := utils.Pagination(&items, page, database.DBClient().Order("id desc"), ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return items, totalPages, nil
+}
+
+func (s *service) GetOutboxMessage(ctx context.Context, id uint) (*entities.WhatsAppOutboxMessage, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	return s.findOutboxMessage(ctx, userID, id)
+}
+
+// RequeueOutboxMessage moves a dead-lettered message back into the queue with a fresh attempt budget
+func (s *service) RequeueOutboxMessage(ctx context.Context, id uint) error {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("authentication required: %v", err)
+	}
+	if _, err := s.findOutboxMessage(ctx, userID, id); err != nil {
+		return err
+	}
+
+	result := database.DBClient().WithContext(ctx).Model(&entities.WhatsAppOutboxMessage{}).
+		Where("id = ? AND status = ?", id, constant.OUTBOX_STATUS_DEAD).
+		Updates(map[string]interface{}{
+			"status":          constant.OUTBOX_STATUS_QUEUED,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to requeue message: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrOutboxNotDead
+	}
+
+	s.wakeOutbox(userID)
+	return nil
+}
+
+func (s *service) findOutboxMessage(ctx context.Context, userID, id uint) (*entities.WhatsAppOutboxMessage, error) {
+	var item entities.WhatsAppOutboxMessage
+	err := database.DBClient().WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&item).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrOutboxNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queued message: %v", err)
+	}
+	return &item, nil
+}
@@ -0,0 +1,289 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrDailyCapReached is returned when an account has used up its sends for the day
+var ErrDailyCapReached = errors.New(constant.DAILY_CAP_REACHED)
+
+// transactionalKey marks a context whose sends bypass pacing
+type transactionalKey struct{}
+
+// withTransactional marks sends made with ctx as transactional, e.g. OTPs and receipts
+func withTransactional(ctx context.Context, transactional bool) context.Context {
+	if !transactional {
+		return ctx
+	}
+	return context.WithValue(ctx, transactionalKey{}, true)
+}
+
+func isTransactional(ctx context.Context) bool {
+	transactional, _ := ctx.Value(transactionalKey{}).(bool)
+	return transactional
+}
+
+// pacer spaces out the sends of each session so they look like a person typing
+type pacer struct {
+	mutex    sync.Mutex
+	sessions map[uint]*sessionPace
+}
+
+// sessionPace holds the reserved send slots of one session
+type sessionPace struct {
+	next       time.Time            // Earliest time the session may send again
+	recipients map[string]time.Time // Earliest time each chat may receive again
+}
+
+func newPacer() *pacer {
+	return &pacer{sessions: make(map[uint]*sessionPace)}
+}
+
+// reserve returns how long the caller has to wait before sending to chat and books that slot,
+// so concurrent senders of one session queue up behind each other instead of bursting
+func (p *pacer) reserve(userID uint, chat string, settings *entities.WhatsAppPacing) time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pace, ok := p.sessions[userID]
+	if !ok {
+		pace = &sessionPace{recipients: make(map[string]time.Time)}
+		p.sessions[userID] = pace
+	}
+
+	now := time.Now()
+	at := now
+	if pace.next.After(at) {
+		at = pace.next
+	}
+	if next, ok := pace.recipients[chat]; ok && next.After(at) {
+		at = next
+	}
+	if settings.JitterMs > 0 {
+		at = at.Add(time.Duration(rand.Intn(settings.JitterMs+1)) * time.Millisecond)
+	}
+
+	pace.next = at.Add(time.Duration(settings.MinIntervalMs) * time.Millisecond)
+	pace.recipients[chat] = at.Add(time.Duration(settings.RecipientIntervalMs) * time.Millisecond)
+
+	// Forget chats whose slot has passed to keep the map small
+	for jid, next := range pace.recipients {
+		if next.Before(now) {
+			delete(pace.recipients, jid)
+		}
+	}
+	return at.Sub(now)
+}
+
+// defaultPacing converts the configured defaults into account settings
+func defaultPacing(pc config.Pacing) entities.WhatsAppPacing {
+	return entities.WhatsAppPacing{
+		Enabled:             pc.Enabled,
+		MinIntervalMs:       pc.MinIntervalMs,
+		RecipientIntervalMs: pc.RecipientIntervalMs,
+		JitterMs:            pc.JitterMs,
+		Typing:              pc.Typing,
+		TypingMsPerChar:     pc.TypingMsPerChar,
+		MaxTypingMs:         pc.MaxTypingMs,
+		DailyCap:            pc.DailyCap,
+	}
+}
+
+// pacingFor returns the account's own settings, or the configured defaults if it has none
+func (s *service) pacingFor(ctx context.Context, userID uint) (*entities.WhatsAppPacing, error) {
+	var stored entities.WhatsAppPacing
+	err := database.DBClient().WithContext(ctx).Where("user_id = ?", userID).First(&stored).Error
+	if err == gorm.ErrRecordNotFound {
+		settings := s.pacingDefaults
+		settings.UserID = userID
+		return &settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pacing settings: %v", err)
+	}
+	if !stored.CountersOnly {
+		return &stored, nil
+	}
+
+	settings := s.pacingDefaults
+	settings.Model = stored.Model
+	settings.UserID = userID
+	settings.SentDay = stored.SentDay
+	settings.SentToday = stored.SentToday
+	settings.CountersOnly = true
+	return &settings, nil
+}
+
+// checkDailyCap fails when the account has used up its sends for the current UTC day
+func checkDailyCap(settings *entities.WhatsAppPacing) error {
+	today := time.Now().UTC().Format(time.DateOnly)
+	if settings.DailyCap > 0 && settings.SentDay == today && settings.SentToday >= settings.DailyCap {
+		return ErrDailyCapReached
+	}
+	return nil
+}
+
+// dailyCapReset returns when the daily cap starts counting again, at the next UTC midnight
+func dailyCapReset() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// countDailySend books a successful send against the daily cap, resetting the counter on a
+// new UTC day. Accounts on the defaults get a row holding only the counter.
+func (s *service) countDailySend(ctx context.Context, settings *entities.WhatsAppPacing) error {
+	if settings.DailyCap <= 0 {
+		return nil
+	}
+
+	db := database.DBClient().WithContext(ctx)
+	today := time.Now().UTC().Format(time.DateOnly)
+	if settings.ID == 0 {
+		counter := entities.WhatsAppPacing{UserID: settings.UserID, SentDay: today, CountersOnly: true}
+		err := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "user_id"}}, DoNothing: true}).Create(&counter).Error
+		if err != nil {
+			return fmt.Errorf("failed to track daily sends: %v", err)
+		}
+	}
+
+	err := db.Model(&entities.WhatsAppPacing{}).Where("user_id = ?", settings.UserID).
+		Updates(map[string]interface{}{
+			"sent_today": gorm.Expr("CASE WHEN sent_day = ? THEN sent_today + 1 ELSE 1 END", today),
+			"sent_day":   today,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to track daily sends: %v", err)
+	}
+	return nil
+}
+
+// typingDuration is how long "composing" is shown for text of the given length
+func typingDuration(settings *entities.WhatsAppPacing, text string) time.Duration {
+	duration := time.Duration(len([]rune(text))*settings.TypingMsPerChar) * time.Millisecond
+	if limit := time.Duration(settings.MaxTypingMs) * time.Millisecond; duration > limit {
+		duration = limit
+	}
+	return duration
+}
+
+// sleepCtx waits for d or until ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// pace delays a send according to the account's pacing and shows a typing indicator.
+// It returns a function to call after the send, which clears the indicator and counts
+// the send against the daily cap if it went out. Failed sends use up no quota.
+func (s *service) pace(ctx context.Context, session *UserSession, recipient types.JID, msg *waProto.Message) (func(sent bool), error) {
+	noop := func(bool) {}
+
+	settings, err := s.pacingFor(ctx, session.UserID)
+	if err != nil {
+		return noop, err
+	}
+	if !settings.Enabled {
+		return noop, nil
+	}
+
+	if err := checkDailyCap(settings); err != nil {
+		return noop, err
+	}
+	count := func(sent bool) {
+		if !sent {
+			return
+		}
+		if err := s.countDailySend(context.WithoutCancel(ctx), settings); err != nil {
+			log.Printf("Failed to count send of user %d: %v", session.UserID, err)
+		}
+	}
+
+	if err := sleepCtx(ctx, s.pacer.reserve(session.UserID, recipient.String(), settings)); err != nil {
+		return noop, err
+	}
+
+	if !settings.Typing || recipient.Server == types.BroadcastServer {
+		return count, nil
+	}
+
+	text, messageType := messageContent(msg)
+	media := types.ChatPresenceMediaText
+	if messageType == "audio" {
+		media = types.ChatPresenceMediaAudio
+	}
+	if err := session.Client.SendChatPresence(recipient, types.ChatPresenceComposing, media); err != nil {
+		// A missing indicator is not worth failing the send for
+		log.Printf("Failed to send composing presence for user %d: %v", session.UserID, err)
+		return count, nil
+	}
+	done := func(sent bool) {
+		session.Client.SendChatPresence(recipient, types.ChatPresencePaused, media)
+		count(sent)
+	}
+
+	if err := sleepCtx(ctx, typingDuration(settings, text)); err != nil {
+		done(false)
+		return noop, err
+	}
+	return done, nil
+}
+
+func (s *service) GetPacing(ctx context.Context) (*entities.WhatsAppPacing, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	return s.pacingFor(ctx, userID)
+}
+
+// UpdatePacing stores the account's own pacing settings, replacing the configured defaults
+func (s *service) UpdatePacing(ctx context.Context, req dtos.PacingDTO) (*entities.WhatsAppPacing, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	settings, err := s.pacingFor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	settings.Enabled = req.Enabled
+	settings.MinIntervalMs = req.MinIntervalMs
+	settings.RecipientIntervalMs = req.RecipientIntervalMs
+	settings.JitterMs = req.JitterMs
+	settings.Typing = req.Typing
+	settings.TypingMsPerChar = req.TypingMsPerChar
+	settings.MaxTypingMs = req.MaxTypingMs
+	settings.DailyCap = req.DailyCap
+	settings.CountersOnly = false
+
+	if err := database.DBClient().WithContext(ctx).Save(settings).Error; err != nil {
+		return nil, fmt.Errorf("failed to save pacing settings: %v", err)
+	}
+	return settings, nil
+}
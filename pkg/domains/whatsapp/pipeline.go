@@ -0,0 +1,156 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/entities"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// ErrStopPipeline ends processing of a message early without counting as a failure,
+// e.g. for a bot that has fully handled it
+var ErrStopPipeline = errors.New("stop inbound pipeline")
+
+// InboundMessage is a received message on its way through the inbound pipeline
+type InboundMessage struct {
+	Session *UserSession
+	Event   *events.Message
+	Record  *entities.WhatsAppMessage // Stored copy, set by the persist handler
+	Type    string                    // Event type sent to streams and webhooks, set by the persist handler
+}
+
+// InboundHandlerFunc processes one received message. Errors are logged and counted;
+// the remaining handlers still run unless the error is ErrStopPipeline.
+type InboundHandlerFunc func(ctx context.Context, msg *InboundMessage) error
+
+// Option customises the service built by NewService
+type Option func(*service)
+
+// WithInboundHandler appends a handler to the inbound pipeline. Handlers run in
+// registration order after the built-in persist, media, stream and webhook handlers.
+func WithInboundHandler(name string, fn InboundHandlerFunc) Option {
+	return func(s *service) {
+		s.inbound = append(s.inbound, inboundHandler{name: name, fn: fn})
+	}
+}
+
+type inboundHandler struct {
+	name     string
+	fn       InboundHandlerFunc
+	critical bool // A failure skips the remaining handlers
+}
+
+// builtinInboundHandlers are the handlers every message goes through first
+func (s *service) builtinInboundHandlers() []inboundHandler {
+	return []inboundHandler{
+		{name: "persist", fn: s.persistInbound, critical: true},
+		{name: "media", fn: s.downloadInbound},
+		{name: "stream", fn: s.streamInbound},
+		{name: "webhook", fn: s.webhookInbound},
+	}
+}
+
+// runInbound passes a message through the pipeline. A failing or panicking handler
+// does not keep the others from running.
+func (s *service) runInbound(ctx context.Context, msg *InboundMessage) {
+	for _, handler := range s.inbound {
+		err := runInboundHandler(ctx, handler, msg)
+		if errors.Is(err, ErrStopPipeline) {
+			return
+		}
+		if err != nil {
+			log.Printf("Inbound handler %s failed on message %s of user %d: %v", handler.name, msg.Event.Info.ID, msg.Session.UserID, err)
+			if handler.critical {
+				return
+			}
+		}
+	}
+}
+
+// runInboundHandler runs one handler, turning a panic into an error and recording metrics
+func runInboundHandler(ctx context.Context, handler inboundHandler, msg *InboundMessage) (err error) {
+	started := time.Now()
+	outcome := "ok"
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Inbound handler %s panicked: %v\n%s", handler.name, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+			outcome = "panic"
+		}
+		inboundHandlerDuration.WithLabelValues(handler.name).Observe(time.Since(started).Seconds())
+		inboundHandlerRuns.WithLabelValues(handler.name, outcome).Inc()
+	}()
+
+	err = handler.fn(ctx, msg)
+	switch {
+	case errors.Is(err, ErrStopPipeline):
+		outcome = "stopped"
+	case err != nil:
+		outcome = "error"
+	}
+	return err
+}
+
+// persistInbound stores the message, or applies it to the stored copy when it is an
+// edit, revoke or disappearing timer change. Edits continue down the pipeline as
+// message_edited events; revokes, other protocol messages and types the message log
+// cannot show go no further.
+func (s *service) persistInbound(ctx context.Context, msg *InboundMessage) error {
+	if protocol := msg.Event.Message.GetProtocolMessage(); protocol != nil {
+		switch protocol.GetType() {
+		case waProto.ProtocolMessage_MESSAGE_EDIT:
+			record := s.applyEdit(msg.Session, msg.Event, protocol)
+			if record == nil {
+				return ErrStopPipeline
+			}
+			msg.Record = record
+			msg.Type = constant.EVENT_MESSAGE_EDITED
+			return nil
+		case waProto.ProtocolMessage_REVOKE:
+			s.applyRevoke(msg.Session, msg.Event, protocol)
+		case waProto.ProtocolMessage_EPHEMERAL_SETTING:
+			saveDisappearingTimer(msg.Session.UserID, msg.Event.Info.Chat, protocol.GetEphemeralExpiration())
+		}
+		return ErrStopPipeline
+	}
+	if !renderable(msg.Event.Message) {
+		return ErrStopPipeline
+	}
+
+	record, created, err := s.storeIncomingMessage(msg.Session, msg.Event)
+	if err != nil {
+		return err
+	}
+	if !created {
+		// Already imported from a history sync
+		return ErrStopPipeline
+	}
+	msg.Record = record
+	msg.Type = constant.EVENT_MESSAGE
+	return nil
+}
+
+// downloadInbound pulls the attachment of a new message into blob storage
+func (s *service) downloadInbound(ctx context.Context, msg *InboundMessage) error {
+	if msg.Type == constant.EVENT_MESSAGE {
+		s.storeIncomingMedia(msg.Session, msg.Event, msg.Record)
+	}
+	return nil
+}
+
+func (s *service) streamInbound(ctx context.Context, msg *InboundMessage) error {
+	s.publishEvent(msg.Session.UserID, msg.Type, msg.Record)
+	return nil
+}
+
+func (s *service) webhookInbound(ctx context.Context, msg *InboundMessage) error {
+	s.emitWebhook(msg.Session.UserID, msg.Type, msg.Record)
+	return nil
+}
@@ -0,0 +1,162 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"github.com/crm/pkg/utils"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func (s *service) SetPresence(ctx context.Context, req dtos.PresenceDTO) error {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	presence := types.PresenceAvailable
+	if req.State == "unavailable" {
+		presence = types.PresenceUnavailable
+	}
+	if err := session.Client.SendPresence(presence); err != nil {
+		return fmt.Errorf("failed to send presence: %v", err)
+	}
+	return nil
+}
+
+func (s *service) SetChatPresence(ctx context.Context, req dtos.ChatPresenceDTO) error {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	chat, err := s.resolveChat(req.PhoneNumber, req.ChatJID)
+	if err != nil {
+		return err
+	}
+
+	state, media := types.ChatPresenceComposing, types.ChatPresenceMediaText
+	switch req.State {
+	case "recording":
+		media = types.ChatPresenceMediaAudio
+	case "paused":
+		state = types.ChatPresencePaused
+	}
+	if err := session.Client.SendChatPresence(chat, state, media); err != nil {
+		return fmt.Errorf("failed to send chat presence: %v", err)
+	}
+	return nil
+}
+
+// MarkRead sends read receipts for inbound messages of a chat and returns how many were marked
+func (s *service) MarkRead(ctx context.Context, req dtos.MarkReadDTO) (int, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	chat, err := s.resolveChat(req.PhoneNumber, req.ChatJID)
+	if err != nil {
+		return 0, err
+	}
+
+	query := database.DBClient().WithContext(ctx).
+		Where("user_id = ? AND chat_jid = ? AND is_incoming = ? AND read_at IS NULL", session.UserID, chat.String(), true)
+	if len(req.MessageIDs) > 0 {
+		query = query.Where("message_id IN ?", req.MessageIDs)
+	}
+
+	var messages []entities.WhatsAppMessage
+	if err := query.Order("timestamp").Find(&messages).Error; err != nil {
+		return 0, fmt.Errorf("failed to load messages: %v", err)
+	}
+	return len(messages), s.markMessagesRead(ctx, session, chat, messages)
+}
+
+// markMessagesRead sends one receipt per sender, as group receipts must name the participant
+func (s *service) markMessagesRead(ctx context.Context, session *UserSession, chat types.JID, messages []entities.WhatsAppMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	bySender := map[string][]entities.WhatsAppMessage{}
+	for _, message := range messages {
+		bySender[message.FromJID] = append(bySender[message.FromJID], message)
+	}
+
+	now := time.Now()
+	for from, batch := range bySender {
+		sender, err := types.ParseJID(from)
+		if err != nil {
+			return fmt.Errorf("invalid sender JID %q: %v", from, err)
+		}
+
+		ids := make([]types.MessageID, 0, len(batch))
+		records := make([]uint, 0, len(batch))
+		for _, message := range batch {
+			ids = append(ids, message.MessageID)
+			records = append(records, message.ID)
+		}
+
+		// In direct chats the sender is implied by the chat
+		if chat.Server != types.GroupServer {
+			sender = types.EmptyJID
+		}
+		if err := session.Client.MarkRead(ids, now, chat, sender); err != nil {
+			return fmt.Errorf("failed to mark messages as read: %v", err)
+		}
+
+		err = database.DBClient().WithContext(ctx).Model(&entities.WhatsAppMessage{}).
+			Where("id IN ?", records).Update("read_at", now).Error
+		if err != nil {
+			return fmt.Errorf("failed to update messages: %v", err)
+		}
+	}
+
+	log.Printf("Marked %d messages in %s as read for user %d", len(messages), chat, session.UserID)
+	return nil
+}
+
+// GetMessages returns one page of a conversation, newest first
+func (s *service) GetMessages(ctx context.Context, req dtos.MessageHistoryDTO) ([]entities.WhatsAppMessage, int, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("authentication required: %v", err)
+	}
+
+	chat, err := s.resolveChat(req.PhoneNumber, req.ChatJID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var messages []entities.WhatsAppMessage
+	totalPages, err := utils.Pagination(&messages, req.Page, database.DBClient().Order("timestamp desc"), ctx,
+		"user_id = ? AND chat_jid = ?", userID, chat.String())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if req.MarkRead {
+		var unread []entities.WhatsAppMessage
+		for _, message := range messages {
+			if message.IsIncoming && message.ReadAt == nil {
+				unread = append(unread, message)
+			}
+		}
+
+		// Reading history must keep working while WhatsApp is offline
+		session, err := s.activeSession(ctx)
+		if err == nil {
+			err = s.markMessagesRead(ctx, session, chat, unread)
+		}
+		if err != nil {
+			log.Printf("Failed to auto-mark messages in %s as read for user %d: %v", chat, userID, err)
+		}
+	}
+	return messages, totalPages, nil
+}
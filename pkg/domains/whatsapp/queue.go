@@ -0,0 +1,333 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/entities"
+	"github.com/prometheus/client_golang/prometheus"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Overflow policies of the inbound queue
+const (
+	overflowSpill      = "spill"       // Park messages in Postgres until the queue has caught up
+	overflowDropOldest = "drop_oldest" // Discard the oldest queued message
+	overflowBlock      = "block"       // Hold whatsmeow's dispatch up to the block timeout, then discard the new message
+)
+
+// spillBatch is how many spilled messages are loaded per pass
+const spillBatch = 100
+
+// spillRetryInterval is how often a failed spill drain is retried
+const spillRetryInterval = 5 * time.Second
+
+// inboundSettings controls the per-session queue in front of the inbound pipeline
+type inboundSettings struct {
+	queueSize    int
+	overflow     string
+	blockTimeout time.Duration
+}
+
+func newInboundSettings(ic config.Inbound) inboundSettings {
+	settings := inboundSettings{
+		queueSize:    ic.QueueSize,
+		overflow:     ic.Overflow,
+		blockTimeout: time.Duration(ic.BlockTimeoutMs) * time.Millisecond,
+	}
+	if settings.queueSize <= 0 {
+		settings.queueSize = 100
+	}
+	switch settings.overflow {
+	case overflowSpill, overflowDropOldest, overflowBlock:
+	case "":
+		settings.overflow = overflowSpill
+	default:
+		log.Printf("Unknown inbound overflow policy %q, spilling instead", settings.overflow)
+		settings.overflow = overflowSpill
+	}
+	if settings.blockTimeout <= 0 {
+		settings.blockTimeout = 5 * time.Second
+	}
+	return settings
+}
+
+// inboundItem is a received message waiting for the pipeline
+type inboundItem struct {
+	event      *events.Message
+	receivedAt time.Time
+}
+
+// inboundQueue decouples whatsmeow's event dispatch from the inbound pipeline, so
+// slow processing never stalls the connection itself
+type inboundQueue struct {
+	items     chan inboundItem
+	spillWake chan struct{} // Signals the processor that messages were spilled
+
+	// Once a message is spilled every later one is too until the spill is drained,
+	// which keeps processing in arrival order
+	spillMutex sync.Mutex
+	spilling   bool
+
+	spilled    atomic.Int64 // Messages waiting in the spill table
+	processing atomic.Int64 // Receive time in unix nanoseconds of the message being processed, zero when idle
+}
+
+// newInboundQueue creates the user's queue, picking up messages spilled before a restart
+func (s *service) newInboundQueue(userID uint) *inboundQueue {
+	q := &inboundQueue{
+		items:     make(chan inboundItem, s.inboundSettings.queueSize),
+		spillWake: make(chan struct{}, 1),
+	}
+
+	var pending int64
+	err := database.DBClient().Model(&entities.WhatsAppInboundSpill{}).Where("user_id = ?", userID).Count(&pending).Error
+	if err != nil {
+		log.Printf("Failed to count spilled messages of user %d: %v", userID, err)
+	}
+	if pending > 0 {
+		q.spilling = true
+		q.spilled.Store(pending)
+		q.spillWake <- struct{}{}
+	}
+	return q
+}
+
+// depth is the number of messages waiting, queued or spilled
+func (q *inboundQueue) depth() int64 {
+	return int64(len(q.items)) + q.spilled.Load()
+}
+
+// lag is how long ago the message being processed was received
+func (q *inboundQueue) lag() time.Duration {
+	receivedAt := q.processing.Load()
+	if receivedAt == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, receivedAt))
+}
+
+func (q *inboundQueue) isSpilling() bool {
+	q.spillMutex.Lock()
+	defer q.spillMutex.Unlock()
+	return q.spilling
+}
+
+// enqueueInbound hands a received message to the session's processor, applying the
+// overflow policy when the queue is full. It runs on whatsmeow's dispatch goroutine.
+func (s *service) enqueueInbound(session *UserSession, evt *events.Message) {
+	q := session.Inbound
+	item := inboundItem{event: evt, receivedAt: time.Now()}
+
+	switch s.inboundSettings.overflow {
+	case overflowDropOldest:
+		for {
+			select {
+			case q.items <- item:
+				return
+			default:
+			}
+			select {
+			case dropped := <-q.items:
+				inboundDropped.WithLabelValues("oldest").Inc()
+				log.Printf("Inbound queue of user %d full, dropped message %s", session.UserID, dropped.event.Info.ID)
+			default:
+			}
+		}
+
+	case overflowBlock:
+		select {
+		case q.items <- item:
+			return
+		default:
+		}
+		timer := time.NewTimer(s.inboundSettings.blockTimeout)
+		defer timer.Stop()
+		select {
+		case q.items <- item:
+		case <-timer.C:
+			inboundDropped.WithLabelValues("timeout").Inc()
+			log.Printf("Inbound queue of user %d full for %s, dropped message %s", session.UserID, s.inboundSettings.blockTimeout, evt.Info.ID)
+		case <-session.Ctx.Done():
+		}
+
+	default:
+		q.spillMutex.Lock()
+		defer q.spillMutex.Unlock()
+		if !q.spilling {
+			select {
+			case q.items <- item:
+				return
+			default:
+				q.spilling = true
+			}
+		}
+		if err := spillInbound(session.UserID, item); err != nil {
+			inboundDropped.WithLabelValues("spill_failed").Inc()
+			log.Printf("Failed to spill message %s of user %d: %v", evt.Info.ID, session.UserID, err)
+			return
+		}
+		q.spilled.Add(1)
+		inboundSpilled.Inc()
+		select {
+		case q.spillWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// spillInbound parks a message in Postgres
+func spillInbound(userID uint, item inboundItem) error {
+	info, err := json.Marshal(item.event.Info)
+	if err != nil {
+		return fmt.Errorf("failed to encode message info: %v", err)
+	}
+	msg := item.event.RawMessage
+	if msg == nil {
+		msg = item.event.Message
+	}
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %v", err)
+	}
+
+	return database.DBClient().Create(&entities.WhatsAppInboundSpill{
+		UserID:     userID,
+		Info:       string(info),
+		RawMessage: raw,
+		ReceivedAt: item.receivedAt,
+	}).Error
+}
+
+// unspillInbound restores a parked message
+func unspillInbound(row *entities.WhatsAppInboundSpill) (*events.Message, error) {
+	evt := &events.Message{RawMessage: &waProto.Message{}}
+	if err := json.Unmarshal([]byte(row.Info), &evt.Info); err != nil {
+		return nil, fmt.Errorf("failed to decode message info: %v", err)
+	}
+	if err := proto.Unmarshal(row.RawMessage, evt.RawMessage); err != nil {
+		return nil, fmt.Errorf("failed to decode message: %v", err)
+	}
+	return evt.UnwrapRaw(), nil
+}
+
+// eventProcessor runs the inbound pipeline over a user's received messages in the background
+func (s *service) eventProcessor(session *UserSession) {
+	q := session.Inbound
+	retry := time.NewTicker(spillRetryInterval)
+	defer retry.Stop()
+
+	for {
+		// Queued messages are older than spilled ones
+		if len(q.items) == 0 && q.isSpilling() {
+			s.drainSpill(session)
+		}
+
+		select {
+		case item := <-q.items:
+			s.processInbound(session, item)
+		case <-q.spillWake:
+		case <-retry.C:
+		case <-session.Ctx.Done():
+			log.Printf("Event processor stopped for user %d", session.UserID)
+			return
+		}
+	}
+}
+
+// drainSpill processes spilled messages in arrival order until none are left. Each is
+// deleted once processed; one processed again after a crash is deduplicated on store.
+func (s *service) drainSpill(session *UserSession) {
+	q := session.Inbound
+	db := database.DBClient()
+
+	for session.Ctx.Err() == nil {
+		q.spillMutex.Lock()
+		var rows []entities.WhatsAppInboundSpill
+		if err := db.Where("user_id = ?", session.UserID).Order("id").Limit(spillBatch).Find(&rows).Error; err != nil {
+			q.spillMutex.Unlock()
+			log.Printf("Failed to load spilled messages of user %d: %v", session.UserID, err)
+			return
+		}
+		if len(rows) == 0 {
+			q.spilling = false
+			q.spilled.Store(0)
+			q.spillMutex.Unlock()
+			return
+		}
+		q.spillMutex.Unlock()
+
+		for i := range rows {
+			if session.Ctx.Err() != nil {
+				return
+			}
+			evt, err := unspillInbound(&rows[i])
+			if err != nil {
+				log.Printf("Discarding spilled message %d of user %d: %v", rows[i].ID, session.UserID, err)
+			} else {
+				s.processInbound(session, inboundItem{event: evt, receivedAt: rows[i].ReceivedAt})
+			}
+			if err := db.Unscoped().Delete(&rows[i]).Error; err != nil {
+				log.Printf("Failed to delete spilled message %d of user %d: %v", rows[i].ID, session.UserID, err)
+				return
+			}
+			q.spilled.Add(-1)
+		}
+	}
+}
+
+// processInbound logs a received message and passes it through the pipeline
+func (s *service) processInbound(session *UserSession, item inboundItem) {
+	q := session.Inbound
+	q.processing.Store(item.receivedAt.UnixNano())
+	defer q.processing.Store(0)
+	inboundWait.Observe(time.Since(item.receivedAt).Seconds())
+
+	event := item.event
+	sender := event.Info.SourceString()
+	messageText, messageType := messageContent(event.Message)
+	log.Printf("📱 WhatsApp Message [User %d] - From: %s | Type: %s | Content: %s | Timestamp: %v",
+		session.UserID, sender, messageType, messageText, event.Info.Timestamp)
+
+	s.runInbound(session.Ctx, &InboundMessage{Session: session, Event: event})
+}
+
+// inboundQueueCollector reports the depth and lag of every live session's inbound queue
+type inboundQueueCollector struct {
+	s *service
+}
+
+var (
+	inboundDepthDesc = prometheus.NewDesc("whatsapp_inbound_queue_depth",
+		"Received messages waiting for the inbound pipeline, queued or spilled.", []string{"user_id"}, nil)
+	inboundLagDesc = prometheus.NewDesc("whatsapp_inbound_queue_lag_seconds",
+		"Age of the message the inbound pipeline is processing, zero when idle.", []string{"user_id"}, nil)
+)
+
+func (c inboundQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- inboundDepthDesc
+	ch <- inboundLagDesc
+}
+
+func (c inboundQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	c.s.mutex.RLock()
+	defer c.s.mutex.RUnlock()
+
+	for userID, session := range c.s.sessions {
+		if session.Inbound == nil {
+			continue
+		}
+		user := strconv.FormatUint(uint64(userID), 10)
+		ch <- prometheus.MustNewConstMetric(inboundDepthDesc, prometheus.GaugeValue, float64(session.Inbound.depth()), user)
+		ch <- prometheus.MustNewConstMetric(inboundLagDesc, prometheus.GaugeValue, session.Inbound.lag().Seconds(), user)
+	}
+}
@@ -0,0 +1,221 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/entities"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNewInboundSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		ic   config.Inbound
+		want inboundSettings
+	}{
+		{
+			name: "defaults",
+			want: inboundSettings{queueSize: 100, overflow: overflowSpill, blockTimeout: 5 * time.Second},
+		},
+		{
+			name: "configured",
+			ic:   config.Inbound{QueueSize: 10, Overflow: overflowBlock, BlockTimeoutMs: 250},
+			want: inboundSettings{queueSize: 10, overflow: overflowBlock, blockTimeout: 250 * time.Millisecond},
+		},
+		{
+			name: "unknown policy spills",
+			ic:   config.Inbound{QueueSize: 10, Overflow: "discard"},
+			want: inboundSettings{queueSize: 10, overflow: overflowSpill, blockTimeout: 5 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newInboundSettings(tt.ic); got != tt.want {
+				t.Fatalf("newInboundSettings = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestInboundSession returns a session whose queue holds size messages, without
+// touching the spill table
+func newTestInboundSession(t *testing.T, size int) *UserSession {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &UserSession{
+		UserID: 1,
+		Inbound: &inboundQueue{
+			items:     make(chan inboundItem, size),
+			spillWake: make(chan struct{}, 1),
+		},
+		Ctx:    ctx,
+		Cancel: cancel,
+	}
+}
+
+func inboundEvent(id string) *events.Message {
+	return &events.Message{Info: types.MessageInfo{ID: types.MessageID(id)}}
+}
+
+// queuedIDs empties the queue and returns the IDs it held, oldest first
+func queuedIDs(q *inboundQueue) []string {
+	var ids []string
+	for len(q.items) > 0 {
+		item := <-q.items
+		ids = append(ids, string(item.event.Info.ID))
+	}
+	return ids
+}
+
+func TestEnqueueInboundOverflow(t *testing.T) {
+	tests := []struct {
+		name        string
+		overflow    string
+		queueSize   int
+		ids         []string
+		consume     bool // Take one message off the queue while the last one is enqueued
+		cancel      bool // End the session before the last message is enqueued
+		wantQueued  []string
+		wantDropped map[string]float64
+	}{
+		{
+			name:       "spill queues while there is room",
+			overflow:   overflowSpill,
+			queueSize:  3,
+			ids:        []string{"a", "b", "c"},
+			wantQueued: []string{"a", "b", "c"},
+		},
+		{
+			name:        "drop oldest keeps the newest",
+			overflow:    overflowDropOldest,
+			queueSize:   2,
+			ids:         []string{"a", "b", "c", "d"},
+			wantQueued:  []string{"c", "d"},
+			wantDropped: map[string]float64{"oldest": 2},
+		},
+		{
+			name:        "block drops the new message after the timeout",
+			overflow:    overflowBlock,
+			queueSize:   1,
+			ids:         []string{"a", "b"},
+			wantQueued:  []string{"a"},
+			wantDropped: map[string]float64{"timeout": 1},
+		},
+		{
+			name:       "block waits for room",
+			overflow:   overflowBlock,
+			queueSize:  1,
+			ids:        []string{"a", "b"},
+			consume:    true,
+			wantQueued: []string{"b"},
+		},
+		{
+			name:       "block gives up when the session ends",
+			overflow:   overflowBlock,
+			queueSize:  1,
+			ids:        []string{"a", "b"},
+			cancel:     true,
+			wantQueued: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{inboundSettings: inboundSettings{
+				queueSize:    tt.queueSize,
+				overflow:     tt.overflow,
+				blockTimeout: 50 * time.Millisecond,
+			}}
+			if tt.cancel || tt.consume {
+				s.inboundSettings.blockTimeout = time.Minute
+			}
+			session := newTestInboundSession(t, tt.queueSize)
+
+			before := map[string]float64{}
+			for reason := range tt.wantDropped {
+				before[reason] = testutil.ToFloat64(inboundDropped.WithLabelValues(reason))
+			}
+
+			last := len(tt.ids) - 1
+			for _, id := range tt.ids[:last] {
+				s.enqueueInbound(session, inboundEvent(id))
+			}
+			switch {
+			case tt.consume:
+				go func() {
+					time.Sleep(20 * time.Millisecond)
+					<-session.Inbound.items
+				}()
+			case tt.cancel:
+				session.Cancel()
+			}
+
+			done := make(chan struct{})
+			go func() {
+				s.enqueueInbound(session, inboundEvent(tt.ids[last]))
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("enqueueInbound did not return")
+			}
+
+			got := queuedIDs(session.Inbound)
+			if len(got) != len(tt.wantQueued) {
+				t.Fatalf("queued = %v, want %v", got, tt.wantQueued)
+			}
+			for i := range got {
+				if got[i] != tt.wantQueued[i] {
+					t.Fatalf("queued = %v, want %v", got, tt.wantQueued)
+				}
+			}
+			for reason, want := range tt.wantDropped {
+				if got := testutil.ToFloat64(inboundDropped.WithLabelValues(reason)) - before[reason]; got != want {
+					t.Fatalf("dropped with reason %q = %v, want %v", reason, got, want)
+				}
+			}
+			if session.Inbound.isSpilling() {
+				t.Fatalf("queue switched to spilling")
+			}
+		})
+	}
+}
+
+func TestUnspillInbound(t *testing.T) {
+	info := types.MessageInfo{ID: "3EB0ABC", Timestamp: time.Date(2024, 1, 31, 14, 5, 0, 0, time.UTC)}
+	encodedInfo, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("encoding info: %v", err)
+	}
+	raw, err := proto.Marshal(&waProto.Message{
+		EphemeralMessage: &waProto.FutureProofMessage{Message: &waProto.Message{Conversation: proto.String("hello")}},
+	})
+	if err != nil {
+		t.Fatalf("encoding message: %v", err)
+	}
+
+	evt, err := unspillInbound(&entities.WhatsAppInboundSpill{Info: string(encodedInfo), RawMessage: raw})
+	if err != nil {
+		t.Fatalf("unspillInbound: %v", err)
+	}
+	if evt.Info.ID != info.ID || !evt.Info.Timestamp.Equal(info.Timestamp) {
+		t.Fatalf("info = %+v, want %+v", evt.Info, info)
+	}
+	if !evt.IsEphemeral || evt.Message.GetConversation() != "hello" {
+		t.Fatalf("message was not unwrapped: ephemeral %v, text %q", evt.IsEphemeral, evt.Message.GetConversation())
+	}
+
+	if _, err := unspillInbound(&entities.WhatsAppInboundSpill{Info: "{", RawMessage: raw}); err == nil {
+		t.Fatalf("unspillInbound with corrupt info succeeded, want error")
+	}
+}
@@ -0,0 +1,433 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"github.com/crm/pkg/state"
+	"github.com/crm/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// ErrScheduleNotFound is returned when a scheduled message does not exist for the user
+var ErrScheduleNotFound = errors.New(constant.SCHEDULE_NOT_FOUND)
+
+// ErrInvalidSchedule is returned for unparsable times, time zones or recurrences
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// localTimeLayouts are accepted for send_at values without an offset, read in the schedule's time zone
+var localTimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// scheduleDueBatch is how many due messages the worker picks up per poll
+const scheduleDueBatch = 100
+
+// scheduleSendTimeout bounds a single scheduled send, including pacing delays
+const scheduleSendTimeout = 2 * time.Minute
+
+func schedulerInterval(sc config.Scheduler) time.Duration {
+	interval := time.Duration(sc.PollIntervalSec) * time.Second
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	return interval
+}
+
+// isScheduled reports whether a send request should be deferred instead of sent now
+func isScheduled(req dtos.ScheduleDTO) bool {
+	return req.SendAt != "" || req.Recurrence != ""
+}
+
+// resolveSchedule validates req and returns the first run in UTC together with the normalized time zone
+func resolveSchedule(req dtos.ScheduleDTO) (time.Time, string, error) {
+	timezone := req.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: unknown timezone %q", ErrInvalidSchedule, req.Timezone)
+	}
+
+	var cron *utils.CronSchedule
+	if req.Recurrence != "" {
+		cron, err = utils.ParseCron(req.Recurrence)
+		if err != nil {
+			return time.Time{}, "", fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+	}
+
+	now := time.Now()
+	var sendAt time.Time
+	switch {
+	case req.SendAt != "":
+		sendAt, err = parseSendAt(req.SendAt, loc)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		if sendAt.Before(now.Add(-time.Minute)) {
+			return time.Time{}, "", fmt.Errorf("%w: send_at is in the past", ErrInvalidSchedule)
+		}
+	default:
+		sendAt = cron.Next(now.In(loc))
+	}
+	if sendAt.IsZero() {
+		return time.Time{}, "", fmt.Errorf("%w: recurrence never fires", ErrInvalidSchedule)
+	}
+	return sendAt.UTC(), timezone, nil
+}
+
+func parseSendAt(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: send_at must be RFC3339 or YYYY-MM-DD HH:MM", ErrInvalidSchedule)
+}
+
+// scheduleMessage stores a deferred text send
+func (s *service) scheduleMessage(ctx context.Context, userID uint, req dtos.SendMessageDTO) (*dtos.MessageResponseDTO, error) {
+	return s.createSchedule(ctx, &entities.WhatsAppScheduledMessage{
+		UserID:        userID,
+		PhoneNumber:   req.PhoneNumber,
+		Message:       req.Message,
+		Transactional: req.Transactional,
+	}, req.ScheduleDTO)
+}
+
+// scheduleMediaMessage stores a deferred media send, backed by a library entry or a retained blob
+func (s *service) scheduleMediaMessage(ctx context.Context, userID uint, req dtos.SendMediaMessageDTO) (*dtos.MessageResponseDTO, error) {
+	if _, _, err := resolveSchedule(req.ScheduleDTO); err != nil {
+		return nil, err
+	}
+	key, err := s.retainMedia(ctx, userID, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.createSchedule(ctx, &entities.WhatsAppScheduledMessage{
+		UserID:      userID,
+		PhoneNumber: req.PhoneNumber,
+		Message:     req.Caption,
+		MediaID:     req.MediaID,
+		StorageKey:  key,
+		MimeType:    req.MimeType,
+		FileName:    req.FileName,
+		FileSize:    req.FileSize,
+		ViewOnce:    req.ViewOnce,
+		Voice:       req.Voice,
+		Height:      req.Height,
+		Width:       req.Width,
+
+		Transactional: req.Transactional,
+	}, req.ScheduleDTO)
+	if err != nil {
+		s.releaseMedia(key)
+	}
+	return resp, err
+}
+
+func (s *service) createSchedule(ctx context.Context, schedule *entities.WhatsAppScheduledMessage, req dtos.ScheduleDTO) (*dtos.MessageResponseDTO, error) {
+	if _, err := s.formatPhoneNumber(schedule.PhoneNumber); err != nil {
+		return nil, fmt.Errorf(constant.INVALID_PHONE_NUMBER+": %v", err)
+	}
+
+	sendAt, timezone, err := resolveSchedule(req)
+	if err != nil {
+		return nil, err
+	}
+	schedule.SendAt = sendAt
+	schedule.Timezone = timezone
+	schedule.Recurrence = req.Recurrence
+	schedule.Status = constant.SCHEDULE_STATUS_SCHEDULED
+
+	if err := database.DBClient().WithContext(ctx).Create(schedule).Error; err != nil {
+		return nil, fmt.Errorf("failed to schedule message: %v", err)
+	}
+
+	log.Printf("Message %d scheduled by user %d for %s", schedule.ID, schedule.UserID, sendAt.Format(time.RFC3339))
+	return &dtos.MessageResponseDTO{
+		Timestamp:  sendAt.Format(time.RFC3339),
+		Status:     constant.SCHEDULE_STATUS_SCHEDULED,
+		To:         schedule.PhoneNumber,
+		ScheduleID: schedule.ID,
+	}, nil
+}
+
+// runScheduler fires due scheduled messages. State lives in the database, so
+// pending messages survive restarts and are picked up on the next poll.
+func (s *service) runScheduler() {
+	// A crash mid-send leaves rows claimed; hand them back to the worker
+	database.DBClient().Model(&entities.WhatsAppScheduledMessage{}).
+		Where("status = ?", constant.SCHEDULE_STATUS_SENDING).
+		Update("status", constant.SCHEDULE_STATUS_SCHEDULED)
+
+	ticker := time.NewTicker(s.schedulerInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.runDueSchedules()
+	}
+}
+
+// runDueSchedules fires one batch of due messages concurrently and waits for it to finish
+func (s *service) runDueSchedules() {
+	// Messages wait until their owner is connected again, without holding up other users
+	ready := s.readyUsers()
+	if len(ready) == 0 {
+		return
+	}
+
+	db := database.DBClient()
+	var due []entities.WhatsAppScheduledMessage
+	err := db.Where("status = ? AND send_at <= ? AND user_id IN ?", constant.SCHEDULE_STATUS_SCHEDULED, time.Now(), ready).
+		Order("send_at").Limit(scheduleDueBatch).Find(&due).Error
+	if err != nil {
+		log.Printf("Failed to load due scheduled messages: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := range due {
+		schedule := &due[i]
+
+		claim := db.Model(schedule).Where("status = ?", constant.SCHEDULE_STATUS_SCHEDULED).
+			Update("status", constant.SCHEDULE_STATUS_SENDING)
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.fireSchedule(schedule)
+		}()
+	}
+	wg.Wait()
+}
+
+// readyUsers returns the users whose session can send right now
+func (s *service) readyUsers() []uint {
+	s.mutex.RLock()
+	userIDs := make([]uint, 0, len(s.sessions))
+	for userID := range s.sessions {
+		userIDs = append(userIDs, userID)
+	}
+	s.mutex.RUnlock()
+
+	ready := userIDs[:0]
+	for _, userID := range userIDs {
+		if s.sessionReady(userID) {
+			ready = append(ready, userID)
+		}
+	}
+	return ready
+}
+
+// sessionReady reports whether the user's session can send right now
+func (s *service) sessionReady(userID uint) bool {
+	s.mutex.RLock()
+	session, exists := s.sessions[userID]
+	s.mutex.RUnlock()
+
+	return exists && session.Client != nil && session.IsConnected &&
+		session.Client.IsConnected() && session.Client.Store.ID != nil
+}
+
+// fireSchedule sends one scheduled message through the normal send path and computes its next run
+func (s *service) fireSchedule(schedule *entities.WhatsAppScheduledMessage) {
+	ctx, cancel := context.WithTimeout(state.SetCurrentUser(context.Background(), schedule.UserID), scheduleSendTimeout)
+	defer cancel()
+
+	var (
+		resp *dtos.MessageResponseDTO
+		err  error
+	)
+	if schedule.MediaID != 0 || schedule.StorageKey != "" {
+		resp, err = s.sendScheduledMedia(ctx, schedule)
+	} else {
+		resp, err = s.SendMessage(ctx, dtos.SendMessageDTO{
+			PhoneNumber:   schedule.PhoneNumber,
+			Message:       schedule.Message,
+			Transactional: schedule.Transactional,
+		})
+	}
+
+	now := time.Now()
+	schedule.RunCount++
+	schedule.LastRunAt = &now
+	if err != nil {
+		log.Printf("Scheduled message %d of user %d failed: %v", schedule.ID, schedule.UserID, err)
+		schedule.Error = err.Error()
+		schedule.Status = constant.SCHEDULE_STATUS_FAILED
+	} else {
+		log.Printf("Scheduled message %d of user %d sent. ID: %s", schedule.ID, schedule.UserID, resp.MessageID)
+		schedule.Error = ""
+		schedule.LastMessageID = resp.MessageID
+		schedule.Status = constant.SCHEDULE_STATUS_SENT
+	}
+
+	// Recurring messages keep going after a failed run; missed runs are skipped rather than replayed
+	if schedule.Recurrence != "" {
+		if next := nextRun(schedule, now); !next.IsZero() {
+			schedule.SendAt = next
+			schedule.Status = constant.SCHEDULE_STATUS_SCHEDULED
+		}
+	}
+
+	if err := database.DBClient().Save(schedule).Error; err != nil {
+		log.Printf("Failed to update scheduled message %d: %v", schedule.ID, err)
+		return
+	}
+	// Failed and cancelled messages keep their media so they can be rescheduled
+	if schedule.Status == constant.SCHEDULE_STATUS_SENT {
+		s.releaseMedia(schedule.StorageKey)
+	}
+}
+
+// sendScheduledMedia sends a scheduled media message, streaming a retained upload from the blob store
+func (s *service) sendScheduledMedia(ctx context.Context, schedule *entities.WhatsAppScheduledMessage) (*dtos.MessageResponseDTO, error) {
+	req := dtos.SendMediaMessageDTO{
+		PhoneNumber: schedule.PhoneNumber,
+		Caption:     schedule.Message,
+		MediaID:     schedule.MediaID,
+		FileName:    schedule.FileName,
+		FileSize:    schedule.FileSize,
+		MimeType:    schedule.MimeType,
+		Height:      schedule.Height,
+		Width:       schedule.Width,
+		ViewOnce:    schedule.ViewOnce,
+		Voice:       schedule.Voice,
+
+		Transactional: schedule.Transactional,
+	}
+	if schedule.StorageKey != "" {
+		content, err := s.store.Get(ctx, schedule.StorageKey)
+		if err != nil {
+			return nil, fmt.Errorf(constant.FILE_READ_FAILED+": %v", err)
+		}
+		defer content.Close()
+		req.Media = content
+	}
+	return s.SendMediaMessage(ctx, req)
+}
+
+// nextRun returns the next recurrence after now in the schedule's time zone, or zero if there is none
+func nextRun(schedule *entities.WhatsAppScheduledMessage, now time.Time) time.Time {
+	cron, err := utils.ParseCron(schedule.Recurrence)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return cron.Next(now.In(loc)).UTC()
+}
+
+// ListScheduledMessages returns the caller's scheduled messages, optionally filtered by status
+func (s *service) ListScheduledMessages(ctx context.Context, status string) ([]entities.WhatsAppScheduledMessage, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	query := database.DBClient().WithContext(ctx).Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var schedules []entities.WhatsAppScheduledMessage
+	if err := query.Order("send_at").Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list scheduled messages: %v", err)
+	}
+	return schedules, nil
+}
+
+func (s *service) CancelScheduledMessage(ctx context.Context, id uint) error {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("authentication required: %v", err)
+	}
+	if _, err := s.findSchedule(ctx, userID, id); err != nil {
+		return err
+	}
+
+	result := database.DBClient().WithContext(ctx).Model(&entities.WhatsAppScheduledMessage{}).
+		Where("id = ? AND status = ?", id, constant.SCHEDULE_STATUS_SCHEDULED).
+		Update("status", constant.SCHEDULE_STATUS_CANCELLED)
+	if result.Error != nil {
+		return fmt.Errorf("failed to cancel scheduled message: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: only pending messages can be cancelled", ErrInvalidSchedule)
+	}
+	return nil
+}
+
+// RescheduleMessage moves a message to a new time or recurrence and re-activates it
+func (s *service) RescheduleMessage(ctx context.Context, id uint, req dtos.RescheduleDTO) (*entities.WhatsAppScheduledMessage, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	schedule, err := s.findSchedule(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if !isScheduled(req.ScheduleDTO) {
+		return nil, fmt.Errorf("%w: send_at or recurrence is required", ErrInvalidSchedule)
+	}
+	// Uploaded media is deleted once its message is sent
+	if schedule.Status == constant.SCHEDULE_STATUS_SENT && schedule.StorageKey != "" {
+		return nil, fmt.Errorf("%w: media of a sent message is no longer kept", ErrInvalidSchedule)
+	}
+
+	sendAt, timezone, err := resolveSchedule(req.ScheduleDTO)
+	if err != nil {
+		return nil, err
+	}
+
+	// The worker may have claimed the message in the meantime
+	result := database.DBClient().WithContext(ctx).Model(schedule).
+		Where("status <> ?", constant.SCHEDULE_STATUS_SENDING).
+		Updates(map[string]interface{}{
+			"send_at":    sendAt,
+			"timezone":   timezone,
+			"recurrence": req.Recurrence,
+			"status":     constant.SCHEDULE_STATUS_SCHEDULED,
+			"error":      "",
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to reschedule message: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: message is being sent", ErrInvalidSchedule)
+	}
+
+	return s.findSchedule(ctx, userID, id)
+}
+
+func (s *service) findSchedule(ctx context.Context, userID, id uint) (*entities.WhatsAppScheduledMessage, error) {
+	var schedule entities.WhatsAppScheduledMessage
+	err := database.DBClient().WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&schedule).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled message: %v", err)
+	}
+	return &schedule, nil
+}
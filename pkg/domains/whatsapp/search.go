@@ -0,0 +1,181 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"gorm.io/gorm"
+)
+
+const (
+	// searchPageSize matches the page size of the other paginated endpoints
+	searchPageSize = 10
+	// searchSnippetOptions controls the ts_headline excerpts returned with each hit. Hits
+	// are delimited with private-use characters that become <mark> tags after escaping.
+	searchSnippetOptions = "StartSel=" + snippetStart + ", StopSel=" + snippetStop + ", MaxFragments=2, MaxWords=20, MinWords=8"
+	snippetStart         = "\uE000"
+	snippetStop          = "\uE001"
+)
+
+// snippetHTML escapes a ts_headline excerpt and turns its hit delimiters into <mark> tags
+func snippetHTML(headline string) string {
+	escaped := html.EscapeString(headline)
+	return strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>").Replace(escaped)
+}
+
+// ErrInvalidSearch is returned for search requests with a missing query or malformed filters
+var ErrInvalidSearch = errors.New(constant.INVALID_SEARCH_QUERY)
+
+func defaultSearchLanguage(sc config.Search) string {
+	if sc.Language == "" {
+		return "simple"
+	}
+	return sc.Language
+}
+
+// parseTimeFilter accepts an RFC3339 time or a YYYY-MM-DD date. Dates used as the
+// upper bound cover the whole day.
+func parseTimeFilter(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC3339 time or YYYY-MM-DD date", value)
+	}
+	if endOfDay {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return day, nil
+}
+
+// SearchMessages runs a full-text search over the caller's stored messages, best matches first
+func (s *service) SearchMessages(ctx context.Context, req dtos.MessageSearchDTO) ([]dtos.MessageSearchResultDTO, int, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("authentication required: %v", err)
+	}
+
+	text := strings.TrimSpace(req.Query)
+	if text == "" {
+		return nil, 0, fmt.Errorf("%w: q is required", ErrInvalidSearch)
+	}
+
+	db := database.DBClient().WithContext(ctx)
+	language := s.searchLanguage
+	if req.Language != "" {
+		var known int64
+		if err := db.Table("pg_ts_config").Where("cfgname = ?", req.Language).Count(&known).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to check search language: %v", err)
+		}
+		if known == 0 {
+			return nil, 0, fmt.Errorf("%w: unknown language %q", ErrInvalidSearch, req.Language)
+		}
+		language = req.Language
+	}
+
+	query := db.Model(&entities.WhatsAppMessage{}).
+		Where("user_id = ? AND search_vector @@ websearch_to_tsquery(?::regconfig, ?)", userID, language, text)
+
+	if req.ChatJID != "" {
+		chat, err := s.resolveChat("", req.ChatJID)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where("chat_jid = ?", chat.String())
+	}
+	if req.Contact != "" {
+		phoneNumber, chatJID := req.Contact, ""
+		if strings.Contains(req.Contact, "@") {
+			phoneNumber, chatJID = "", req.Contact
+		}
+		contact, err := s.resolveChat(phoneNumber, chatJID)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Messages they sent anywhere, and everything in the direct chat with them
+		query = query.Where("(from_jid = ? OR chat_jid = ?)", contact.String(), contact.String())
+	}
+	if req.From != "" {
+		from, err := parseTimeFilter(req.From, false)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+		}
+		query = query.Where("timestamp >= ?", from)
+	}
+	if req.To != "" {
+		to, err := parseTimeFilter(req.To, true)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+		}
+		query = query.Where("timestamp <= ?", to)
+	}
+	switch req.Direction {
+	case "":
+	case "incoming", "outgoing":
+		query = query.Where("is_incoming = ?", req.Direction == "incoming")
+	default:
+		return nil, 0, fmt.Errorf("%w: direction must be incoming or outgoing", ErrInvalidSearch)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %v", err)
+	}
+	if total == 0 {
+		return []dtos.MessageSearchResultDTO{}, 0, nil
+	}
+	totalPages := int((total + searchPageSize - 1) / searchPageSize)
+	if req.Page <= 0 || req.Page > totalPages {
+		return nil, 0, errors.New(constant.PAGE_NUMBER_OUT_OF_RANGE)
+	}
+
+	var hits []struct {
+		ID          uint
+		MessageID   string
+		ChatJID     string
+		FromJID     string
+		IsIncoming  bool
+		MessageType string
+		Timestamp   time.Time
+		Snippet     string
+		Rank        float64
+	}
+	err = query.
+		Select(`id, message_id, chat_jid, from_jid, is_incoming, message_type, timestamp,
+			ts_headline(?::regconfig, content, websearch_to_tsquery(?::regconfig, ?), ?) AS snippet,
+			ts_rank(search_vector, websearch_to_tsquery(?::regconfig, ?)) AS rank`,
+			language, language, text, searchSnippetOptions, language, text).
+		Order("rank desc, timestamp desc").
+		Limit(searchPageSize).Offset((req.Page - 1) * searchPageSize).
+		Scan(&hits).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search messages: %v", err)
+	}
+
+	results := make([]dtos.MessageSearchResultDTO, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, dtos.MessageSearchResultDTO{
+			ID:          hit.ID,
+			MessageID:   hit.MessageID,
+			ChatJID:     hit.ChatJID,
+			FromJID:     hit.FromJID,
+			IsIncoming:  hit.IsIncoming,
+			MessageType: hit.MessageType,
+			Timestamp:   hit.Timestamp.Format(time.RFC3339),
+			Snippet:     snippetHTML(hit.Snippet),
+			Rank:        hit.Rank,
+		})
+	}
+	return results, totalPages, nil
+}
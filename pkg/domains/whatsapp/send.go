@@ -0,0 +1,100 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/entities"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// sendTo is the single path every outgoing message takes through a session.
+// Sends are paced unless ctx was marked transactional. Sent messages are stored,
+// since WhatsApp does not echo a device's own sends back to it.
+func (s *service) sendTo(ctx context.Context, session *UserSession, recipient types.JID, msg *waProto.Message) (whatsmeow.SendResponse, error) {
+	sent := false
+	if !isTransactional(ctx) {
+		done, err := s.pace(ctx, session, recipient, msg)
+		defer func() { done(sent) }()
+		if err != nil {
+			return whatsmeow.SendResponse{}, err
+		}
+	}
+	resp, err := session.Client.SendMessage(ctx, recipient, msg)
+	if err != nil {
+		return resp, err
+	}
+	sent = true
+	s.storeOutgoingMessage(session, recipient, msg, resp)
+	return resp, nil
+}
+
+// storeOutgoingMessage records a sent message in history. Status posts and broadcast
+// lists are not chats and are left out.
+func (s *service) storeOutgoingMessage(session *UserSession, recipient types.JID, msg *waProto.Message, resp whatsmeow.SendResponse) {
+	if recipient.Server == types.BroadcastServer || !renderable(msg) {
+		return
+	}
+
+	content, messageType := messageContent(msg)
+	contextInfo := contextInfoOf(msg)
+	record := &entities.WhatsAppMessage{
+		UserID:      session.UserID,
+		MessageID:   resp.ID,
+		ChatJID:     recipient.String(),
+		FromJID:     session.Client.Store.ID.ToNonAD().String(),
+		ToJID:       recipient.String(),
+		Content:     content,
+		MessageType: messageType,
+		Timestamp:   resp.Timestamp,
+
+		ForwardingScore: contextInfo.GetForwardingScore(),
+		SearchLanguage:  s.searchLanguage,
+	}
+	if seconds := contextInfo.GetExpiration(); seconds > 0 {
+		expiresAt := resp.Timestamp.Add(time.Duration(seconds) * time.Second)
+		record.ExpiresAt = &expiresAt
+	}
+	if _, err := insertMessage(record); err != nil {
+		// The message is already out; only its history entry is missing
+		log.Printf("Failed to record sent message %s of user %d: %v", resp.ID, session.UserID, err)
+	}
+}
+
+// ErrInvalidChat is returned when a request names no chat or an unparsable one
+var ErrInvalidChat = errors.New("invalid chat")
+
+// resolveChat returns the chat addressed by a request, preferring an explicit JID over a phone number
+func (s *service) resolveChat(phoneNumber, chatJID string) (types.JID, error) {
+	switch {
+	case chatJID != "":
+		chat, err := types.ParseJID(chatJID)
+		if err != nil {
+			return types.JID{}, fmt.Errorf("%w: invalid chat_jid: %v", ErrInvalidChat, err)
+		}
+		return chat, nil
+	case phoneNumber != "":
+		chat, err := s.formatPhoneNumber(phoneNumber)
+		if err != nil {
+			return types.JID{}, fmt.Errorf("%w: "+constant.INVALID_PHONE_NUMBER+": %v", ErrInvalidChat, err)
+		}
+		return chat, nil
+	default:
+		return types.JID{}, fmt.Errorf("%w: phone_number or chat_jid is required", ErrInvalidChat)
+	}
+}
+
+// textMessage builds a plain text message
+func textMessage(text string) *waProto.Message {
+	return &waProto.Message{
+		Conversation: proto.String(text),
+	}
+}
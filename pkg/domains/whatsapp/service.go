@@ -2,27 +2,32 @@ package whatsapp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"os"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/crm/pkg/config"
 	"github.com/crm/pkg/constant"
 	"github.com/crm/pkg/database"
 	"github.com/crm/pkg/dtos"
 	"github.com/crm/pkg/entities"
 	"github.com/crm/pkg/state"
+	"github.com/crm/pkg/storage"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.mau.fi/whatsmeow"
-	waProto "go.mau.fi/whatsmeow/binary/proto"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	waTypes "go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 	waLog "go.mau.fi/whatsmeow/util/log"
-	"google.golang.org/protobuf/proto"
 	"gorm.io/gorm"
 	_ "modernc.org/sqlite"
 )
@@ -36,6 +41,64 @@ type Service interface {
 	CheckConnection(ctx context.Context, phoneNumber string) (bool, error)
 	GetStatus(ctx context.Context) (string, error)
 	GetContacts(ctx context.Context) (map[types.JID]types.ContactInfo, error)
+	MediaSizeLimit(mimeType string) int64
+	SpoolMedia(r io.Reader, mimeType string) (*os.File, int64, error)
+	SendMediaFromJSON(ctx context.Context, req dtos.SendMediaJSONDTO) (*dtos.MessageResponseDTO, error)
+	UploadLibraryMedia(ctx context.Context, req dtos.LibraryUploadDTO) (*dtos.LibraryMediaDTO, error)
+	ListLibraryMedia(ctx context.Context) ([]dtos.LibraryMediaDTO, error)
+	DeleteLibraryMedia(ctx context.Context, id uint) error
+	GetMessageMedia(ctx context.Context, id uint) (*entities.WhatsAppMessageMedia, io.ReadCloser, error)
+	RetryMessageMedia(ctx context.Context, id uint) (*entities.WhatsAppMessageMedia, error)
+	SetDisappearingTimer(ctx context.Context, req dtos.DisappearingTimerDTO) error
+	CreateBroadcast(ctx context.Context, req dtos.CreateBroadcastDTO) (*entities.WhatsAppBroadcast, error)
+	ListBroadcasts(ctx context.Context) ([]entities.WhatsAppBroadcast, error)
+	GetBroadcast(ctx context.Context, id uint) (*entities.WhatsAppBroadcast, error)
+	GetBroadcastRecipients(ctx context.Context, id uint, status string, page int) ([]entities.WhatsAppBroadcastRecipient, int, error)
+	CancelBroadcast(ctx context.Context, id uint) error
+	ListScheduledMessages(ctx context.Context, status string) ([]entities.WhatsAppScheduledMessage, error)
+	CancelScheduledMessage(ctx context.Context, id uint) error
+	RescheduleMessage(ctx context.Context, id uint, req dtos.RescheduleDTO) (*entities.WhatsAppScheduledMessage, error)
+	ListOutbox(ctx context.Context, status string, page int) ([]entities.WhatsAppOutboxMessage, int, error)
+	GetOutboxMessage(ctx context.Context, id uint) (*entities.WhatsAppOutboxMessage, error)
+	RequeueOutboxMessage(ctx context.Context, id uint) error
+	Idempotent(ctx context.Context, key, endpoint, fingerprint string, send func() (*dtos.MessageResponseDTO, error)) (*dtos.MessageResponseDTO, bool, error)
+	CreateTemplate(ctx context.Context, req dtos.TemplateDTO) (*entities.WhatsAppTemplate, error)
+	ListTemplates(ctx context.Context) ([]entities.WhatsAppTemplate, error)
+	GetTemplate(ctx context.Context, id uint) (*entities.WhatsAppTemplate, error)
+	UpdateTemplate(ctx context.Context, id uint, req dtos.TemplateDTO) (*entities.WhatsAppTemplate, error)
+	DeleteTemplate(ctx context.Context, id uint) error
+	GetPacing(ctx context.Context) (*entities.WhatsAppPacing, error)
+	UpdatePacing(ctx context.Context, req dtos.PacingDTO) (*entities.WhatsAppPacing, error)
+	SetPresence(ctx context.Context, req dtos.PresenceDTO) error
+	SetChatPresence(ctx context.Context, req dtos.ChatPresenceDTO) error
+	MarkRead(ctx context.Context, req dtos.MarkReadDTO) (int, error)
+	GetMessages(ctx context.Context, req dtos.MessageHistoryDTO) ([]entities.WhatsAppMessage, int, error)
+	PostTextStatus(ctx context.Context, req dtos.StatusTextDTO) (*entities.WhatsAppStatusPost, error)
+	PostMediaStatus(ctx context.Context, req dtos.StatusMediaDTO) (*entities.WhatsAppStatusPost, error)
+	GetStatusAudience(ctx context.Context) (*dtos.StatusAudienceDTO, error)
+	ListStatusPosts(ctx context.Context, page int) ([]entities.WhatsAppStatusPost, int, error)
+	GetStatusViewers(ctx context.Context, id uint) ([]entities.WhatsAppStatusView, error)
+	GetStatusFeed(ctx context.Context, sender string, page int) ([]dtos.StatusFeedItemDTO, int, error)
+	ForwardMessage(ctx context.Context, req dtos.ForwardMessageDTO) ([]dtos.ForwardResultDTO, error)
+	UpdateChatState(ctx context.Context, req dtos.ChatStateDTO) (*entities.WhatsAppChat, error)
+	StarMessage(ctx context.Context, req dtos.StarMessageDTO) error
+	ListLabels(ctx context.Context) ([]entities.WhatsAppLabel, error)
+	CreateLabel(ctx context.Context, req dtos.LabelDTO) (*entities.WhatsAppLabel, error)
+	UpdateLabel(ctx context.Context, labelID string, req dtos.LabelDTO) (*entities.WhatsAppLabel, error)
+	DeleteLabel(ctx context.Context, labelID string) error
+	AssignLabel(ctx context.Context, labelID string, req dtos.LabelAssignDTO) error
+	ListChats(ctx context.Context, req dtos.ChatListDTO) ([]dtos.ChatDTO, int, error)
+	ListHistorySyncs(ctx context.Context, page int) ([]entities.WhatsAppHistorySync, int, error)
+	SearchMessages(ctx context.Context, req dtos.MessageSearchDTO) ([]dtos.MessageSearchResultDTO, int, error)
+	ExportChat(ctx context.Context, req dtos.ExportDTO) (*dtos.ExportFileDTO, io.ReadCloser, error)
+	ImportChat(ctx context.Context, req dtos.ImportDTO) (*dtos.ImportResultDTO, error)
+	CreateWebhook(ctx context.Context, req dtos.WebhookDTO) (*entities.WhatsAppWebhook, error)
+	ListWebhooks(ctx context.Context) ([]entities.WhatsAppWebhook, error)
+	UpdateWebhook(ctx context.Context, id uint, req dtos.WebhookDTO) (*entities.WhatsAppWebhook, error)
+	DeleteWebhook(ctx context.Context, id uint) error
+	ListWebhookDeliveries(ctx context.Context, id uint, status string, page int) ([]entities.WhatsAppWebhookDelivery, int, error)
+	RedeliverWebhook(ctx context.Context, deliveryID uint) (*entities.WhatsAppWebhookDelivery, error)
+	SubscribeEvents(ctx context.Context, lastEventID uint64) (*EventSubscription, error)
 }
 
 // UserSession represents a WhatsApp session for a specific user
@@ -43,23 +106,94 @@ type UserSession struct {
 	UserID      uint
 	Client      *whatsmeow.Client
 	DB          *sqlstore.Container
-	EventChan   chan *events.Message
+	Inbound     *inboundQueue            // Received messages waiting for the inbound pipeline
+	OutboxWake  chan struct{}            // Signals the outbox worker that new messages are queued
+	HistorySync chan *events.HistorySync // History sync chunks waiting to be imported, in order
 	IsConnected bool
 	Ctx         context.Context
 	Cancel      context.CancelFunc
 }
 
 type service struct {
-	sessions map[uint]*UserSession // Map of user ID to their WhatsApp session
-	mutex    sync.RWMutex          // Mutex to protect concurrent access to sessions
+	sessions  map[uint]*UserSession // Map of user ID to their WhatsApp session
+	mutex     sync.RWMutex          // Mutex to protect concurrent access to sessions
+	media     mediaLimits           // Per-type upload caps
+	fetcher   *http.Client          // SSRF-guarded client for media_url downloads
+	store     storage.BlobStore     // Where downloaded inbound media is kept
+	retention retentionPolicy       // Purging of disappearing messages
+
+	broadcast        broadcastSettings                // Pacing defaults for bulk sends
+	broadcastCancels map[uint]context.CancelCauseFunc // Running broadcast jobs by ID
+	broadcastMutex   sync.Mutex
+
+	schedulerInterval time.Duration // How often due scheduled messages are polled
+	outbox            outboxSettings
+	idempotencyTTL    time.Duration // Replay window of Idempotency-Key responses
+	pacer             *pacer
+	pacingDefaults    entities.WhatsAppPacing // Used by accounts without their own settings
+
+	statusAudienceDefault string // Expected status privacy when a post names none
+	history               historySettings
+	searchLanguage        string // Text search configuration new messages are indexed with
+
+	webhooks      webhookSettings
+	webhookClient *http.Client  // SSRF-guarded client that does not follow redirects
+	webhookWake   chan struct{} // Signals the webhook worker that new deliveries are queued
+
+	streams          map[uint]*eventStream // Real-time event streams by user ID
+	streamsMutex     sync.Mutex
+	streamReplaySize int
+
+	inbound         []inboundHandler // Pipeline every received message goes through, in order
+	inboundSettings inboundSettings
 }
 
-func NewService() Service {
+// NewService builds the WhatsApp service; options register additional inbound
+// handlers such as auto-reply rules or bots
+func NewService(cfg config.WhatsApp, store storage.BlobStore, opts ...Option) Service {
 	s := &service{
-		sessions: make(map[uint]*UserSession),
-		mutex:    sync.RWMutex{},
+		sessions:  make(map[uint]*UserSession),
+		mutex:     sync.RWMutex{},
+		media:     newMediaLimits(cfg.Media),
+		fetcher:   newFetchClient(cfg.Media),
+		store:     store,
+		retention: newRetentionPolicy(cfg.Retention),
+
+		broadcast:        newBroadcastSettings(cfg.Broadcast),
+		broadcastCancels: make(map[uint]context.CancelCauseFunc),
+
+		schedulerInterval: schedulerInterval(cfg.Scheduler),
+		outbox:            newOutboxSettings(cfg.Outbox),
+		idempotencyTTL:    idempotencyTTL(cfg),
+		pacer:             newPacer(),
+		pacingDefaults:    defaultPacing(cfg.Pacing),
+
+		statusAudienceDefault: cfg.Status.Audience,
+		history:               newHistorySettings(cfg.History),
+		searchLanguage:        defaultSearchLanguage(cfg.Search),
+
+		webhooks:    newWebhookSettings(cfg.Webhooks),
+		webhookWake: make(chan struct{}, 1),
+
+		streams:          make(map[uint]*eventStream),
+		streamReplaySize: streamReplaySize(cfg.Stream),
+
+		inboundSettings: newInboundSettings(cfg.Inbound),
+	}
+	s.webhookClient = newWebhookClient(cfg.Webhooks, s.webhooks.timeout)
+	s.inbound = s.builtinInboundHandlers()
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := prometheus.Register(inboundQueueCollector{s}); err != nil {
+		log.Printf("Failed to register inbound queue metrics: %v", err)
 	}
 
+	go s.purgeExpiredMessages()
+	go s.runScheduler()
+	go s.purgeIdempotencyKeys()
+	go s.runWebhooks()
+
 	return s
 }
 
@@ -76,10 +210,13 @@ func (s *service) getUserSession(userID uint) (*UserSession, error) {
 	// Create new session for this user
 	ctx, cancel := context.WithCancel(context.Background())
 	session := &UserSession{
-		UserID:    userID,
-		EventChan: make(chan *events.Message, 100),
-		Ctx:       ctx,
-		Cancel:    cancel,
+		UserID:     userID,
+		Inbound:    s.newInboundQueue(userID),
+		OutboxWake: make(chan struct{}, 1),
+		Ctx:        ctx,
+		Cancel:     cancel,
+
+		HistorySync: make(chan *events.HistorySync, historySyncBacklog),
 	}
 
 	// Initialize the session
@@ -90,6 +227,8 @@ func (s *service) getUserSession(userID uint) (*UserSession, error) {
 
 	// Start event processor for this user
 	go s.eventProcessor(session)
+	go s.runOutbox(session)
+	go s.runHistorySync(session)
 
 	// Store the session
 	s.sessions[userID] = session
@@ -108,11 +247,6 @@ func (s *service) removeUserSession(userID uint) {
 			session.Cancel()
 		}
 
-		// Close event channel
-		if session.EventChan != nil {
-			close(session.EventChan)
-		}
-
 		// Disconnect client
 		if session.Client != nil {
 			session.Client.Disconnect()
@@ -129,7 +263,7 @@ func (s *service) removeUserSession(userID uint) {
 	}
 }
 
-// getUserIDFromContext extracts user ID from Gin context
+// getUserIDFromContext extracts user ID from Gin context, or from a background context set with state.SetCurrentUser
 func (s *service) getUserIDFromContext(ctx context.Context) (uint, error) {
 	if ginCtx, ok := ctx.(*gin.Context); ok {
 		userID, exists := ginCtx.Get(state.CurrentUserId)
@@ -142,37 +276,10 @@ func (s *service) getUserIDFromContext(ctx context.Context) (uint, error) {
 		}
 		return 0, fmt.Errorf("invalid user ID type in context")
 	}
-	return 0, fmt.Errorf("invalid context type")
-}
-
-// eventProcessor processes incoming WhatsApp events in background for a specific user
-func (s *service) eventProcessor(session *UserSession) {
-	for {
-		select {
-		case event := <-session.EventChan:
-			// Process incoming message - MessageInfo struct'ını doğru kullanım
-			sender := event.Info.SourceString() // Better source identification
-			var messageText string
-
-			// Safe message content extraction
-			if event.Message != nil && event.Message.GetConversation() != "" {
-				messageText = event.Message.GetConversation()
-			} else if event.Message != nil && event.Message.GetExtendedTextMessage() != nil {
-				messageText = event.Message.GetExtendedTextMessage().GetText()
-			} else {
-				messageText = "[Media or unsupported message type]"
-			}
-
-			log.Printf("📱 WhatsApp Message [User %d] - From: %s | Content: %s | Timestamp: %v",
-				session.UserID, sender, messageText, event.Info.Timestamp)
-
-			// Here you can add your custom message processing logic
-			// For example: save to database, trigger webhooks, auto-reply, etc.
-		case <-session.Ctx.Done():
-			log.Printf("Event processor stopped for user %d", session.UserID)
-			return
-		}
+	if uid := state.CurrentUser(ctx); uid != 0 {
+		return uid, nil
 	}
+	return 0, fmt.Errorf("invalid context type")
 }
 
 // formatPhoneNumber converts phone number to WhatsApp JID format using proper whatsmeow functions
@@ -258,6 +365,25 @@ func (s *service) SendMessage(ctx context.Context, req dtos.SendMessageDTO) (*dt
 		return nil, fmt.Errorf("authentication required: %v", err)
 	}
 
+	ctx = withTransactional(ctx, req.Transactional)
+
+	// Templates are rendered up front so scheduled and queued sends store the final text
+	if req.TemplateID != 0 {
+		if req.Message, err = s.renderTemplate(ctx, userID, req.TemplateOptionsDTO); err != nil {
+			return nil, err
+		}
+	}
+
+	// Deferred sends are stored and fired later by the scheduler
+	if isScheduled(req.ScheduleDTO) {
+		return s.scheduleMessage(ctx, userID, req)
+	}
+
+	// Async sends are queued in the outbox and delivered by the session's worker
+	if req.Async {
+		return s.enqueueMessage(ctx, userID, req)
+	}
+
 	// Get user session
 	s.mutex.RLock()
 	session, exists := s.sessions[userID]
@@ -287,12 +413,10 @@ func (s *service) SendMessage(ctx context.Context, req dtos.SendMessageDTO) (*dt
 	}
 
 	// Create message
-	msg := &waProto.Message{
-		Conversation: proto.String(req.Message),
-	}
+	msg := textMessage(req.Message)
 
 	// Send message
-	resp, err := session.Client.SendMessage(ctx, recipient, msg)
+	resp, err := s.sendTo(ctx, session, recipient, msg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send message: %v", err)
 	}
@@ -316,6 +440,25 @@ func (s *service) SendMediaMessage(ctx context.Context, req dtos.SendMediaMessag
 		return nil, fmt.Errorf("authentication required: %v", err)
 	}
 
+	ctx = withTransactional(ctx, req.Transactional)
+
+	// Templates render the caption
+	if req.TemplateID != 0 {
+		if req.Caption, err = s.renderTemplate(ctx, userID, req.TemplateOptionsDTO); err != nil {
+			return nil, err
+		}
+	}
+
+	// Deferred sends are stored and fired later by the scheduler
+	if isScheduled(req.ScheduleDTO) {
+		return s.scheduleMediaMessage(ctx, userID, req)
+	}
+
+	// Async sends are queued in the outbox and delivered by the session's worker
+	if req.Async {
+		return s.enqueueMediaMessage(ctx, userID, req)
+	}
+
 	// Get user session
 	s.mutex.RLock()
 	session, exists := s.sessions[userID]
@@ -344,86 +487,34 @@ func (s *service) SendMediaMessage(ctx context.Context, req dtos.SendMediaMessag
 		return nil, fmt.Errorf(constant.INVALID_PHONE_NUMBER+": %v", err)
 	}
 
+	// Library entries are resolved first since they carry their own MIME type
+	var uploaded whatsmeow.UploadResponse
+	if req.MediaID != 0 {
+		uploaded, err = s.libraryUpload(ctx, session, &req)
+	}
+
 	// Determine media type based on MIME type
-	var mediaType whatsmeow.MediaType
-	switch {
-	case strings.HasPrefix(req.MimeType, "image/"):
-		mediaType = whatsmeow.MediaImage
-	case strings.HasPrefix(req.MimeType, "video/"):
-		mediaType = whatsmeow.MediaVideo
-	case strings.HasPrefix(req.MimeType, "audio/"):
-		mediaType = whatsmeow.MediaAudio
-	default:
-		mediaType = whatsmeow.MediaDocument
-	}
-
-	// Upload media
-	uploaded, err := session.Client.Upload(ctx, req.MediaData, mediaType)
+	mediaType := mediaTypeFor(req.MimeType)
+	if req.ViewOnce && mediaType == whatsmeow.MediaDocument {
+		return nil, ErrViewOnceNotSupported
+	}
+
+	// Upload media unless the library already holds a live CDN entry
+	if req.MediaID == 0 {
+		uploaded, err = s.uploadMedia(ctx, session, req, mediaType)
+	}
+	if errors.Is(err, ErrMediaTooLarge) || errors.Is(err, ErrMediaNotFound) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf(constant.MEDIA_UPLOAD_FAILED+": %v", err)
 	}
 
-	var msg *waProto.Message
-
 	// Create appropriate message based on media type
-	switch mediaType {
-	case whatsmeow.MediaImage:
-		msg = &waProto.Message{
-			ImageMessage: &waProto.ImageMessage{
-				URL:           &uploaded.URL,
-				Mimetype:      &req.MimeType,
-				Caption:       &req.Caption,
-				FileSHA256:    uploaded.FileSHA256,
-				FileLength:    &uploaded.FileLength,
-				Height:        &req.Height,
-				Width:         &req.Width,
-				DirectPath:    &uploaded.DirectPath,
-				MediaKey:      uploaded.MediaKey,
-				FileEncSHA256: uploaded.FileEncSHA256,
-			},
-		}
-	case whatsmeow.MediaVideo:
-		msg = &waProto.Message{
-			VideoMessage: &waProto.VideoMessage{
-				URL:           &uploaded.URL,
-				Mimetype:      &req.MimeType,
-				Caption:       &req.Caption,
-				FileSHA256:    uploaded.FileSHA256,
-				FileLength:    &uploaded.FileLength,
-				DirectPath:    &uploaded.DirectPath,
-				MediaKey:      uploaded.MediaKey,
-				FileEncSHA256: uploaded.FileEncSHA256,
-			},
-		}
-	case whatsmeow.MediaAudio:
-		msg = &waProto.Message{
-			AudioMessage: &waProto.AudioMessage{
-				URL:           &uploaded.URL,
-				Mimetype:      &req.MimeType,
-				FileSHA256:    uploaded.FileSHA256,
-				FileLength:    &uploaded.FileLength,
-				DirectPath:    &uploaded.DirectPath,
-				MediaKey:      uploaded.MediaKey,
-				FileEncSHA256: uploaded.FileEncSHA256,
-			},
-		}
-	default: // Document
-		msg = &waProto.Message{
-			DocumentMessage: &waProto.DocumentMessage{
-				URL:           &uploaded.URL,
-				Mimetype:      &req.MimeType,
-				Title:         &req.Caption,
-				FileSHA256:    uploaded.FileSHA256,
-				FileLength:    &uploaded.FileLength,
-				DirectPath:    &uploaded.DirectPath,
-				MediaKey:      uploaded.MediaKey,
-				FileEncSHA256: uploaded.FileEncSHA256,
-			},
-		}
-	}
+	msg := buildMediaMessage(mediaType, uploaded, req)
 
 	// Send message
-	resp, err := session.Client.SendMessage(ctx, recipient, msg)
+	resp, err := s.sendTo(ctx, session, recipient, msg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send media message: %v", err)
 	}
@@ -710,10 +801,68 @@ func (s *service) handleEvents(session *UserSession, evt interface{}) {
 	switch v := evt.(type) {
 	case *events.Message:
 		// Handle incoming messages
-		session.EventChan <- v
+		s.enqueueInbound(session, v)
 	case *events.Receipt:
 		// Handle message receipts
 		// You can implement delivery status tracking here
 		log.Printf("Message receipt for user %d: %v", session.UserID, v)
+		s.notify(session.UserID, constant.EVENT_RECEIPT, receiptPayload(v))
+
+		// Views of the account's own status posts
+		if v.Chat == types.StatusBroadcastJID && !v.IsFromMe &&
+			(v.Type == types.ReceiptTypeRead || v.Type == types.ReceiptTypePlayed) {
+			go s.recordStatusViews(session, v)
+		}
+	case *events.Presence:
+		s.publishEvent(session.UserID, constant.EVENT_PRESENCE, presencePayload(v))
+	case *events.ChatPresence:
+		s.publishEvent(session.UserID, constant.EVENT_PRESENCE, chatPresencePayload(v))
+	case *events.HistorySync:
+		// Past conversations sent by the phone after pairing; imported off the dispatch goroutine
+		s.enqueueHistorySync(session, v)
+	case *events.MediaRetry:
+		// Finish downloads of expired attachments off the dispatch goroutine
+		go s.handleMediaRetry(session, v)
+	case *events.Archive, *events.Pin, *events.Mute, *events.Star,
+		*events.LabelEdit, *events.LabelAssociationChat, *events.LabelAssociationMessage:
+		// Chat organisation changed on the phone or another device
+		s.handleAppState(session, v)
+	case *events.GroupInfo:
+		// Keep group subjects of the chat list current
+		if v.Name != nil {
+			s.handleGroupName(session, v.JID, v.Name.Name)
+		}
+		if v.Ephemeral != nil {
+			saveDisappearingTimer(session.UserID, v.JID, groupDisappearingTimer(*v.Ephemeral))
+		}
+		s.notify(session.UserID, constant.EVENT_GROUP, groupInfoPayload(v))
+	case *events.JoinedGroup:
+		s.handleGroupName(session, v.JID, v.Name)
+		saveDisappearingTimer(session.UserID, v.JID, groupDisappearingTimer(v.GroupEphemeral))
+		s.notify(session.UserID, constant.EVENT_GROUP, map[string]interface{}{
+			"action":    "joined",
+			"group_jid": v.JID.String(),
+			"name":      v.Name,
+		})
+	case *events.Connected:
+		// Remembered so imports can tell the account's own messages apart while offline
+		if session.Client.Store.ID != nil {
+			saveDeviceJID(session.UserID, *session.Client.Store.ID)
+		}
+		// Deliver whatever was queued while the websocket was down
+		select {
+		case session.OutboxWake <- struct{}{}:
+		default:
+		}
+		go s.syncGroupNames(session)
+		go s.resumeBroadcasts(session)
+		s.notify(session.UserID, constant.EVENT_CONNECTION, map[string]interface{}{"state": "connected"})
+	case *events.Disconnected:
+		s.notify(session.UserID, constant.EVENT_CONNECTION, map[string]interface{}{"state": "disconnected"})
+	case *events.LoggedOut:
+		s.notify(session.UserID, constant.EVENT_CONNECTION, map[string]interface{}{
+			"state":  "logged_out",
+			"reason": v.Reason.String(),
+		})
 	}
 }
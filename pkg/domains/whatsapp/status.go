@@ -0,0 +1,361 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"github.com/crm/pkg/utils"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+// statusLifetime is how long WhatsApp shows a Status update
+const statusLifetime = 24 * time.Hour
+
+var (
+	// ErrStatusPostNotFound is returned when a status post does not exist or belongs to another user
+	ErrStatusPostNotFound = errors.New(constant.STATUS_POST_NOT_FOUND)
+	// ErrInvalidStatus is returned for status posts WhatsApp cannot show, e.g. documents
+	ErrInvalidStatus = errors.New(constant.INVALID_STATUS_POST)
+	// ErrStatusAudienceMismatch is returned when the phone's status privacy differs from the requested audience
+	ErrStatusAudienceMismatch = errors.New(constant.STATUS_AUDIENCE_MISMATCH)
+)
+
+// parseStatusColor converts "#RRGGBB" or "#AARRGGBB" into the ARGB value WhatsApp expects
+func parseStatusColor(color string) (uint32, error) {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) == 6 {
+		hex = "FF" + hex
+	}
+	if len(hex) != 8 {
+		return 0, fmt.Errorf("%w: background_color must be #RRGGBB or #AARRGGBB", ErrInvalidStatus)
+	}
+	argb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: background_color must be #RRGGBB or #AARRGGBB", ErrInvalidStatus)
+	}
+	return uint32(argb), nil
+}
+
+// statusPrivacy returns the account's default status privacy, which decides who receives its posts
+func statusPrivacy(session *UserSession) (types.StatusPrivacy, error) {
+	privacy, err := session.Client.GetStatusPrivacy()
+	if err != nil {
+		return types.StatusPrivacy{}, fmt.Errorf("failed to get status privacy: %v", err)
+	}
+	if len(privacy) == 0 {
+		return whatsmeow.DefaultStatusPrivacy[0], nil
+	}
+	return privacy[0], nil
+}
+
+// statusAudience refuses to post when the phone's status privacy differs from the requested audience.
+// whatsmeow always fans statuses out according to that setting, so it cannot be overridden per post.
+func (s *service) statusAudience(session *UserSession, requested string) (string, error) {
+	privacy, err := statusPrivacy(session)
+	if err != nil {
+		return "", err
+	}
+
+	if requested == "" {
+		requested = s.statusAudienceDefault
+	}
+	if requested != "" && string(privacy.Type) != requested {
+		return "", fmt.Errorf("%w: phone is set to %s, requested %s", ErrStatusAudienceMismatch, privacy.Type, requested)
+	}
+	return string(privacy.Type), nil
+}
+
+// postStatus sends msg to status@broadcast and records the post
+func (s *service) postStatus(ctx context.Context, session *UserSession, msg *waProto.Message, post *entities.WhatsAppStatusPost) error {
+	resp, err := s.sendTo(ctx, session, types.StatusBroadcastJID, msg)
+	if err != nil {
+		return fmt.Errorf("failed to post status: %v", err)
+	}
+
+	post.UserID = session.UserID
+	post.MessageID = resp.ID
+	post.PostedAt = resp.Timestamp
+	post.ExpiresAt = resp.Timestamp.Add(statusLifetime)
+	if err := database.DBClient().WithContext(ctx).Create(post).Error; err != nil {
+		return fmt.Errorf("failed to save status post: %v", err)
+	}
+
+	log.Printf("Status %s posted by user %d to %s audience", resp.ID, session.UserID, post.Audience)
+	return nil
+}
+
+func (s *service) PostTextStatus(ctx context.Context, req dtos.StatusTextDTO) (*entities.WhatsAppStatusPost, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	text := &waProto.ExtendedTextMessage{
+		Text:     proto.String(req.Text),
+		TextArgb: proto.Uint32(0xFFFFFFFF),
+	}
+	if req.BackgroundColor != "" {
+		argb, err := parseStatusColor(req.BackgroundColor)
+		if err != nil {
+			return nil, err
+		}
+		text.BackgroundArgb = proto.Uint32(argb)
+	}
+	if _, ok := waE2E.ExtendedTextMessage_FontType_name[int32(req.Font)]; !ok {
+		return nil, fmt.Errorf("%w: unknown font %d", ErrInvalidStatus, req.Font)
+	}
+	text.Font = waProto.ExtendedTextMessage_FontType(req.Font).Enum()
+
+	audience, err := s.statusAudience(session, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+
+	post := &entities.WhatsAppStatusPost{
+		Kind:            "text",
+		Content:         req.Text,
+		BackgroundColor: req.BackgroundColor,
+		Font:            req.Font,
+		Audience:        audience,
+	}
+	if err := s.postStatus(ctx, session, &waProto.Message{ExtendedTextMessage: text}, post); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// PostMediaStatus posts an image, video or audio status; uploads are kept in the media library for reposting
+func (s *service) PostMediaStatus(ctx context.Context, req dtos.StatusMediaDTO) (*entities.WhatsAppStatusPost, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.MediaID == 0 && mediaTypeFor(req.MimeType) == whatsmeow.MediaDocument {
+		return nil, fmt.Errorf("%w: documents cannot be posted as status", ErrInvalidStatus)
+	}
+
+	audience, err := s.statusAudience(session, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+
+	media := dtos.SendMediaMessageDTO{
+		Caption:  req.Caption,
+		Media:    req.Media,
+		MediaID:  req.MediaID,
+		FileName: req.FileName,
+		FileSize: req.FileSize,
+		MimeType: req.MimeType,
+	}
+	// Posts keep referring to their media, so uploads become library entries
+	if media.MediaID == 0 {
+		stored, err := s.UploadLibraryMedia(ctx, dtos.LibraryUploadDTO{
+			Media:    req.Media,
+			FileName: req.FileName,
+			FileSize: req.FileSize,
+			MimeType: req.MimeType,
+		})
+		if err != nil {
+			return nil, err
+		}
+		media.MediaID = stored.ID
+		media.Media = nil
+	}
+
+	uploaded, err := s.libraryUpload(ctx, session, &media)
+	if errors.Is(err, ErrMediaNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf(constant.MEDIA_UPLOAD_FAILED+": %v", err)
+	}
+
+	mediaType := mediaTypeFor(media.MimeType)
+	if mediaType == whatsmeow.MediaDocument {
+		return nil, fmt.Errorf("%w: documents cannot be posted as status", ErrInvalidStatus)
+	}
+
+	msg := buildMediaMessage(mediaType, uploaded, media)
+	_, kind := messageContent(msg)
+	post := &entities.WhatsAppStatusPost{
+		Kind:     kind,
+		Content:  req.Caption,
+		MediaID:  media.MediaID,
+		Audience: audience,
+	}
+	if err := s.postStatus(ctx, session, msg, post); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func (s *service) GetStatusAudience(ctx context.Context) (*dtos.StatusAudienceDTO, error) {
+	session, err := s.activeSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	privacy, err := statusPrivacy(session)
+	if err != nil {
+		return nil, err
+	}
+
+	audience := &dtos.StatusAudienceDTO{Type: string(privacy.Type), List: []string{}}
+	for _, jid := range privacy.List {
+		audience.List = append(audience.List, jid.String())
+	}
+	return audience, nil
+}
+
+// ListStatusPosts returns one page of the account's own posts, newest first, with their view counts
+func (s *service) ListStatusPosts(ctx context.Context, page int) ([]entities.WhatsAppStatusPost, int, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("authentication required: %v", err)
+	}
+
+	var posts []entities.WhatsAppStatusPost
+	totalPages, err := utils.Pagination(&posts, page, database.DBClient().Order("id desc"), ctx, "user_id = ?", userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := make([]uint, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+
+	var counts []struct {
+		StatusPostID uint
+		Views        int
+	}
+	err = database.DBClient().WithContext(ctx).Model(&entities.WhatsAppStatusView{}).
+		Select("status_post_id, COUNT(*) AS views").
+		Where("status_post_id IN ?", ids).
+		Group("status_post_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count status views: %v", err)
+	}
+
+	views := make(map[uint]int, len(counts))
+	for _, count := range counts {
+		views[count.StatusPostID] = count.Views
+	}
+	for i := range posts {
+		posts[i].ViewCount = views[posts[i].ID]
+	}
+	return posts, totalPages, nil
+}
+
+func (s *service) GetStatusViewers(ctx context.Context, id uint) ([]entities.WhatsAppStatusView, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	var post entities.WhatsAppStatusPost
+	err = database.DBClient().WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&post).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrStatusPostNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status post: %v", err)
+	}
+
+	var viewers []entities.WhatsAppStatusView
+	err = database.DBClient().WithContext(ctx).Where("status_post_id = ?", post.ID).Order("viewed_at").Find(&viewers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status viewers: %v", err)
+	}
+	return viewers, nil
+}
+
+// GetStatusFeed returns one page of the status updates contacts posted, newest first
+func (s *service) GetStatusFeed(ctx context.Context, sender string, page int) ([]dtos.StatusFeedItemDTO, int, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("authentication required: %v", err)
+	}
+
+	query := "user_id = ? AND chat_jid = ? AND is_incoming = ?"
+	args := []interface{}{userID, types.StatusBroadcastJID.String(), true}
+	if sender != "" {
+		query += " AND from_jid = ?"
+		args = append(args, sender)
+	}
+
+	var messages []entities.WhatsAppMessage
+	totalPages, err := utils.Pagination(&messages, page, database.DBClient().Order("timestamp desc"), ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		records = append(records, message.ID)
+	}
+	var media []entities.WhatsAppMessageMedia
+	if err := database.DBClient().WithContext(ctx).Where("message_record_id IN ?", records).Find(&media).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get status media: %v", err)
+	}
+	mediaIDs := make(map[uint]uint, len(media))
+	for _, m := range media {
+		mediaIDs[m.MessageRecordID] = m.ID
+	}
+
+	feed := make([]dtos.StatusFeedItemDTO, 0, len(messages))
+	for _, message := range messages {
+		feed = append(feed, dtos.StatusFeedItemDTO{
+			ID:        message.ID,
+			MessageID: message.MessageID,
+			SenderJID: message.FromJID,
+			Kind:      message.MessageType,
+			Content:   message.Content,
+			MediaID:   mediaIDs[message.ID],
+			PostedAt:  message.Timestamp.Format(time.RFC3339),
+			ExpiresAt: message.Timestamp.Add(statusLifetime).Format(time.RFC3339),
+		})
+	}
+	return feed, totalPages, nil
+}
+
+// recordStatusViews stores who viewed the account's status posts from their read receipts
+func (s *service) recordStatusViews(session *UserSession, receipt *events.Receipt) {
+	db := database.DBClient()
+
+	var posts []entities.WhatsAppStatusPost
+	if err := db.Where("user_id = ? AND message_id IN ?", session.UserID, receipt.MessageIDs).Find(&posts).Error; err != nil {
+		log.Printf("Failed to look up status posts for user %d: %v", session.UserID, err)
+		return
+	}
+
+	viewer := receipt.Sender.ToNonAD().String()
+	for _, post := range posts {
+		view := entities.WhatsAppStatusView{
+			StatusPostID: post.ID,
+			ViewerJID:    viewer,
+			ViewedAt:     receipt.Timestamp,
+		}
+		// A viewer may send several receipts for one post; the first one counts
+		if err := db.Where("status_post_id = ? AND viewer_jid = ?", post.ID, viewer).FirstOrCreate(&view).Error; err != nil {
+			log.Printf("Failed to record status view for user %d: %v", session.UserID, err)
+		}
+	}
+}
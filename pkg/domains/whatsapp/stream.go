@@ -0,0 +1,181 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/dtos"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// streamSubscriberBuffer is how many events a subscriber may lag behind before it is dropped.
+// Dropped clients reconnect with Last-Event-ID and catch up from the replay buffer.
+const streamSubscriberBuffer = 64
+
+func streamReplaySize(sc config.Stream) int {
+	if sc.ReplaySize <= 0 {
+		return 500
+	}
+	return sc.ReplaySize
+}
+
+// eventStream fans a user's events out to connected clients and keeps the newest ones for resume
+type eventStream struct {
+	mutex       sync.Mutex
+	lastID      uint64
+	replay      []dtos.StreamEventDTO // Oldest first, at most replaySize long
+	replaySize  int
+	subscribers map[chan dtos.StreamEventDTO]struct{}
+}
+
+// EventSubscription is one client's view of the stream. Events is closed when the
+// client falls too far behind, after which it should reconnect with its last event ID.
+type EventSubscription struct {
+	Replay []dtos.StreamEventDTO // Buffered events after the requested Last-Event-ID
+	Events <-chan dtos.StreamEventDTO
+
+	close func()
+}
+
+// Close stops delivery to the subscription
+func (sub *EventSubscription) Close() {
+	sub.close()
+}
+
+// streamFor returns the user's event stream, creating it on first use. Streams outlive
+// sessions so clients can resume across reconnects of the WhatsApp connection.
+func (s *service) streamFor(userID uint) *eventStream {
+	s.streamsMutex.Lock()
+	defer s.streamsMutex.Unlock()
+
+	stream, exists := s.streams[userID]
+	if !exists {
+		stream = &eventStream{
+			// IDs start at the current time so they keep increasing across restarts
+			lastID:      uint64(time.Now().UnixMicro()),
+			replaySize:  s.streamReplaySize,
+			subscribers: make(map[chan dtos.StreamEventDTO]struct{}),
+		}
+		s.streams[userID] = stream
+	}
+	return stream
+}
+
+// publishEvent sends an event to the user's connected stream clients
+func (s *service) publishEvent(userID uint, eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode %s stream event of user %d: %v", eventType, userID, err)
+		return
+	}
+
+	stream := s.streamFor(userID)
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	stream.lastID++
+	event := dtos.StreamEventDTO{
+		ID:        stream.lastID,
+		Type:      eventType,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Data:      payload,
+	}
+	if len(stream.replay) >= stream.replaySize {
+		stream.replay = stream.replay[1:]
+	}
+	stream.replay = append(stream.replay, event)
+
+	for ch := range stream.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(stream.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify publishes an event to the user's stream clients and webhooks
+func (s *service) notify(userID uint, eventType string, data interface{}) {
+	s.publishEvent(userID, eventType, data)
+	s.emitWebhook(userID, eventType, data)
+}
+
+// SubscribeEvents attaches a client to the caller's event stream, replaying buffered
+// events newer than lastEventID. Zero starts with live events only. When events after
+// lastEventID are no longer buffered the replay starts with a resync event.
+func (s *service) SubscribeEvents(ctx context.Context, lastEventID uint64) (*EventSubscription, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	stream := s.streamFor(userID)
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	ch := make(chan dtos.StreamEventDTO, streamSubscriberBuffer)
+	stream.subscribers[ch] = struct{}{}
+	sub := &EventSubscription{
+		Events: ch,
+		close: func() {
+			stream.mutex.Lock()
+			defer stream.mutex.Unlock()
+			if _, ok := stream.subscribers[ch]; ok {
+				delete(stream.subscribers, ch)
+				close(ch)
+			}
+		},
+	}
+
+	if lastEventID == 0 {
+		return sub, nil
+	}
+
+	// Events older than the buffer are gone, and so is everything from before a restart.
+	// An ID ahead of the stream comes from before a restart with a skewed clock.
+	oldest := stream.lastID + 1
+	if len(stream.replay) > 0 {
+		oldest = stream.replay[0].ID
+	}
+	if lastEventID+1 < oldest || lastEventID > stream.lastID {
+		sub.Replay = append(sub.Replay, dtos.StreamEventDTO{
+			Type:      constant.EVENT_RESYNC,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		})
+	}
+	for _, event := range stream.replay {
+		if event.ID > lastEventID {
+			sub.Replay = append(sub.Replay, event)
+		}
+	}
+	return sub, nil
+}
+
+// presencePayload is the data of a contact's online status event
+func presencePayload(evt *events.Presence) map[string]interface{} {
+	data := map[string]interface{}{
+		"jid":       evt.From.String(),
+		"available": !evt.Unavailable,
+	}
+	if !evt.LastSeen.IsZero() {
+		data["last_seen"] = evt.LastSeen.Format(time.RFC3339)
+	}
+	return data
+}
+
+// chatPresencePayload is the data of a typing or recording indicator event
+func chatPresencePayload(evt *events.ChatPresence) map[string]interface{} {
+	return map[string]interface{}{
+		"chat_jid":   evt.Chat.String(),
+		"sender_jid": evt.Sender.String(),
+		"state":      string(evt.State),
+		"media":      string(evt.Media),
+	}
+}
@@ -0,0 +1,322 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"gorm.io/gorm"
+)
+
+// ErrTemplateNotFound is returned when a template does not exist for the user
+var ErrTemplateNotFound = errors.New(constant.TEMPLATE_NOT_FOUND)
+
+// ErrInvalidTemplate is returned for templates that fail validation or cannot be rendered
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// parseTemplateBody parses body and checks that it only references declared variables
+func parseTemplateBody(name, body string, declared map[string]bool) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	used := map[string]bool{}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			collectFields(t.Tree.Root, used)
+		}
+	}
+
+	var undeclared []string
+	for field := range used {
+		if !declared[field] {
+			undeclared = append(undeclared, field)
+		}
+	}
+	if len(undeclared) > 0 {
+		sort.Strings(undeclared)
+		return nil, fmt.Errorf("%w: undeclared variables %s", ErrInvalidTemplate, strings.Join(undeclared, ", "))
+	}
+	return tmpl, nil
+}
+
+// collectFields records the top-level fields ({{.name}}) referenced anywhere below node
+func collectFields(node parse.Node, used map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectFields(child, used)
+		}
+	case *parse.ActionNode:
+		collectFields(n.Pipe, used)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectFields(cmd, used)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectFields(arg, used)
+		}
+	case *parse.FieldNode:
+		used[n.Ident[0]] = true
+	case *parse.VariableNode:
+		// $ is the root data, so {{$.name}} refers to a variable too
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			used[n.Ident[1]] = true
+		}
+	case *parse.ChainNode:
+		collectFields(n.Node, used)
+	case *parse.IfNode:
+		collectBranch(&n.BranchNode, used)
+	case *parse.RangeNode:
+		collectBranch(&n.BranchNode, used)
+	case *parse.WithNode:
+		collectBranch(&n.BranchNode, used)
+	case *parse.TemplateNode:
+		collectFields(n.Pipe, used)
+	}
+}
+
+func collectBranch(n *parse.BranchNode, used map[string]bool) {
+	collectFields(n.Pipe, used)
+	collectFields(n.List, used)
+	collectFields(n.ElseList, used)
+}
+
+// validateTemplate checks the default body and every variant against the declared variables
+func validateTemplate(req dtos.TemplateDTO) error {
+	declared := make(map[string]bool, len(req.Variables))
+	for _, name := range req.Variables {
+		if name == "" {
+			return fmt.Errorf("%w: variable names must not be empty", ErrInvalidTemplate)
+		}
+		declared[name] = true
+	}
+
+	if _, err := parseTemplateBody(req.Name, req.Body, declared); err != nil {
+		return err
+	}
+
+	languages := map[string]bool{}
+	for _, variant := range req.Variants {
+		language := strings.ToLower(variant.Language)
+		if languages[language] {
+			return fmt.Errorf("%w: duplicate variant for language %s", ErrInvalidTemplate, variant.Language)
+		}
+		languages[language] = true
+
+		if _, err := parseTemplateBody(req.Name, variant.Body, declared); err != nil {
+			return fmt.Errorf("%w (variant %s)", err, variant.Language)
+		}
+	}
+	return nil
+}
+
+// pickTemplateBody returns the variant for language, falling back to its base language and then the default body
+func pickTemplateBody(tmpl *entities.WhatsAppTemplate, language string) string {
+	if language == "" {
+		return tmpl.Body
+	}
+
+	base, _, _ := strings.Cut(language, "-")
+	var baseMatch string
+	for _, variant := range tmpl.Variants {
+		if strings.EqualFold(variant.Language, language) {
+			return variant.Body
+		}
+		if baseMatch == "" && strings.EqualFold(variant.Language, base) {
+			baseMatch = variant.Body
+		}
+	}
+	if baseMatch != "" {
+		return baseMatch
+	}
+	return tmpl.Body
+}
+
+// renderTemplate renders a saved template for a send; every declared variable must be provided
+func (s *service) renderTemplate(ctx context.Context, userID uint, opts dtos.TemplateOptionsDTO) (string, error) {
+	tmpl, err := s.findTemplate(ctx, userID, opts.TemplateID)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	declared := make(map[string]bool, len(tmpl.Variables))
+	for _, name := range tmpl.Variables {
+		declared[name] = true
+		if _, ok := opts.Variables[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: missing variables %s", ErrInvalidTemplate, strings.Join(missing, ", "))
+	}
+
+	parsed, err := parseTemplateBody(tmpl.Name, pickTemplateBody(tmpl, opts.Language), declared)
+	if err != nil {
+		return "", err
+	}
+	text, err := renderMessage(parsed, opts.Variables)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	return text, nil
+}
+
+func (s *service) CreateTemplate(ctx context.Context, req dtos.TemplateDTO) (*entities.WhatsAppTemplate, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	if err := validateTemplate(req); err != nil {
+		return nil, err
+	}
+	if err := s.checkTemplateName(ctx, userID, 0, req.Name); err != nil {
+		return nil, err
+	}
+
+	tmpl := &entities.WhatsAppTemplate{
+		UserID:    userID,
+		Name:      req.Name,
+		Language:  req.Language,
+		Body:      req.Body,
+		Variables: req.Variables,
+		Variants:  toTemplateVariants(req.Variants),
+	}
+	if err := database.DBClient().WithContext(ctx).Create(tmpl).Error; err != nil {
+		return nil, fmt.Errorf("failed to create template: %v", err)
+	}
+	return tmpl, nil
+}
+
+func (s *service) ListTemplates(ctx context.Context) ([]entities.WhatsAppTemplate, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	var templates []entities.WhatsAppTemplate
+	err = database.DBClient().WithContext(ctx).Preload("Variants").Where("user_id = ?", userID).Order("name").Find(&templates).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %v", err)
+	}
+	return templates, nil
+}
+
+func (s *service) GetTemplate(ctx context.Context, id uint) (*entities.WhatsAppTemplate, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	return s.findTemplate(ctx, userID, id)
+}
+
+// UpdateTemplate replaces a template including all of its variants
+func (s *service) UpdateTemplate(ctx context.Context, id uint, req dtos.TemplateDTO) (*entities.WhatsAppTemplate, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	tmpl, err := s.findTemplate(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTemplate(req); err != nil {
+		return nil, err
+	}
+	if err := s.checkTemplateName(ctx, userID, id, req.Name); err != nil {
+		return nil, err
+	}
+
+	tmpl.Name = req.Name
+	tmpl.Language = req.Language
+	tmpl.Body = req.Body
+	tmpl.Variables = req.Variables
+	tmpl.Variants = toTemplateVariants(req.Variants)
+
+	err = database.DBClient().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("template_id = ?", id).Delete(&entities.WhatsAppTemplateVariant{}).Error; err != nil {
+			return err
+		}
+		return tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(tmpl).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update template: %v", err)
+	}
+	return tmpl, nil
+}
+
+func (s *service) DeleteTemplate(ctx context.Context, id uint) error {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("authentication required: %v", err)
+	}
+	tmpl, err := s.findTemplate(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	err = database.DBClient().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("template_id = ?", id).Delete(&entities.WhatsAppTemplateVariant{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(tmpl).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %v", err)
+	}
+	return nil
+}
+
+// checkTemplateName rejects a name already used by another of the user's templates
+func (s *service) checkTemplateName(ctx context.Context, userID, id uint, name string) error {
+	var count int64
+	err := database.DBClient().WithContext(ctx).Model(&entities.WhatsAppTemplate{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, id).Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to check template name: %v", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: a template named %q already exists", ErrInvalidTemplate, name)
+	}
+	return nil
+}
+
+func (s *service) findTemplate(ctx context.Context, userID, id uint) (*entities.WhatsAppTemplate, error) {
+	var tmpl entities.WhatsAppTemplate
+	err := database.DBClient().WithContext(ctx).Preload("Variants").Where("id = ? AND user_id = ?", id, userID).First(&tmpl).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %v", err)
+	}
+	return &tmpl, nil
+}
+
+func toTemplateVariants(variants []dtos.TemplateVariantDTO) []entities.WhatsAppTemplateVariant {
+	result := make([]entities.WhatsAppTemplateVariant, 0, len(variants))
+	for _, variant := range variants {
+		result = append(result, entities.WhatsAppTemplateVariant{
+			Language: variant.Language,
+			Body:     variant.Body,
+		})
+	}
+	return result
+}
@@ -0,0 +1,173 @@
+package whatsapp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      dtos.TemplateDTO
+		wantErr  bool
+		mentions string // Expected in the error message
+	}{
+		{
+			name: "declared variables",
+			req:  dtos.TemplateDTO{Name: "t", Body: "Hi {{.name}}, your order {{.order}} shipped", Variables: []string{"name", "order"}},
+		},
+		{
+			name: "no variables",
+			req:  dtos.TemplateDTO{Name: "t", Body: "Thanks for your message"},
+		},
+		{
+			name: "declared but unused",
+			req:  dtos.TemplateDTO{Name: "t", Body: "Hello", Variables: []string{"name"}},
+		},
+		{
+			name: "variables inside conditionals",
+			req:  dtos.TemplateDTO{Name: "t", Body: "{{if .vip}}Dear {{.name}}{{else}}Hi{{end}}", Variables: []string{"vip", "name"}},
+		},
+		{
+			name: "variables inside pipelines",
+			req:  dtos.TemplateDTO{Name: "t", Body: `{{printf "%s!" .name}}`, Variables: []string{"name"}},
+		},
+		{
+			name:     "undeclared variable",
+			req:      dtos.TemplateDTO{Name: "t", Body: "Hi {{.name}}"},
+			wantErr:  true,
+			mentions: "name",
+		},
+		{
+			name:     "undeclared variables are listed sorted",
+			req:      dtos.TemplateDTO{Name: "t", Body: "{{.zip}} {{.city}}", Variables: []string{"name"}},
+			wantErr:  true,
+			mentions: "city, zip",
+		},
+		{
+			name:     "undeclared variable in else branch",
+			req:      dtos.TemplateDTO{Name: "t", Body: "{{if .vip}}VIP{{else}}{{.tier}}{{end}}", Variables: []string{"vip"}},
+			wantErr:  true,
+			mentions: "tier",
+		},
+		{
+			name:     "undeclared variable through the root",
+			req:      dtos.TemplateDTO{Name: "t", Body: "{{with .name}}{{$.code}}{{end}}", Variables: []string{"name"}},
+			wantErr:  true,
+			mentions: "code",
+		},
+		{
+			name:     "undeclared variable in a defined template",
+			req:      dtos.TemplateDTO{Name: "t", Body: `{{define "greet"}}Hi {{.name}}{{end}}{{template "greet" .}}`},
+			wantErr:  true,
+			mentions: "name",
+		},
+		{
+			name:     "syntax error",
+			req:      dtos.TemplateDTO{Name: "t", Body: "Hi {{.name"},
+			wantErr:  true,
+			mentions: "unclosed action",
+		},
+		{
+			name:     "empty variable name",
+			req:      dtos.TemplateDTO{Name: "t", Body: "Hi", Variables: []string{""}},
+			wantErr:  true,
+			mentions: "must not be empty",
+		},
+		{
+			name: "variant with declared variables",
+			req: dtos.TemplateDTO{Name: "t", Body: "Hi {{.name}}", Variables: []string{"name"}, Variants: []dtos.TemplateVariantDTO{
+				{Language: "es", Body: "Hola {{.name}}"},
+			}},
+		},
+		{
+			name: "undeclared variable in a variant",
+			req: dtos.TemplateDTO{Name: "t", Body: "Hi {{.name}}", Variables: []string{"name"}, Variants: []dtos.TemplateVariantDTO{
+				{Language: "es", Body: "Hola {{.nombre}}"},
+			}},
+			wantErr:  true,
+			mentions: "variant es",
+		},
+		{
+			name: "duplicate variant language",
+			req: dtos.TemplateDTO{Name: "t", Body: "Hi", Variants: []dtos.TemplateVariantDTO{
+				{Language: "pt-BR", Body: "Oi"},
+				{Language: "pt-br", Body: "Olá"},
+			}},
+			wantErr:  true,
+			mentions: "duplicate variant",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTemplate(tt.req)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("validateTemplate: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidTemplate) {
+				t.Fatalf("validateTemplate error = %v, want ErrInvalidTemplate", err)
+			}
+			if !strings.Contains(err.Error(), tt.mentions) {
+				t.Fatalf("validateTemplate error = %v, want it to mention %q", err, tt.mentions)
+			}
+		})
+	}
+}
+
+func TestPickTemplateBody(t *testing.T) {
+	tmpl := &entities.WhatsAppTemplate{
+		Body: "Hi",
+		Variants: []entities.WhatsAppTemplateVariant{
+			{Language: "pt", Body: "Olá"},
+			{Language: "pt-BR", Body: "Oi"},
+			{Language: "es", Body: "Hola"},
+		},
+	}
+
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{language: "", want: "Hi"},
+		{language: "pt-BR", want: "Oi"},
+		{language: "pt-br", want: "Oi"},
+		{language: "pt-PT", want: "Olá"},
+		{language: "es-MX", want: "Hola"},
+		{language: "ES", want: "Hola"},
+		{language: "de", want: "Hi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.language, func(t *testing.T) {
+			if got := pickTemplateBody(tmpl, tt.language); got != tt.want {
+				t.Fatalf("pickTemplateBody(%q) = %q, want %q", tt.language, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateBody(t *testing.T) {
+	declared := map[string]bool{"name": true, "order": true}
+	tmpl, err := parseTemplateBody("t", "Hi {{.name}}, order {{.order}}", declared)
+	if err != nil {
+		t.Fatalf("parseTemplateBody: %v", err)
+	}
+
+	text, err := renderMessage(tmpl, map[string]string{"name": "Ana", "order": "#42"})
+	if err != nil || text != "Hi Ana, order #42" {
+		t.Fatalf("renderMessage = %q, %v; want %q", text, err, "Hi Ana, order #42")
+	}
+
+	// Declared variables are checked before rendering, but a missing key must still fail rather than print <no value>
+	if _, err := renderMessage(tmpl, map[string]string{"name": "Ana"}); err == nil {
+		t.Fatalf("renderMessage with a missing variable succeeded, want error")
+	}
+}
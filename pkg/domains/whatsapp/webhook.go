@@ -0,0 +1,519 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/constant"
+	"github.com/crm/pkg/database"
+	"github.com/crm/pkg/dtos"
+	"github.com/crm/pkg/entities"
+	"github.com/crm/pkg/utils"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+)
+
+// ErrWebhookNotFound is returned when a webhook does not exist for the user
+var ErrWebhookNotFound = errors.New(constant.WEBHOOK_NOT_FOUND)
+
+// ErrWebhookDeliveryNotFound is returned when a delivery does not exist for the user
+var ErrWebhookDeliveryNotFound = errors.New(constant.WEBHOOK_DELIVERY_NOT_FOUND)
+
+// ErrInvalidWebhook is returned for subscriptions with an unusable URL
+var ErrInvalidWebhook = errors.New(constant.INVALID_WEBHOOK)
+
+const (
+	// webhookBatch is how many due deliveries are loaded per pass
+	webhookBatch = 50
+	// webhookWorkers bounds the number of concurrent requests to subscribers
+	webhookWorkers = 8
+	// webhookResponseLimit is how much of a subscriber's response is kept in the delivery log
+	webhookResponseLimit = 1024
+)
+
+// webhookSettings controls polling, timeouts and retry backoff of webhook deliveries
+type webhookSettings struct {
+	retry   outboxSettings
+	timeout time.Duration
+}
+
+func newWebhookSettings(wc config.Webhooks) webhookSettings {
+	settings := webhookSettings{
+		retry: newOutboxSettings(config.Outbox{
+			PollIntervalMs: wc.PollIntervalMs,
+			MaxAttempts:    wc.MaxAttempts,
+			BaseBackoffMs:  wc.BaseBackoffMs,
+			MaxBackoffSec:  wc.MaxBackoffSec,
+		}),
+		timeout: time.Duration(wc.TimeoutSec) * time.Second,
+	}
+	if settings.timeout <= 0 {
+		settings.timeout = 10 * time.Second
+	}
+	return settings
+}
+
+// newWebhookClient builds the client deliveries are POSTed with. Redirects are not
+// followed, so a subscriber cannot bounce the signed payload to another host.
+func newWebhookClient(wc config.Webhooks, timeout time.Duration) *http.Client {
+	client := newGuardedClient(timeout, wc.AllowPrivate)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client
+}
+
+// randomToken returns n random bytes as hex
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// signWebhook returns the X-Webhook-Signature value of a payload sent at timestamp
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateWebhookURL accepts absolute http(s) URLs; the address itself is checked when connecting
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	return nil
+}
+
+// emitWebhook queues an event for every active webhook of the user subscribed to its type
+func (s *service) emitWebhook(userID uint, eventType string, data interface{}) {
+	db := database.DBClient()
+
+	var hooks []entities.WhatsAppWebhook
+	if err := db.Where("user_id = ? AND active = ?", userID, true).Find(&hooks).Error; err != nil {
+		log.Printf("Failed to load webhooks of user %d: %v", userID, err)
+		return
+	}
+
+	hooks = slices.DeleteFunc(hooks, func(hook entities.WhatsAppWebhook) bool {
+		return len(hook.Events) > 0 && !slices.Contains(hook.Events, eventType)
+	})
+	if len(hooks) == 0 {
+		return
+	}
+
+	// Every subscriber receives the same event ID and payload
+	event := dtos.WebhookEventDTO{
+		ID:        randomToken(16),
+		Type:      eventType,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Data:      data,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s webhook event of user %d: %v", eventType, userID, err)
+		return
+	}
+
+	deliveries := make([]entities.WhatsAppWebhookDelivery, 0, len(hooks))
+	for _, hook := range hooks {
+		deliveries = append(deliveries, entities.WhatsAppWebhookDelivery{
+			WebhookID:     hook.ID,
+			UserID:        userID,
+			EventID:       event.ID,
+			EventType:     eventType,
+			Payload:       string(payload),
+			Status:        constant.WEBHOOK_STATUS_PENDING,
+			NextAttemptAt: time.Now(),
+		})
+	}
+	if err := db.Create(&deliveries).Error; err != nil {
+		log.Printf("Failed to queue %s webhook event of user %d: %v", eventType, userID, err)
+		return
+	}
+	s.wakeWebhooks()
+}
+
+// wakeWebhooks nudges the delivery worker without waiting for the next poll
+func (s *service) wakeWebhooks() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhooks delivers queued webhook events. Deliveries stay in Postgres, so
+// anything pending at a restart is sent once the service is back.
+func (s *service) runWebhooks() {
+	// Attempts interrupted by a crash are retried
+	database.DBClient().Model(&entities.WhatsAppWebhookDelivery{}).
+		Where("status = ?", constant.WEBHOOK_STATUS_SENDING).
+		Update("status", constant.WEBHOOK_STATUS_PENDING)
+
+	ticker := time.NewTicker(s.webhooks.retry.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.webhookWake:
+		}
+		s.drainWebhooks()
+	}
+}
+
+// drainWebhooks sends due deliveries until none are left
+func (s *service) drainWebhooks() {
+	db := database.DBClient()
+	for {
+		var due []entities.WhatsAppWebhookDelivery
+		err := db.Where("status = ? AND next_attempt_at <= ?", constant.WEBHOOK_STATUS_PENDING, time.Now()).
+			Order("id").Limit(webhookBatch).Find(&due).Error
+		if err != nil {
+			log.Printf("Failed to load webhook deliveries: %v", err)
+			return
+		}
+		if len(due) == 0 {
+			return
+		}
+
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, webhookWorkers)
+		for i := range due {
+			// Claim the delivery so a concurrent pass cannot send it twice
+			result := db.Model(&entities.WhatsAppWebhookDelivery{}).
+				Where("id = ? AND status = ?", due[i].ID, constant.WEBHOOK_STATUS_PENDING).
+				Updates(map[string]interface{}{
+					"status":   constant.WEBHOOK_STATUS_SENDING,
+					"attempts": gorm.Expr("attempts + 1"),
+				})
+			if result.Error != nil || result.RowsAffected == 0 {
+				continue
+			}
+			due[i].Attempts++
+
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(delivery *entities.WhatsAppWebhookDelivery) {
+				defer func() { <-slots; wg.Done() }()
+				s.deliverWebhook(delivery)
+			}(&due[i])
+		}
+		wg.Wait()
+	}
+}
+
+// deliverWebhook makes one delivery attempt and records the outcome
+func (s *service) deliverWebhook(delivery *entities.WhatsAppWebhookDelivery) {
+	db := database.DBClient()
+
+	// Deliveries of deleted or disabled webhooks are given up right away
+	var hook entities.WhatsAppWebhook
+	permanent := true
+	err := db.First(&hook, delivery.WebhookID).Error
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		err = errors.New("webhook was deleted")
+	case err != nil:
+		permanent = false
+		err = fmt.Errorf("failed to get webhook: %v", err)
+	case !hook.Active:
+		err = errors.New("webhook is inactive")
+	default:
+		permanent = false
+		started := time.Now()
+		delivery.ResponseStatus, delivery.ResponseBody, err = s.postWebhook(&hook, delivery)
+		delivery.DurationMs = time.Since(started).Milliseconds()
+		if err == nil && (delivery.ResponseStatus < 200 || delivery.ResponseStatus > 299) {
+			err = fmt.Errorf("subscriber responded with status %d", delivery.ResponseStatus)
+		}
+	}
+
+	switch {
+	case err == nil:
+		deliveredAt := time.Now()
+		delivery.Status = constant.WEBHOOK_STATUS_DELIVERED
+		delivery.DeliveredAt = &deliveredAt
+		delivery.LastError = ""
+	case permanent || delivery.Attempts >= s.webhooks.retry.maxAttempts:
+		delivery.Status = constant.WEBHOOK_STATUS_FAILED
+		delivery.LastError = err.Error()
+		log.Printf("Webhook delivery %d to %s failed after %d attempts: %v", delivery.ID, hook.URL, delivery.Attempts, err)
+	default:
+		delivery.Status = constant.WEBHOOK_STATUS_PENDING
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(s.webhooks.retry.backoff(delivery.Attempts))
+		log.Printf("Webhook delivery %d to %s failed, retrying at %s: %v", delivery.ID, hook.URL, delivery.NextAttemptAt.Format(time.RFC3339), err)
+	}
+
+	if err := db.Save(delivery).Error; err != nil {
+		log.Printf("Failed to update webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// postWebhook sends the signed payload and returns the response status and the start of its body
+func (s *service) postWebhook(hook *entities.WhatsAppWebhook, delivery *entities.WhatsAppWebhookDelivery) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.webhooks.timeout)
+	defer cancel()
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "crm-webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Event-ID", delivery.EventID)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(hook.Secret, timestamp, payload))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errDisallowedAddress) {
+			return 0, "", fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+		}
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	return resp.StatusCode, string(body), nil
+}
+
+// receiptPayload is the data of a receipt webhook event
+func receiptPayload(evt *events.Receipt) map[string]interface{} {
+	return map[string]interface{}{
+		"chat_jid":    evt.Chat.String(),
+		"sender_jid":  evt.Sender.String(),
+		"is_from_me":  evt.IsFromMe,
+		"is_group":    evt.IsGroup,
+		"type":        receiptType(evt.Type),
+		"message_ids": evt.MessageIDs,
+		"timestamp":   evt.Timestamp.Format(time.RFC3339),
+	}
+}
+
+// receiptType names the delivery receipt, which whatsmeow leaves empty
+func receiptType(t types.ReceiptType) string {
+	if t == types.ReceiptTypeDelivered {
+		return "delivered"
+	}
+	return string(t)
+}
+
+// groupInfoPayload is the data of a group webhook event; only the changed fields are set
+func groupInfoPayload(evt *events.GroupInfo) map[string]interface{} {
+	data := map[string]interface{}{
+		"action":    "updated",
+		"group_jid": evt.JID.String(),
+		"timestamp": evt.Timestamp.Format(time.RFC3339),
+	}
+	if evt.Sender != nil {
+		data["sender_jid"] = evt.Sender.String()
+	}
+	if evt.Name != nil {
+		data["name"] = evt.Name.Name
+	}
+	if evt.Topic != nil {
+		data["topic"] = evt.Topic.Topic
+	}
+	for key, jids := range map[string][]types.JID{
+		"joined": evt.Join, "left": evt.Leave, "promoted": evt.Promote, "demoted": evt.Demote,
+	} {
+		if len(jids) == 0 {
+			continue
+		}
+		members := make([]string, len(jids))
+		for i, jid := range jids {
+			members[i] = jid.String()
+		}
+		data[key] = members
+	}
+	return data
+}
+
+// CreateWebhook subscribes a URL to the user's events; a secret is generated when none is given
+func (s *service) CreateWebhook(ctx context.Context, req dtos.WebhookDTO) (*entities.WhatsAppWebhook, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	if err := validateWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	hook := &entities.WhatsAppWebhook{
+		UserID:      userID,
+		URL:         req.URL,
+		Secret:      req.Secret,
+		Events:      req.Events,
+		Active:      req.Active == nil || *req.Active,
+		Description: req.Description,
+	}
+	if hook.Secret == "" {
+		hook.Secret = randomToken(32)
+	}
+	if err := database.DBClient().WithContext(ctx).Create(hook).Error; err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %v", err)
+	}
+	return hook, nil
+}
+
+func (s *service) ListWebhooks(ctx context.Context) ([]entities.WhatsAppWebhook, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	var hooks []entities.WhatsAppWebhook
+	if err := database.DBClient().WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&hooks).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %v", err)
+	}
+	return hooks, nil
+}
+
+// UpdateWebhook replaces a subscription; the secret is only rotated when a new one is given
+func (s *service) UpdateWebhook(ctx context.Context, id uint, req dtos.WebhookDTO) (*entities.WhatsAppWebhook, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+	hook, err := s.findWebhook(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	hook.URL = req.URL
+	hook.Events = req.Events
+	hook.Description = req.Description
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	if err := database.DBClient().WithContext(ctx).Save(hook).Error; err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %v", err)
+	}
+	return hook, nil
+}
+
+// DeleteWebhook removes a subscription; its pending deliveries fail on their next attempt
+func (s *service) DeleteWebhook(ctx context.Context, id uint) error {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("authentication required: %v", err)
+	}
+	hook, err := s.findWebhook(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	if err := database.DBClient().WithContext(ctx).Delete(hook).Error; err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns one page of a webhook's delivery log, newest first
+func (s *service) ListWebhookDeliveries(ctx context.Context, id uint, status string, page int) ([]entities.WhatsAppWebhookDelivery, int, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("authentication required: %v", err)
+	}
+	if _, err := s.findWebhook(ctx, userID, id); err != nil {
+		return nil, 0, err
+	}
+
+	query := "webhook_id = ? AND user_id = ?"
+	args := []interface{}{id, userID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+
+	var deliveries []entities.WhatsAppWebhookDelivery
+	totalPages, err := utils.Pagination(&deliveries, page, database.DBClient().Order("id desc"), ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return deliveries, totalPages, nil
+}
+
+// RedeliverWebhook queues the payload of an earlier delivery again, signed with the webhook's current secret
+func (s *service) RedeliverWebhook(ctx context.Context, deliveryID uint) (*entities.WhatsAppWebhookDelivery, error) {
+	userID, err := s.getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication required: %v", err)
+	}
+
+	db := database.DBClient().WithContext(ctx)
+	var original entities.WhatsAppWebhookDelivery
+	err = db.Where("id = ? AND user_id = ?", deliveryID, userID).First(&original).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %v", err)
+	}
+	if _, err := s.findWebhook(ctx, userID, original.WebhookID); err != nil {
+		return nil, err
+	}
+
+	delivery := &entities.WhatsAppWebhookDelivery{
+		WebhookID:     original.WebhookID,
+		UserID:        userID,
+		EventID:       original.EventID,
+		EventType:     original.EventType,
+		Payload:       original.Payload,
+		Status:        constant.WEBHOOK_STATUS_PENDING,
+		NextAttemptAt: time.Now(),
+		RedeliveryOf:  &original.ID,
+	}
+	if err := db.Create(delivery).Error; err != nil {
+		return nil, fmt.Errorf("failed to queue redelivery: %v", err)
+	}
+
+	s.wakeWebhooks()
+	return delivery, nil
+}
+
+func (s *service) findWebhook(ctx context.Context, userID, id uint) (*entities.WhatsAppWebhook, error) {
+	var hook entities.WhatsAppWebhook
+	err := database.DBClient().WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&hook).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %v", err)
+	}
+	return &hook, nil
+}
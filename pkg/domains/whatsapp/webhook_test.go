@@ -0,0 +1,161 @@
+package whatsapp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/crm/pkg/config"
+	"github.com/crm/pkg/entities"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		payload   string
+		want      string
+	}{
+		{
+			name:      "event payload",
+			secret:    "whsec_test",
+			timestamp: "1700000000",
+			payload:   `{"id":"evt_1","type":"message"}`,
+			want:      "sha256=9ae5cbcf16be3eae909d2cf79a514dcdb27a239ac15b33b44a14b2a2923b6f3d",
+		},
+		{
+			name:      "empty payload",
+			secret:    "key",
+			timestamp: "0",
+			want:      "sha256=85841b4efc3cd7776c3c8f9b7cca9e281c550e5d19889d78e9e669c6337f000d",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signWebhook(tt.secret, tt.timestamp, []byte(tt.payload)); got != tt.want {
+				t.Fatalf("signWebhook = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The timestamp is signed too, so a captured payload cannot be replayed under a new one
+	payload := []byte(`{"id":"evt_1"}`)
+	if signWebhook("s", "1700000000", payload) == signWebhook("s", "1700000001", payload) {
+		t.Fatalf("signature does not depend on the timestamp")
+	}
+	if signWebhook("s", "1700000000", payload) == signWebhook("t", "1700000000", payload) {
+		t.Fatalf("signature does not depend on the secret")
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "https://example.com/hooks/whatsapp"},
+		{url: "http://example.com:8080/hook"},
+		{url: "ftp://example.com/hook", wantErr: true},
+		{url: "example.com/hook", wantErr: true},
+		{url: "/hook", wantErr: true},
+		{url: "https://", wantErr: true},
+		{url: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := validateWebhookURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateWebhookURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidWebhook) {
+				t.Fatalf("validateWebhookURL(%q) error = %v, want ErrInvalidWebhook", tt.url, err)
+			}
+		})
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/hook", http.StatusTemporaryRedirect)
+		default:
+			body, _ = io.ReadAll(r.Body)
+			received <- r
+			w.WriteHeader(http.StatusAccepted)
+			io.WriteString(w, "queued")
+		}
+	}))
+	defer server.Close()
+
+	delivery := &entities.WhatsAppWebhookDelivery{EventID: "evt_1", EventType: "message", Payload: `{"id":"evt_1"}`}
+	delivery.ID = 7
+
+	tests := []struct {
+		name         string
+		allowPrivate bool
+		path         string
+		wantStatus   int
+		wantBody     string
+		wantErr      error
+	}{
+		{name: "signed delivery", allowPrivate: true, path: "/hook", wantStatus: http.StatusAccepted, wantBody: "queued"},
+		{name: "redirects are not followed", allowPrivate: true, path: "/redirect", wantStatus: http.StatusTemporaryRedirect},
+		{name: "private address blocked", path: "/hook", wantErr: ErrInvalidWebhook},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wc := config.Webhooks{AllowPrivate: tt.allowPrivate}
+			s := &service{webhooks: newWebhookSettings(wc), webhookClient: newWebhookClient(wc, 5*time.Second)}
+			hook := &entities.WhatsAppWebhook{URL: server.URL + tt.path, Secret: "whsec_test"}
+
+			status, respBody, err := s.postWebhook(hook, delivery)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("postWebhook error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("postWebhook: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			if respBody != tt.wantBody {
+				t.Fatalf("response body = %q, want %q", respBody, tt.wantBody)
+			}
+
+			req := <-received
+			if string(body) != delivery.Payload {
+				t.Fatalf("payload = %q, want %q", body, delivery.Payload)
+			}
+			want := signWebhook("whsec_test", req.Header.Get("X-Webhook-Timestamp"), body)
+			if got := req.Header.Get("X-Webhook-Signature"); got != want {
+				t.Fatalf("X-Webhook-Signature = %q, want %q", got, want)
+			}
+			for header, value := range map[string]string{
+				"X-Webhook-Event":    "message",
+				"X-Webhook-Event-ID": "evt_1",
+				"X-Webhook-Delivery": "7",
+				"Content-Type":       "application/json",
+			} {
+				if got := req.Header.Get(header); got != value {
+					t.Fatalf("%s = %q, want %q", header, got, value)
+				}
+			}
+		})
+	}
+}
@@ -1,17 +1,88 @@
 package dtos
 
+import (
+	"encoding/json"
+	"io"
+)
+
+// ScheduleDTO defers a send; SendAt is RFC3339 or a local "2006-01-02 15:04" time in Timezone.
+// Recurrence is a five-field cron expression evaluated in Timezone.
+type ScheduleDTO struct {
+	SendAt     string `json:"send_at"`
+	Timezone   string `json:"timezone"` // IANA name, defaults to UTC
+	Recurrence string `json:"recurrence"`
+}
+
+// TemplateOptionsDTO renders the message text or caption from a saved template
+type TemplateOptionsDTO struct {
+	TemplateID uint              `json:"template_id"`
+	Variables  map[string]string `json:"variables"`
+	Language   string            `json:"language"` // Picks a variant, e.g. "de" or "pt-BR"
+}
+
 type SendMessageDTO struct {
-	PhoneNumber string `json:"phone_number" binding:"required"`
-	Message     string `json:"message" binding:"required"`
+	PhoneNumber   string `json:"phone_number" binding:"required"`
+	Message       string `json:"message"`       // Required unless template_id is set
+	Async         bool   `json:"async"`         // Queue in the outbox and return immediately
+	Transactional bool   `json:"transactional"` // OTPs, receipts and the like skip pacing and daily caps
+	ScheduleDTO
+	TemplateOptionsDTO
 }
 
 type SendMediaMessageDTO struct {
-	PhoneNumber string `json:"phone_number" binding:"required"`
-	Caption     string `json:"caption"`
-	MediaData   []byte `json:"media_data" binding:"required"`
-	MimeType    string `json:"mime_type" binding:"required"`
-	Height      uint32 `json:"height"`
-	Width       uint32 `json:"width"`
+	PhoneNumber   string    `json:"phone_number" binding:"required"`
+	Caption       string    `json:"caption"`
+	Media         io.Reader `json:"-"`        // Streamed file content, usually a spooled temp file
+	MediaID       uint      `json:"media_id"` // Media library entry sent instead of Media
+	FileName      string    `json:"file_name"`
+	FileSize      int64     `json:"file_size"`
+	MimeType      string    `json:"mime_type" binding:"required"`
+	Height        uint32    `json:"height"`
+	Width         uint32    `json:"width"`
+	ViewOnce      bool      `json:"view_once"`     // Image, video and audio only
+	Voice         bool      `json:"voice"`         // Send audio as a push-to-talk voice note
+	Async         bool      `json:"async"`         // Queue in the outbox and return immediately
+	Transactional bool      `json:"transactional"` // OTPs, receipts and the like skip pacing and daily caps
+	ScheduleDTO
+	TemplateOptionsDTO
+}
+
+// SendMediaJSONDTO is the JSON variant of /send-media; exactly one of MediaData, MediaURL or MediaID is required
+type SendMediaJSONDTO struct {
+	PhoneNumber   string `json:"phone_number" binding:"required"`
+	Caption       string `json:"caption"`
+	MediaData     string `json:"media_data"` // Base64 encoded file content
+	MediaURL      string `json:"media_url"`  // HTTP(S) URL fetched by the service
+	MediaID       uint   `json:"media_id"`   // Media library entry
+	FileName      string `json:"file_name"`
+	MimeType      string `json:"mime_type"` // Required with media_data, detected from the response for media_url
+	Height        uint32 `json:"height"`
+	Width         uint32 `json:"width"`
+	ViewOnce      bool   `json:"view_once"`
+	Voice         bool   `json:"voice"`
+	Async         bool   `json:"async"`
+	Transactional bool   `json:"transactional"` // OTPs, receipts and the like skip pacing and daily caps
+	ScheduleDTO
+	TemplateOptionsDTO
+}
+
+type DisappearingTimerDTO struct {
+	PhoneNumber string `json:"phone_number"` // Direct chat, or
+	ChatJID     string `json:"chat_jid"`     // any chat JID including groups
+	Timer       string `json:"timer" binding:"required,oneof=off 24h 7d 90d"`
+}
+
+type BroadcastRecipientDTO struct {
+	PhoneNumber string            `json:"phone_number" binding:"required"`
+	Variables   map[string]string `json:"variables"`
+}
+
+// CreateBroadcastDTO starts a bulk send; Message uses text/template syntax, e.g. "Hi {{.name}}"
+type CreateBroadcastDTO struct {
+	Message    string                  `json:"message"`
+	MediaID    uint                    `json:"media_id"`
+	IntervalMs int                     `json:"interval_ms"`
+	Recipients []BroadcastRecipientDTO `json:"recipients" binding:"required,min=1,dive"`
 }
 
 type WhatsAppStatusDTO struct {
@@ -38,8 +109,306 @@ type ContactInfoDTO struct {
 }
 
 type MessageResponseDTO struct {
+	MessageID  string `json:"message_id"`
+	Timestamp  string `json:"timestamp"` // Next run for scheduled messages
+	Status     string `json:"status"`
+	To         string `json:"to"`
+	ScheduleID uint   `json:"schedule_id,omitempty"`
+	OutboxID   uint   `json:"outbox_id,omitempty"`
+}
+
+type LibraryUploadDTO struct {
+	Media    io.Reader
+	FileName string
+	FileSize int64
+	MimeType string
+}
+
+type LibraryMediaDTO struct {
+	ID         uint   `json:"id"`
+	FileName   string `json:"file_name"`
+	MimeType   string `json:"mime_type"`
+	MediaType  string `json:"media_type"`
+	FileLength uint64 `json:"file_length"`
+	UploadedAt string `json:"uploaded_at"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+// RescheduleDTO moves a scheduled message; an empty recurrence turns it into a one-off send
+type RescheduleDTO struct {
+	ScheduleDTO
+}
+
+type TemplateVariantDTO struct {
+	Language string `json:"language" binding:"required"`
+	Body     string `json:"body" binding:"required"`
+}
+
+// TemplateDTO creates or replaces a message template; bodies use text/template syntax, e.g. "Hi {{.name}}"
+type TemplateDTO struct {
+	Name      string               `json:"name" binding:"required,max=100"`
+	Language  string               `json:"language"`
+	Body      string               `json:"body" binding:"required"`
+	Variables []string             `json:"variables"`
+	Variants  []TemplateVariantDTO `json:"variants" binding:"dive"`
+}
+
+// PacingDTO replaces an account's send pacing settings
+type PacingDTO struct {
+	Enabled             bool `json:"enabled"`
+	MinIntervalMs       int  `json:"min_interval_ms" binding:"min=0"`
+	RecipientIntervalMs int  `json:"recipient_interval_ms" binding:"min=0"`
+	JitterMs            int  `json:"jitter_ms" binding:"min=0"`
+	Typing              bool `json:"typing"`
+	TypingMsPerChar     int  `json:"typing_ms_per_char" binding:"min=0"`
+	MaxTypingMs         int  `json:"max_typing_ms" binding:"min=0"`
+	DailyCap            int  `json:"daily_cap" binding:"min=0"`
+}
+
+// PresenceDTO sets the account's global presence
+type PresenceDTO struct {
+	State string `json:"state" binding:"required,oneof=available unavailable"`
+}
+
+// ChatPresenceDTO shows or clears a typing or recording indicator in one chat
+type ChatPresenceDTO struct {
+	PhoneNumber string `json:"phone_number"` // Direct chat, or
+	ChatJID     string `json:"chat_jid"`     // any chat JID including groups
+	State       string `json:"state" binding:"required,oneof=composing recording paused"`
+}
+
+// MarkReadDTO marks inbound messages of a chat as read; without message IDs every unread message is marked
+type MarkReadDTO struct {
+	PhoneNumber string   `json:"phone_number"`
+	ChatJID     string   `json:"chat_jid"`
+	MessageIDs  []string `json:"message_ids"`
+}
+
+// MessageHistoryDTO selects one page of a conversation
+type MessageHistoryDTO struct {
+	PhoneNumber string
+	ChatJID     string
+	Page        int
+	MarkRead    bool // Mark the returned inbound messages as read
+}
+
+// MessageSearchDTO filters a full-text search over stored messages
+type MessageSearchDTO struct {
+	Query     string
+	ChatJID   string
+	Contact   string // Phone number or JID of the other party
+	From      string // RFC3339 time or YYYY-MM-DD date, inclusive
+	To        string // RFC3339 time or YYYY-MM-DD date, inclusive
+	Direction string // incoming or outgoing
+	Language  string // Text search configuration, defaults to the configured one
+	Page      int
+}
+
+// MessageSearchResultDTO is one stored message matching a search
+type MessageSearchResultDTO struct {
+	ID          uint    `json:"id"`
+	MessageID   string  `json:"message_id"`
+	ChatJID     string  `json:"chat_jid"`
+	FromJID     string  `json:"from_jid"`
+	IsIncoming  bool    `json:"is_incoming"`
+	MessageType string  `json:"message_type"`
+	Timestamp   string  `json:"timestamp"`
+	Snippet     string  `json:"snippet"` // HTML-escaped matching text with hits wrapped in <mark>; safe to render as HTML
+	Rank        float64 `json:"rank"`
+}
+
+// ExportDTO selects the messages of a transcript export: one chat, a date range, or both
+type ExportDTO struct {
+	PhoneNumber  string
+	ChatJID      string
+	From         string // RFC3339 time or YYYY-MM-DD date, inclusive
+	To           string // RFC3339 time or YYYY-MM-DD date, inclusive
+	Format       string // txt (default), csv or json
+	TimeZone     string // IANA zone timestamps are written in, defaults to UTC
+	IncludeMedia bool   // Bundle the transcript and stored attachments as a ZIP
+}
+
+// ExportFileDTO describes the streamed export download
+type ExportFileDTO struct {
+	FileName       string
+	ContentType    string
+	TranscriptName string // Name of the transcript inside a ZIP
+}
+
+// ExportMessageDTO is one message in CSV and JSON exports
+type ExportMessageDTO struct {
+	ID          uint   `json:"id"`
+	MessageID   string `json:"message_id"`
+	ChatJID     string `json:"chat_jid"`
+	Timestamp   string `json:"timestamp"`
+	FromJID     string `json:"from_jid"`
+	Sender      string `json:"sender"`
+	Direction   string `json:"direction"`
+	MessageType string `json:"message_type"`
+	Content     string `json:"content"`
+	MediaID     uint   `json:"media_id,omitempty"`
+	MediaFile   string `json:"media_file,omitempty"` // Name of the attachment inside the ZIP
+	EditedAt    string `json:"edited_at,omitempty"`
+	Imported    bool   `json:"imported"`
+}
+
+// ImportDTO imports a WhatsApp "Export chat" transcript, or a ZIP with its attachments, into one chat
+type ImportDTO struct {
+	File        io.ReaderAt
+	FileSize    int64
+	PhoneNumber string
+	ChatJID     string
+	DateOrder   string            // dmy (default), mdy or ymd; only used when the dates are ambiguous
+	TimeZone    string            // IANA zone the phone exported in, defaults to UTC
+	SelfName    string            // Name the account appears under, defaults to its push name
+	Senders     map[string]string // Sender name to phone number or JID
+}
+
+// ImportResultDTO summarizes an import
+type ImportResultDTO struct {
+	ChatJID    string            `json:"chat_jid"`
+	Imported   int               `json:"imported"`
+	Duplicates int               `json:"duplicates"` // Already stored by an earlier import
+	Skipped    int               `json:"skipped"`    // System notices and unreadable lines
+	Media      int               `json:"media"`
+	Senders    map[string]string `json:"senders"` // How sender names were mapped to JIDs
+}
+
+// StatusTextDTO posts a text Status update
+type StatusTextDTO struct {
+	Text            string `json:"text" binding:"required"`
+	BackgroundColor string `json:"background_color"` // "#RRGGBB" or "#AARRGGBB"
+	Font            int    `json:"font"`             // WhatsApp font style, 0 is the default
+	Audience        string `json:"audience" binding:"omitempty,oneof=contacts whitelist blacklist"`
+}
+
+// StatusMediaDTO posts an image, video or audio Status update
+type StatusMediaDTO struct {
+	Caption  string
+	Media    io.Reader // Streamed file content, or
+	MediaID  uint      // a media library entry
+	FileName string
+	FileSize int64
+	MimeType string
+	Audience string // Expected status privacy, see StatusTextDTO
+}
+
+// StatusAudienceDTO is the status privacy that decides who receives the account's posts
+type StatusAudienceDTO struct {
+	Type string   `json:"type"` // contacts, whitelist or blacklist
+	List []string `json:"list"` // JIDs included or excluded by the list types
+}
+
+// StatusFeedItemDTO is one Status update posted by a contact
+type StatusFeedItemDTO struct {
+	ID        uint   `json:"id"`
 	MessageID string `json:"message_id"`
-	Timestamp string `json:"timestamp"`
+	SenderJID string `json:"sender_jid"`
+	Kind      string `json:"kind"`
+	Content   string `json:"content"`
+	MediaID   uint   `json:"media_id,omitempty"` // Served by GET /media/:id
+	PostedAt  string `json:"posted_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// ForwardMessageDTO forwards a stored message; targets are chat JIDs or phone numbers
+type ForwardMessageDTO struct {
+	MessageID uint     `json:"message_id" binding:"required"` // ID of the stored message record
+	Targets   []string `json:"targets" binding:"required,min=1,max=50"`
+}
+
+// ForwardResultDTO is the outcome of forwarding to one target
+type ForwardResultDTO struct {
+	Target    string `json:"target"`
+	MessageID string `json:"message_id,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
 	Status    string `json:"status"`
-	To        string `json:"to"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ChatStateDTO archives, pins or mutes a chat; fields left out are not changed
+type ChatStateDTO struct {
+	PhoneNumber     string `json:"phone_number"`
+	ChatJID         string `json:"chat_jid"`
+	Archived        *bool  `json:"archived"`
+	Pinned          *bool  `json:"pinned"`
+	Muted           *bool  `json:"muted"`
+	MuteDurationSec int64  `json:"mute_duration_sec" binding:"min=0"` // 0 mutes forever
+}
+
+// StarMessageDTO stars or unstars a stored message
+type StarMessageDTO struct {
+	PhoneNumber string `json:"phone_number"`
+	ChatJID     string `json:"chat_jid"`
+	MessageID   string `json:"message_id" binding:"required"` // WhatsApp message ID
+	Starred     bool   `json:"starred"`
+}
+
+// LabelDTO creates or edits a label
+type LabelDTO struct {
+	Name  string `json:"name" binding:"required"`
+	Color int32  `json:"color" binding:"min=0,max=19"`
+}
+
+// LabelAssignDTO adds a label to or removes it from a chat, or from one message of it
+type LabelAssignDTO struct {
+	PhoneNumber string `json:"phone_number"`
+	ChatJID     string `json:"chat_jid"`
+	MessageID   string `json:"message_id"`
+	Labeled     bool   `json:"labeled"`
+}
+
+// ChatDTO is one entry of the inbox
+type ChatDTO struct {
+	ChatJID        string              `json:"chat_jid"`
+	Name           string              `json:"name"`
+	IsGroup        bool                `json:"is_group"`
+	LastMessage    *ChatLastMessageDTO `json:"last_message,omitempty"`
+	UnreadCount    int                 `json:"unread_count"`
+	Archived       bool                `json:"archived"`
+	Pinned         bool                `json:"pinned"`
+	Muted          bool                `json:"muted"`
+	MutedUntil     string              `json:"muted_until,omitempty"`
+	LastActivityAt string              `json:"last_activity_at,omitempty"`
+}
+
+// ChatLastMessageDTO previews the newest message of a chat
+type ChatLastMessageDTO struct {
+	MessageID   string `json:"message_id"`
+	Content     string `json:"content"`
+	MessageType string `json:"message_type"`
+	IsIncoming  bool   `json:"is_incoming"`
+	Timestamp   string `json:"timestamp"`
+}
+
+// ChatListDTO filters the inbox; Archived nil lists archived and unarchived chats
+type ChatListDTO struct {
+	Archived *bool
+	Page     int
+}
+
+// WebhookDTO creates or updates a webhook subscription; an empty Events list subscribes to every event type
+type WebhookDTO struct {
+	URL         string   `json:"url" binding:"required,url,max=2048"`
+	Secret      string   `json:"secret" binding:"max=255"` // Generated on create when empty, kept on update when empty
+	Events      []string `json:"events" binding:"dive,oneof=message message_edited receipt connection group"`
+	Active      *bool    `json:"active"` // Defaults to true
+	Description string   `json:"description" binding:"max=255"`
+}
+
+// StreamEventDTO is one event of the real-time stream; IDs increase monotonically per user
+type StreamEventDTO struct {
+	ID        uint64          `json:"id,omitempty"`
+	Type      string          `json:"type"`
+	CreatedAt string          `json:"created_at"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// WebhookEventDTO is the signed JSON body POSTed to webhook subscribers
+type WebhookEventDTO struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	UserID    uint        `json:"user_id"`
+	CreatedAt string      `json:"created_at"`
+	Data      interface{} `json:"data"`
 }
@@ -0,0 +1,43 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatsAppBroadcast is a bulk send job delivering one message to many recipients
+type WhatsAppBroadcast struct {
+	gorm.Model
+	UserID        uint       `json:"user_id" gorm:"index;not null"`
+	Message       string     `json:"message" gorm:"type:text"` // text/template source, rendered per recipient
+	MediaID       uint       `json:"media_id,omitempty"`       // Optional media library entry, Message becomes its caption
+	IntervalMs    int        `json:"interval_ms"`
+	Status        string     `json:"status" gorm:"type:varchar(50);index"`
+	Total         int        `json:"total"`
+	Sent          int        `json:"sent"`
+	Failed        int        `json:"failed"`
+	NotOnWhatsApp int        `json:"not_on_whatsapp" gorm:"column:not_on_whatsapp"`
+	Error         string     `json:"error,omitempty" gorm:"type:text"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// WhatsAppBroadcastRecipient tracks delivery of a broadcast to one number
+type WhatsAppBroadcastRecipient struct {
+	gorm.Model
+	BroadcastID uint       `json:"broadcast_id" gorm:"index;not null"`
+	PhoneNumber string     `json:"phone_number" gorm:"type:varchar(32);not null"`
+	JID         string     `json:"jid,omitempty" gorm:"type:varchar(255)"`
+	Variables   string     `json:"variables,omitempty" gorm:"type:text"` // JSON object of template variables
+	Status      string     `json:"status" gorm:"type:varchar(50);index"`
+	MessageID   string     `json:"message_id,omitempty" gorm:"type:varchar(255)"`
+	Error       string     `json:"error,omitempty" gorm:"type:text"`
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+
+	// Relations
+	Broadcast WhatsAppBroadcast `json:"-" gorm:"foreignKey:BroadcastID"`
+}
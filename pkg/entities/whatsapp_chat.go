@@ -0,0 +1,54 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatsAppChat holds the inbox state of a chat, kept in sync with the phone through app state
+type WhatsAppChat struct {
+	gorm.Model
+	UserID     uint       `json:"user_id" gorm:"uniqueIndex:idx_chat_user;not null"`
+	ChatJID    string     `json:"chat_jid" gorm:"type:varchar(255);uniqueIndex:idx_chat_user;not null"`
+	Name       string     `json:"name,omitempty" gorm:"type:varchar(255)"` // Subject of group chats
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+	Muted      bool       `json:"muted"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"` // Empty while muted means forever
+
+	// Seconds messages in the chat last before they disappear, zero when off or not known
+	DisappearingTimer uint32 `json:"disappearing_timer" gorm:"not null;default:0"`
+
+	// Inbox summary, maintained by a database trigger on whats_app_messages
+	LastMessageID   string     `json:"last_message_id,omitempty" gorm:"type:varchar(255)"`
+	LastContent     string     `json:"last_content,omitempty" gorm:"type:text"`
+	LastMessageType string     `json:"last_message_type,omitempty" gorm:"type:varchar(50)"`
+	LastIsIncoming  bool       `json:"last_is_incoming" gorm:"not null;default:false"`
+	LastMessageAt   *time.Time `json:"last_message_at,omitempty"`
+	UnreadCount     int        `json:"unread_count" gorm:"not null;default:0"`
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// WhatsAppLabel is a WhatsApp Business label of the account
+type WhatsAppLabel struct {
+	gorm.Model
+	UserID  uint   `json:"user_id" gorm:"uniqueIndex:idx_label_user;not null"`
+	LabelID string `json:"label_id" gorm:"type:varchar(50);uniqueIndex:idx_label_user;not null"` // ID used by WhatsApp
+	Name    string `json:"name" gorm:"type:varchar(255)"`
+	Color   int32  `json:"color"` // Index into WhatsApp's label palette
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// WhatsAppLabelAssociation attaches a label to a chat, or to one message when MessageID is set
+type WhatsAppLabelAssociation struct {
+	gorm.Model
+	UserID    uint   `json:"user_id" gorm:"uniqueIndex:idx_label_association;not null"`
+	LabelID   string `json:"label_id" gorm:"type:varchar(50);uniqueIndex:idx_label_association;not null"`
+	ChatJID   string `json:"chat_jid" gorm:"type:varchar(255);uniqueIndex:idx_label_association;not null"`
+	MessageID string `json:"message_id,omitempty" gorm:"type:varchar(255);uniqueIndex:idx_label_association"`
+}
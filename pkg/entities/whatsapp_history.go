@@ -0,0 +1,26 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatsAppHistorySync tracks the import of one history sync chunk sent by the phone
+type WhatsAppHistorySync struct {
+	gorm.Model
+	UserID        uint       `json:"user_id" gorm:"uniqueIndex:idx_history_chunk;not null"`
+	SyncType      string     `json:"sync_type" gorm:"type:varchar(50);uniqueIndex:idx_history_chunk"` // INITIAL_BOOTSTRAP, RECENT, FULL, ...
+	ChunkOrder    uint32     `json:"chunk_order" gorm:"uniqueIndex:idx_history_chunk"`
+	Progress      uint32     `json:"progress"` // Percentage of the whole sync reported by the phone
+	Conversations int        `json:"conversations"`
+	Imported      int        `json:"imported"`
+	Duplicates    int        `json:"duplicates"` // Already stored from a live event or an earlier chunk
+	Skipped       int        `json:"skipped"`    // Older than the configured limit, not parseable or in a skipped chunk
+	Status        string     `json:"status" gorm:"type:varchar(50)"`
+	Error         string     `json:"error,omitempty" gorm:"type:text"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
@@ -0,0 +1,17 @@
+package entities
+
+import "time"
+
+// WhatsAppIdempotencyKey remembers the outcome of a send made with an Idempotency-Key header
+type WhatsAppIdempotencyKey struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	UserID      uint      `json:"user_id" gorm:"uniqueIndex:idx_idempotency_user_key;not null"`
+	Key         string    `json:"key" gorm:"column:idempotency_key;type:varchar(255);uniqueIndex:idx_idempotency_user_key;not null"`
+	Endpoint    string    `json:"endpoint" gorm:"type:varchar(100)"`
+	Fingerprint string    `json:"fingerprint" gorm:"type:varchar(64)"` // SHA-256 of the request payload
+	Status      string    `json:"status" gorm:"type:varchar(50)"`
+	Response    string    `json:"response" gorm:"type:text"` // JSON encoded MessageResponseDTO
+	ExpiresAt   time.Time `json:"expires_at" gorm:"index"`
+}
@@ -0,0 +1,16 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatsAppInboundSpill is a received message parked in Postgres while the session's inbound queue was full
+type WhatsAppInboundSpill struct {
+	gorm.Model
+	UserID     uint      `json:"user_id" gorm:"index;not null"`
+	Info       string    `json:"info" gorm:"type:text"` // JSON encoded types.MessageInfo
+	RawMessage []byte    `json:"-" gorm:"type:bytea"`   // Protobuf encoded message as received, before unwrapping
+	ReceivedAt time.Time `json:"received_at"`
+}
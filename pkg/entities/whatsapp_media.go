@@ -0,0 +1,28 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatsAppMedia is a reusable media library entry with its cached WhatsApp upload
+type WhatsAppMedia struct {
+	gorm.Model
+	UserID        uint      `json:"user_id" gorm:"index;not null"`
+	FileName      string    `json:"file_name" gorm:"type:varchar(255)"`
+	MimeType      string    `json:"mime_type" gorm:"type:varchar(255);not null"`
+	MediaType     string    `json:"media_type" gorm:"type:varchar(50);not null"`
+	FileLength    uint64    `json:"file_length"`
+	StoragePath   string    `json:"-" gorm:"type:text;not null"` // Original file, kept for re-uploads
+	URL           string    `json:"-" gorm:"type:text"`
+	DirectPath    string    `json:"-" gorm:"type:text"`
+	MediaKey      []byte    `json:"-" gorm:"type:bytea"`
+	FileSHA256    []byte    `json:"-" gorm:"type:bytea"`
+	FileEncSHA256 []byte    `json:"-" gorm:"type:bytea"`
+	UploadedAt    time.Time `json:"uploaded_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
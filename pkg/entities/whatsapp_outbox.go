@@ -0,0 +1,35 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatsAppOutboxMessage is an asynchronously queued send, delivered in FIFO order per chat
+type WhatsAppOutboxMessage struct {
+	gorm.Model
+	UserID        uint       `json:"user_id" gorm:"index:idx_outbox_user_chat;not null"`
+	ChatJID       string     `json:"chat_jid" gorm:"type:varchar(255);index:idx_outbox_user_chat;not null"`
+	PhoneNumber   string     `json:"phone_number" gorm:"type:varchar(32);not null"`
+	Message       string     `json:"message" gorm:"type:text"` // Text body, or caption of media
+	MediaID       uint       `json:"media_id,omitempty"`
+	StorageKey    string     `json:"-" gorm:"type:text"` // Blob holding an uploaded file until it is sent, when MediaID is zero
+	MimeType      string     `json:"mime_type,omitempty" gorm:"type:varchar(255)"`
+	FileName      string     `json:"file_name,omitempty" gorm:"type:varchar(255)"`
+	FileSize      int64      `json:"file_size,omitempty"`
+	ViewOnce      bool       `json:"view_once"`
+	Voice         bool       `json:"voice"`
+	Height        uint32     `json:"height,omitempty"`
+	Width         uint32     `json:"width,omitempty"`
+	Transactional bool       `json:"transactional"` // Skips pacing
+	Status        string     `json:"status" gorm:"type:varchar(50);index"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	LastError     string     `json:"last_error,omitempty" gorm:"type:text"`
+	MessageID     string     `json:"message_id,omitempty" gorm:"type:varchar(255)"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
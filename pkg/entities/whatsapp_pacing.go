@@ -0,0 +1,23 @@
+package entities
+
+import "gorm.io/gorm"
+
+// WhatsAppPacing overrides the default send pacing for one account and tracks its daily volume
+type WhatsAppPacing struct {
+	gorm.Model
+	UserID              uint   `json:"user_id" gorm:"uniqueIndex;not null"`
+	Enabled             bool   `json:"enabled"`
+	MinIntervalMs       int    `json:"min_interval_ms"`
+	RecipientIntervalMs int    `json:"recipient_interval_ms"`
+	JitterMs            int    `json:"jitter_ms"`
+	Typing              bool   `json:"typing"`
+	TypingMsPerChar     int    `json:"typing_ms_per_char"`
+	MaxTypingMs         int    `json:"max_typing_ms"`
+	DailyCap            int    `json:"daily_cap"`                        // 0 means unlimited
+	SentDay             string `json:"sent_day" gorm:"type:varchar(10)"` // UTC date SentToday refers to
+	SentToday           int    `json:"sent_today"`
+	CountersOnly        bool   `json:"-"` // Row only tracks volume; settings fall back to the configured defaults
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
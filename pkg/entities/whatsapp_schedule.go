@@ -0,0 +1,36 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatsAppScheduledMessage is a text or library media message sent at SendAt, optionally on a cron recurrence
+type WhatsAppScheduledMessage struct {
+	gorm.Model
+	UserID        uint       `json:"user_id" gorm:"index;not null"`
+	PhoneNumber   string     `json:"phone_number" gorm:"type:varchar(32);not null"`
+	Message       string     `json:"message" gorm:"type:text"` // Text body, or caption of media
+	MediaID       uint       `json:"media_id,omitempty"`
+	StorageKey    string     `json:"-" gorm:"type:text"` // Blob holding an uploaded file until it is sent, when MediaID is zero
+	MimeType      string     `json:"mime_type,omitempty" gorm:"type:varchar(255)"`
+	FileName      string     `json:"file_name,omitempty" gorm:"type:varchar(255)"`
+	FileSize      int64      `json:"file_size,omitempty"`
+	ViewOnce      bool       `json:"view_once"`
+	Voice         bool       `json:"voice"`
+	Height        uint32     `json:"height,omitempty"`
+	Width         uint32     `json:"width,omitempty"`
+	Transactional bool       `json:"transactional"`                 // Skips pacing
+	SendAt        time.Time  `json:"send_at" gorm:"index;not null"` // Next run in UTC
+	Timezone      string     `json:"timezone" gorm:"type:varchar(64)"`
+	Recurrence    string     `json:"recurrence,omitempty" gorm:"type:varchar(100)"`
+	Status        string     `json:"status" gorm:"type:varchar(50);index"`
+	RunCount      int        `json:"run_count"`
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`
+	LastMessageID string     `json:"last_message_id,omitempty" gorm:"type:varchar(255)"`
+	Error         string     `json:"error,omitempty" gorm:"type:text"`
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
@@ -37,15 +37,57 @@ type WhatsAppDevice struct {
 // WhatsAppMessage stores WhatsApp message logs
 type WhatsAppMessage struct {
 	gorm.Model
-	UserID      uint      `json:"user_id" gorm:"not null"`
-	MessageID   string    `json:"message_id" gorm:"type:varchar(255);not null"`
-	FromJID     string    `json:"from_jid" gorm:"type:varchar(255);not null"`
-	ToJID       string    `json:"to_jid" gorm:"type:varchar(255);not null"`
-	Content     string    `json:"content" gorm:"type:text"`
-	MessageType string    `json:"message_type" gorm:"type:varchar(50)"`
-	Timestamp   time.Time `json:"timestamp"`
-	IsIncoming  bool      `json:"is_incoming" gorm:"default:false"`
-	
+	UserID          uint       `json:"user_id" gorm:"uniqueIndex:idx_message_key;not null"`
+	MessageID       string     `json:"message_id" gorm:"type:varchar(255);uniqueIndex:idx_message_key;not null"`
+	ChatJID         string     `json:"chat_jid" gorm:"type:varchar(255);uniqueIndex:idx_message_key;index"`
+	FromJID         string     `json:"from_jid" gorm:"type:varchar(255);not null"`
+	ToJID           string     `json:"to_jid" gorm:"type:varchar(255);not null"`
+	Content         string     `json:"content" gorm:"type:text"`
+	MessageType     string     `json:"message_type" gorm:"type:varchar(50)"`
+	Timestamp       time.Time  `json:"timestamp"`
+	IsIncoming      bool       `json:"is_incoming" gorm:"default:false"`
+	Starred         bool       `json:"starred"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty" gorm:"index"`        // Set for disappearing messages
+	ReadAt          *time.Time `json:"read_at,omitempty"`                        // When an inbound message was marked as read
+	ForwardingScore uint32     `json:"forwarding_score,omitempty"`               // How often the content was forwarded before
+	ForwardedFromID *uint      `json:"forwarded_from_id,omitempty" gorm:"index"` // Stored message this one forwards
+	Imported        bool       `json:"imported"`                                 // Came from a history sync or a chat export import rather than a live event
+	EditedAt        *time.Time `json:"edited_at,omitempty"`                      // Set when the sender edited the text
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`                     // Set when the sender deleted it for everyone
+
+	// Full-text search; search_vector is maintained by a database trigger on insert and edit
+	SearchLanguage string `json:"-" gorm:"type:varchar(50)"` // Text search configuration, e.g. english or simple
+	SearchVector   string `json:"-" gorm:"->;type:tsvector;index:idx_message_search,type:gin"`
+
 	// Relations
 	User User `json:"user" gorm:"foreignKey:UserID"`
 }
+
+// WhatsAppMessageMedia stores the attachment of a message and where its decrypted copy lives
+type WhatsAppMessageMedia struct {
+	gorm.Model
+	UserID          uint   `json:"user_id" gorm:"index;not null"`
+	MessageRecordID uint   `json:"message_record_id" gorm:"index;not null"`
+	MessageID       string `json:"message_id" gorm:"type:varchar(255);not null"`
+	Kind            string `json:"kind" gorm:"type:varchar(50)"` // image, video, audio, document or sticker
+	MimeType        string `json:"mime_type" gorm:"type:varchar(255)"`
+	FileName        string `json:"file_name" gorm:"type:varchar(255)"`
+	FileLength      uint64 `json:"file_length"`
+	StorageKey      string `json:"-" gorm:"type:text"`
+	Status          string `json:"status" gorm:"type:varchar(50);default:'pending'"`
+	Error           string `json:"error,omitempty" gorm:"type:text"`
+
+	// Media retry state for attachments that expired on the WhatsApp CDN
+	RetryCount       int        `json:"retry_count" gorm:"default:0"`
+	RetryRequestedAt *time.Time `json:"retry_requested_at,omitempty"`
+
+	// Download parameters, kept so the attachment can be fetched again later
+	MediaType     string `json:"-" gorm:"type:varchar(100)"`
+	DirectPath    string `json:"-" gorm:"type:text"`
+	MediaKey      []byte `json:"-" gorm:"type:bytea"`
+	FileSHA256    []byte `json:"-" gorm:"type:bytea"`
+	FileEncSHA256 []byte `json:"-" gorm:"type:bytea"`
+
+	// Relations
+	Message WhatsAppMessage `json:"-" gorm:"foreignKey:MessageRecordID"`
+}
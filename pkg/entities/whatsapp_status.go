@@ -0,0 +1,37 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatsAppStatusPost is a Status update posted by the account to status@broadcast
+type WhatsAppStatusPost struct {
+	gorm.Model
+	UserID          uint      `json:"user_id" gorm:"index;not null"`
+	MessageID       string    `json:"message_id" gorm:"type:varchar(255);index"`
+	Kind            string    `json:"kind" gorm:"type:varchar(50)"` // text, image, video or audio
+	Content         string    `json:"content" gorm:"type:text"`     // Text, or the caption of media posts
+	BackgroundColor string    `json:"background_color,omitempty" gorm:"type:varchar(9)"`
+	Font            int       `json:"font,omitempty"`
+	MediaID         uint      `json:"media_id,omitempty"`               // Library entry of media posts
+	Audience        string    `json:"audience" gorm:"type:varchar(20)"` // Status privacy the post went out with
+	ViewCount       int       `json:"view_count" gorm:"-"`              // Filled when listing
+	PostedAt        time.Time `json:"posted_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// WhatsAppStatusView records that a contact viewed a status post
+type WhatsAppStatusView struct {
+	gorm.Model
+	StatusPostID uint      `json:"status_post_id" gorm:"uniqueIndex:idx_status_view;not null"`
+	ViewerJID    string    `json:"viewer_jid" gorm:"type:varchar(255);uniqueIndex:idx_status_view;not null"`
+	ViewedAt     time.Time `json:"viewed_at"`
+
+	// Relations
+	Post WhatsAppStatusPost `json:"-" gorm:"foreignKey:StatusPostID"`
+}
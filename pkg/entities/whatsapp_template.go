@@ -0,0 +1,25 @@
+package entities
+
+import "gorm.io/gorm"
+
+// WhatsAppTemplate is a reusable text/template message with declared variables
+type WhatsAppTemplate struct {
+	gorm.Model
+	UserID    uint     `json:"user_id" gorm:"index;not null"`
+	Name      string   `json:"name" gorm:"type:varchar(100);not null"`
+	Language  string   `json:"language" gorm:"type:varchar(20)"` // Language of Body, used when no variant matches
+	Body      string   `json:"body" gorm:"type:text;not null"`
+	Variables []string `json:"variables" gorm:"type:text;serializer:json"` // Names usable as {{.name}}
+
+	// Relations
+	User     User                      `json:"-" gorm:"foreignKey:UserID"`
+	Variants []WhatsAppTemplateVariant `json:"variants" gorm:"foreignKey:TemplateID"`
+}
+
+// WhatsAppTemplateVariant is a translation of a template body
+type WhatsAppTemplateVariant struct {
+	gorm.Model
+	TemplateID uint   `json:"template_id" gorm:"index;not null"`
+	Language   string `json:"language" gorm:"type:varchar(20);not null"`
+	Body       string `json:"body" gorm:"type:text;not null"`
+}
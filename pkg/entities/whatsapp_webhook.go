@@ -0,0 +1,43 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WhatsAppWebhook is a user's subscription to event notifications
+type WhatsAppWebhook struct {
+	gorm.Model
+	UserID      uint     `json:"user_id" gorm:"index;not null"`
+	URL         string   `json:"url" gorm:"type:text;not null"`
+	Secret      string   `json:"-" gorm:"type:varchar(255);not null"`     // HMAC-SHA256 key of the payload signatures
+	Events      []string `json:"events" gorm:"serializer:json;type:text"` // Event types to deliver, empty for all
+	Active      bool     `json:"active"`
+	Description string   `json:"description" gorm:"type:varchar(255)"`
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// WhatsAppWebhookDelivery is one event sent, or to be sent, to a webhook, with the outcome of its last attempt
+type WhatsAppWebhookDelivery struct {
+	gorm.Model
+	WebhookID      uint       `json:"webhook_id" gorm:"index;not null"`
+	UserID         uint       `json:"user_id" gorm:"index;not null"`
+	EventID        string     `json:"event_id" gorm:"type:varchar(64);index"` // Shared by every delivery of the same event
+	EventType      string     `json:"event_type" gorm:"type:varchar(50)"`
+	Payload        string     `json:"payload" gorm:"type:text"`
+	Status         string     `json:"status" gorm:"type:varchar(50);index"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" gorm:"index"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+	ResponseBody   string     `json:"response_body,omitempty" gorm:"type:text"` // First KB of the last response
+	LastError      string     `json:"last_error,omitempty" gorm:"type:text"`
+	DurationMs     int64      `json:"duration_ms"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	RedeliveryOf   *uint      `json:"redelivery_of,omitempty"` // Delivery this one was manually re-sent from
+
+	// Relations
+	Webhook WhatsAppWebhook `json:"-" gorm:"foreignKey:WebhookID"`
+}
@@ -77,3 +77,17 @@ func CheckAuth() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// CheckStreamAuth is CheckAuth for EventSource and WebSocket clients, which cannot set
+// headers; they may pass the token as the access_token query parameter instead
+func CheckStreamAuth() gin.HandlerFunc {
+	checkAuth := CheckAuth()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("access_token"); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		checkAuth(c)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/crm/pkg/domains/auth"
 	"github.com/crm/pkg/domains/whatsapp"
 	"github.com/crm/pkg/middleware"
+	"github.com/crm/pkg/storage"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -22,7 +23,7 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func LaunchHttpServer(appc config.App, allows config.Allows) {
+func LaunchHttpServer(appc config.App, allows config.Allows, wac config.WhatsApp, sc config.Storage) {
 	log.Println("Starting HTTP Server...")
 	gin.SetMode(gin.DebugMode)
 
@@ -44,7 +45,7 @@ func LaunchHttpServer(appc config.App, allows config.Allows) {
 	app.Use(middleware.ClaimIp())
 	app.Use(cors.New(cors.Config{
 		AllowMethods:     []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodOptions},
-		AllowHeaders:     []string{"Content-Type", "Authorization", "X-Requested-With", "Origin", "Accept"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "X-Requested-With", "Origin", "Accept", "Idempotency-Key"},
 		AllowOrigins:     []string{"*"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
@@ -67,7 +68,11 @@ func LaunchHttpServer(appc config.App, allows config.Allows) {
 	routes.AuthRoutes(api.Group("/auth"), auth_service)
 
 	// WhatsApp Routes
-	whatsapp_service := whatsapp.NewService()
+	blob_store, err := storage.New(sc)
+	if err != nil {
+		log.Fatalf("Storage başlatilamadi: %v", err)
+	}
+	whatsapp_service := whatsapp.NewService(wac, blob_store)
 	routes.WhatsAppRoutes(api.Group("/whatsapp"), whatsapp_service)
 
 	fmt.Println("Server is running on port " + appc.Port)
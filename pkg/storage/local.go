@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localStore struct {
+	root string
+}
+
+// NewLocalStore keeps blobs as files below root
+func NewLocalStore(root string) (BlobStore, error) {
+	if root == "" {
+		root = "./media_store"
+	}
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %v", err)
+	}
+	return &localStore{root: root}, nil
+}
+
+// path resolves key inside root, refusing keys that would escape it
+func (l *localStore) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(l.root, cleaned), nil
+}
+
+func (l *localStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return fmt.Errorf("failed to create storage directory: %v", err)
+	}
+
+	// Write to a sibling temp file first so readers never see a partial blob
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %v", err)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write blob: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write blob: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store blob: %v", err)
+	}
+	return nil
+}
+
+func (l *localStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	target, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(target)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %v", err)
+	}
+	return file, nil
+}
+
+func (l *localStore) Delete(ctx context.Context, key string) error {
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete blob: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocalStore(root)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		key  string
+		file string // Where the blob is expected below root
+	}{
+		{name: "flat key", key: "photo.jpg", file: "photo.jpg"},
+		{name: "nested key", key: "inbound/1/ABC123.jpg", file: "inbound/1/ABC123.jpg"},
+		{name: "leading slash stays inside root", key: "/inbound/2/x.pdf", file: "inbound/2/x.pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.Put(ctx, tt.key, strings.NewReader("first"), 5, "text/plain"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if err := store.Put(ctx, tt.key, strings.NewReader("second"), 6, "text/plain"); err != nil {
+				t.Fatalf("Put over an existing blob: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(root, tt.file)); err != nil {
+				t.Fatalf("blob not stored at %s: %v", tt.file, err)
+			}
+
+			r, err := store.Get(ctx, tt.key)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			body, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || string(body) != "second" {
+				t.Fatalf("Get = %q, %v; want %q", body, err, "second")
+			}
+
+			if err := store.Delete(ctx, tt.key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := store.Get(ctx, tt.key); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get after Delete error = %v, want ErrNotFound", err)
+			}
+			if err := store.Delete(ctx, tt.key); err != nil {
+				t.Fatalf("Delete of a missing blob: %v", err)
+			}
+		})
+	}
+}
+
+func TestLocalStoreRejectsInvalidKeys(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"", "/", "../outside", "inbound/../../outside", "inbound/.."} {
+		t.Run(key, func(t *testing.T) {
+			if err := store.Put(ctx, key, strings.NewReader("x"), 1, ""); err == nil {
+				t.Fatalf("Put(%q) succeeded, want error", key)
+			}
+			if _, err := store.Get(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get(%q) error = %v, want invalid key", key, err)
+			}
+			if err := store.Delete(ctx, key); err == nil {
+				t.Fatalf("Delete(%q) succeeded, want error", key)
+			}
+		})
+	}
+}
+
+func TestLocalStoreFailedPutLeavesNoBlob(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocalStore(root)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	ctx := context.Background()
+
+	failing := io.MultiReader(strings.NewReader("partial"), errReader{})
+	if err := store.Put(ctx, "inbound/broken.bin", failing, 100, ""); err == nil {
+		t.Fatalf("Put succeeded, want error")
+	}
+	if _, err := store.Get(ctx, "inbound/broken.bin"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get error = %v, want ErrNotFound", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "inbound"))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("temp files left behind: %v", entries)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
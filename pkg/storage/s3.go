@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crm/pkg/config"
+)
+
+// unsignedPayload lets streamed uploads skip hashing the body; S3 and MinIO both accept it
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// s3Store talks to any S3-compatible endpoint (AWS, MinIO, R2, ...) using path-style requests
+// signed with AWS Signature Version 4.
+type s3Store struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// NewS3Store creates a store for the configured bucket
+func NewS3Store(sc config.S3) (BlobStore, error) {
+	if sc.Bucket == "" || sc.AccessKey == "" || sc.SecretKey == "" {
+		return nil, fmt.Errorf("s3 storage requires bucket, access_key and secret_key")
+	}
+
+	endpoint := sc.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+
+	region := sc.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &s3Store{
+		endpoint:  parsed,
+		region:    region,
+		bucket:    sc.Bucket,
+		accessKey: sc.AccessKey,
+		secretKey: sc.SecretKey,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload blob: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return s.responseError("upload", resp)
+	}
+	return nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob: %v", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, s.responseError("download", resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete blob: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return s.responseError("delete", resp)
+	}
+	return nil
+}
+
+func (s *s3Store) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	if key == "" {
+		return nil, fmt.Errorf("invalid storage key %q", key)
+	}
+
+	target := *s.endpoint
+	target.Path = strings.TrimSuffix(s.endpoint.Path, "/") + "/" + s.bucket + "/" + strings.TrimPrefix(key, "/")
+	target.RawPath = uriEncode(target.Path)
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build storage request: %v", err)
+	}
+	return req, nil
+}
+
+// sign adds SigV4 authentication headers to req, dated now
+func (s *s3Store) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", unsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + unsignedPayload + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey(s.secretKey, day, s.region, "s3"), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func (s *s3Store) responseError(action string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("failed to %s blob: %s: %s", action, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// signingKey derives the SigV4 key for one day, region and service
+func signingKey(secret, day, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode escapes everything except unreserved characters and path separators, as SigV4 requires
+func uriEncode(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
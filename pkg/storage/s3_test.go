@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crm/pkg/config"
+)
+
+func TestSigningKey(t *testing.T) {
+	// Example from the AWS Signature Version 4 documentation
+	got := hex.EncodeToString(signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam"))
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if got != want {
+		t.Fatalf("signingKey = %s, want %s", got, want)
+	}
+}
+
+func TestURIEncode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "/bucket/key.jpg", want: "/bucket/key.jpg"},
+		{in: "/bucket/a b.jpg", want: "/bucket/a%20b.jpg"},
+		{in: "/bucket/a+b=c&d", want: "/bucket/a%2Bb%3Dc%26d"},
+		{in: "/bucket/-_.~", want: "/bucket/-_.~"},
+		{in: "/bucket/é", want: "/bucket/%C3%A9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := uriEncode(tt.in); got != tt.want {
+				t.Fatalf("uriEncode(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3Sign(t *testing.T) {
+	blobs, err := NewS3Store(config.S3{
+		Endpoint:  "http://minio.local:9000",
+		Region:    "eu-west-1",
+		Bucket:    "media",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	store := blobs.(*s3Store)
+
+	tests := []struct {
+		name   string
+		method string
+		key    string
+		path   string // Expected canonical URI
+	}{
+		{name: "upload", method: http.MethodPut, key: "inbound/1/photo.jpg", path: "/media/inbound/1/photo.jpg"},
+		{name: "escaped key", method: http.MethodGet, key: "/inbound/1/a b+c.jpg", path: "/media/inbound/1/a%20b%2Bc.jpg"},
+		{name: "delete", method: http.MethodDelete, key: "x", path: "/media/x"},
+	}
+
+	signedAt := time.Date(2024, 5, 1, 14, 30, 45, 0, time.FixedZone("CEST", 2*60*60))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := store.newRequest(context.Background(), tt.method, tt.key, nil)
+			if err != nil {
+				t.Fatalf("newRequest: %v", err)
+			}
+			store.sign(req, signedAt)
+
+			if got := req.Header.Get("x-amz-date"); got != "20240501T123045Z" {
+				t.Fatalf("x-amz-date = %q, want the UTC signing time", got)
+			}
+			if got := req.Header.Get("x-amz-content-sha256"); got != unsignedPayload {
+				t.Fatalf("x-amz-content-sha256 = %q, want %q", got, unsignedPayload)
+			}
+
+			canonicalRequest := tt.method + "\n" +
+				tt.path + "\n" +
+				"\n" +
+				"host:minio.local:9000\n" +
+				"x-amz-content-sha256:UNSIGNED-PAYLOAD\n" +
+				"x-amz-date:20240501T123045Z\n" +
+				"\n" +
+				"host;x-amz-content-sha256;x-amz-date\n" +
+				"UNSIGNED-PAYLOAD"
+			hashed := sha256.Sum256([]byte(canonicalRequest))
+			stringToSign := "AWS4-HMAC-SHA256\n20240501T123045Z\n20240501/eu-west-1/s3/aws4_request\n" + hex.EncodeToString(hashed[:])
+			signature := hex.EncodeToString(hmacSHA256(signingKey("secret", "20240501", "eu-west-1", "s3"), stringToSign))
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240501/eu-west-1/s3/aws4_request, " +
+				"SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" + signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Fatalf("Authorization = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestS3StoreRoundTrip(t *testing.T) {
+	var (
+		mutex   sync.Mutex
+		objects = map[string]string{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			http.Error(w, "AccessDenied", http.StatusForbidden)
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(body)
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, body)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewS3Store(config.S3{Endpoint: server.URL, Bucket: "media", AccessKey: "AKIDEXAMPLE", SecretKey: "secret"})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "inbound/1/a b.txt", strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := objects["/media/inbound/1/a b.txt"]; !ok {
+		t.Fatalf("object stored under unexpected path: %v", objects)
+	}
+
+	r, err := store.Get(ctx, "inbound/1/a b.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	body, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("Get = %q, %v; want %q", body, err, "hello")
+	}
+
+	if err := store.Delete(ctx, "inbound/1/a b.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, "inbound/1/a b.txt"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "inbound/1/a b.txt"); err != nil {
+		t.Fatalf("Delete of a missing object: %v", err)
+	}
+}
+
+func TestNewS3StoreRequiresCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		sc   config.S3
+	}{
+		{name: "no bucket", sc: config.S3{AccessKey: "a", SecretKey: "s"}},
+		{name: "no access key", sc: config.S3{Bucket: "b", SecretKey: "s"}},
+		{name: "no secret key", sc: config.S3{Bucket: "b", AccessKey: "a"}},
+		{name: "bad endpoint", sc: config.S3{Endpoint: "not a url", Bucket: "b", AccessKey: "a", SecretKey: "s"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewS3Store(tt.sc); err == nil {
+				t.Fatalf("NewS3Store succeeded, want error")
+			}
+		})
+	}
+}
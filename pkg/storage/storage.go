@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/crm/pkg/config"
+)
+
+// ErrNotFound is returned by Get when the key does not exist in the store
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores opaque binary objects such as downloaded WhatsApp media
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the blob store selected by the storage driver setting
+func New(sc config.Storage) (BlobStore, error) {
+	switch sc.Driver {
+	case "", "local":
+		return NewLocalStore(sc.LocalDir)
+	case "s3":
+		return NewS3Store(sc.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", sc.Driver)
+	}
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed five-field cron expression: minute hour day-of-month month day-of-week
+type CronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+// ParseCron parses expressions such as "0 9 * * 1-5" or "*/15 8-18 * * *"
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(fields))
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := make([]map[int]bool, 5)
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron field %q: %v", field, err)
+		}
+		sets[i] = set
+	}
+
+	// Both 0 and 7 mean Sunday
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+
+	return &CronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step")
+			}
+			part = part[:idx]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range")
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value")
+			}
+			lo, hi = value, value
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("value out of range %d-%d", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// Next returns the first matching minute strictly after t, evaluated in t's location.
+// Times skipped when clocks go forward run at the first instant after the jump; times
+// repeated when clocks go back run once.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	after := wallClock(t)
+	next := t.Truncate(time.Minute).Add(time.Minute)
+
+	// Five years covers every valid expression, including Feb 29
+	limit := next.AddDate(5, 0, 0)
+	for next.Before(limit) {
+		var following time.Time
+		switch {
+		case !wallClock(next).After(after):
+			// Already considered before the clocks went back
+			following = next.Add(time.Minute)
+		case !c.month[int(next.Month())]:
+			following = startOf(next.Year(), next.Month()+1, 1, 0, next.Location())
+		case !c.dayMatches(next):
+			following = startOf(next.Year(), next.Month(), next.Day()+1, 0, next.Location())
+		case !c.hour[next.Hour()]:
+			following = startOf(next.Year(), next.Month(), next.Day(), next.Hour()+1, next.Location())
+		case !c.minute[next.Minute()]:
+			following = next.Add(time.Minute)
+		default:
+			return next
+		}
+
+		if c.matchesGap(next, following) {
+			return following
+		}
+		next = following
+	}
+	return time.Time{}
+}
+
+// startOf returns the first instant at or after the given local hour. time.Date may
+// resolve an hour skipped by clocks going forward to an earlier instant, which would
+// stop Next from advancing.
+func startOf(year int, month time.Month, day, hour int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, 0, 0, 0, loc)
+	if gap := time.Date(year, month, day, hour, 0, 0, 0, time.UTC).Sub(wallClock(t)); gap > 0 {
+		t = t.Add(gap)
+	}
+	return t
+}
+
+// wallClock returns the local date and time of t without its zone, for comparing
+// times across DST changes
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// matchesGap reports whether a local time that never existed between from and to,
+// because clocks went forward, would have matched
+func (c *CronSchedule) matchesGap(from, to time.Time) bool {
+	start := wallClock(from).Add(to.Sub(from))
+	for missing := start; missing.Before(wallClock(to)); missing = missing.Add(time.Minute) {
+		if c.month[int(missing.Month())] && c.dayMatches(missing) && c.hour[missing.Hour()] && c.minute[missing.Minute()] {
+			return true
+		}
+	}
+	return false
+}
+
+// dayMatches follows cron semantics: when both day fields are restricted, either may match
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom[t.Day()]
+	dowMatch := c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dowMatch
+	case c.dowAny:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "every minute", expr: "* * * * *"},
+		{name: "weekdays at nine", expr: "0 9 * * 1-5"},
+		{name: "steps and ranges", expr: "*/15 8-18 * * *"},
+		{name: "lists", expr: "0,30 9,17 1,15 * *"},
+		{name: "sunday as seven", expr: "0 0 * * 7"},
+		{name: "too few fields", expr: "* * * *", wantErr: true},
+		{name: "too many fields", expr: "* * * * * *", wantErr: true},
+		{name: "minute out of range", expr: "60 * * * *", wantErr: true},
+		{name: "hour out of range", expr: "0 24 * * *", wantErr: true},
+		{name: "day of month zero", expr: "0 0 0 * *", wantErr: true},
+		{name: "month out of range", expr: "0 0 1 13 *", wantErr: true},
+		{name: "day of week out of range", expr: "0 0 * * 8", wantErr: true},
+		{name: "reversed range", expr: "0 18-8 * * *", wantErr: true},
+		{name: "zero step", expr: "*/0 * * * *", wantErr: true},
+		{name: "not a number", expr: "a * * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCron(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "strictly after the given time",
+			expr: "30 9 * * *",
+			from: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC),
+			want: time.Date(2024, 5, 2, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "seconds are truncated",
+			expr: "* * * * *",
+			from: time.Date(2024, 5, 1, 9, 30, 45, 0, time.UTC),
+			want: time.Date(2024, 5, 1, 9, 31, 0, 0, time.UTC),
+		},
+		{
+			name: "steps within an hour range",
+			expr: "*/15 8-18 * * *",
+			from: time.Date(2024, 5, 1, 18, 45, 0, 0, time.UTC),
+			want: time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekdays skip the weekend",
+			expr: "0 9 * * 1-5",
+			from: time.Date(2024, 5, 3, 10, 0, 0, 0, time.UTC), // Friday
+			want: time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "seven means sunday",
+			expr: "0 0 * * 7",
+			from: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), // Wednesday
+			want: time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week, day of week first",
+			expr: "0 12 15 * 1",
+			from: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), // Wednesday
+			want: time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week, day of month first",
+			expr: "0 12 15 * 1",
+			from: time.Date(2024, 5, 13, 13, 0, 0, 0, time.UTC), // Monday
+			want: time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "restricted day of month alone",
+			expr: "0 12 31 * *",
+			from: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "local time across spring forward",
+			expr: "0 9 * * *",
+			from: time.Date(2024, 3, 9, 9, 0, 0, 0, newYork),
+			want: time.Date(2024, 3, 10, 9, 0, 0, 0, newYork),
+		},
+		{
+			name: "skipped time runs after the jump",
+			expr: "30 2 * * *",
+			from: time.Date(2024, 3, 10, 0, 0, 0, 0, newYork),
+			want: time.Date(2024, 3, 10, 3, 0, 0, 0, newYork),
+		},
+		{
+			name: "skipped time reached minute by minute runs after the jump",
+			expr: "30 1,2 * * *",
+			from: time.Date(2024, 3, 10, 1, 30, 0, 0, newYork),
+			want: time.Date(2024, 3, 10, 3, 0, 0, 0, newYork),
+		},
+		{
+			name: "repeated time runs once",
+			expr: "30 1 * * *",
+			from: time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC).In(newYork), // 01:30 EDT
+			want: time.Date(2024, 11, 4, 1, 30, 0, 0, newYork),
+		},
+		{
+			name: "repeated hour still runs later minutes",
+			expr: "0 2 * * *",
+			from: time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC).In(newYork), // 01:30 EDT
+			want: time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC).In(newYork),  // 02:00 EST
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Fatalf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNextNever(t *testing.T) {
+	schedule, err := ParseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatalf("ParseCron: %v", err)
+	}
+	if got := schedule.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Fatalf("Next = %v, want zero time", got)
+	}
+}